@@ -1,21 +1,33 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/emyu/ecommer-be/config"
 	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/mailer"
+	"github.com/emyu/ecommer-be/payment"
 	"github.com/emyu/ecommer-be/routes"
+	"github.com/emyu/ecommer-be/services"
+	"github.com/emyu/ecommer-be/utils"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
 	// Define flags
 	seedFlag := flag.Bool("seed", false, "Seed the database with sample data")
+	routesFlag := flag.Bool("routes", false, "Print the route -> permission table and exit")
 	flag.Parse()
 
+	if *routesFlag {
+		routes.PrintPermissionRoutes(os.Stdout)
+		return
+	}
+
 	// Load config
 	if err := config.LoadConfig(); err != nil {
 		log.Fatal("Failed to load config:", err)
@@ -36,12 +48,36 @@ func main() {
 		return
 	}
 
+	// Initialize payment providers
+	if err := payment.InitProviders(); err != nil {
+		log.Fatal("Failed to initialize payment providers:", err)
+	}
+
+	// Hash the password that logins for unknown emails are checked against
+	utils.PrepareDummyHash()
+
+	// Initialize mailer
+	if err := mailer.Init(); err != nil {
+		log.Fatal("Failed to initialize mailer:", err)
+	}
+
+	// Start background workers
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go services.StartPaymentExpiryWorker(ctx)
+	go services.StartOutboxWorker(ctx)
+
 	// Setup Gin router
 	if config.AppConfig.Env == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
 	router := gin.Default()
+	// Without trusted proxies, c.ClientIP() is the TCP peer address and
+	// forwarded headers cannot spoof it.
+	if err := router.SetTrustedProxies(config.AppConfig.TrustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
 
 	// Setup routes
 	routes.SetupRoutes(router)
@@ -50,7 +86,7 @@ func main() {
 	addr := fmt.Sprintf(":%d", config.AppConfig.Port)
 	log.Printf("🚀 Server running on http://localhost%s\n", addr)
 	log.Printf("📱 API Documentation: Postman collection at /docs\n")
-	log.Printf("🔒 Auth: JWT Bearer Token (24 hours expiry)\n")
+	log.Printf("🔒 Auth: JWT Bearer Token (%s expiry) + refresh tokens\n", config.AppConfig.AccessTokenTTL)
 
 	if err := router.Run(addr); err != nil {
 		log.Fatal("Failed to start server:", err)
@@ -4,20 +4,112 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+	// Embedded zone data, so PAYMENT_GATEWAY_TIMEZONE also resolves on
+	// hosts without a tz database.
+	_ "time/tzdata"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	DBHost   string
-	DBPort   int
-	DBUser   string
-	DBPass   string
-	DBName   string
-	Port     int
-	Env      string
-	JWTKey   string
-	AppName  string
+	DBHost  string
+	DBPort  int
+	DBUser  string
+	DBPass  string
+	DBName  string
+	Port    int
+	Env     string
+	JWTKey  string
+	AppName string
+
+	// Proxies whose X-Forwarded-For / X-Real-IP headers are believed when
+	// resolving the client IP. Empty trusts none.
+	TrustedProxies []string
+
+	// Sessions
+	AccessTokenTTL     time.Duration
+	RefreshTokenTTL    time.Duration
+	PermissionCacheTTL time.Duration
+
+	// Mail
+	SMTPHost          string
+	SMTPPort          int
+	SMTPUsername      string
+	SMTPPassword      string
+	MailFrom          string
+	OutboxInterval    time.Duration
+	OutboxMaxAttempts int
+
+	// Password hashing and policy
+	PasswordHasher        string
+	Argon2Memory          int
+	Argon2Iterations      int
+	Argon2Parallelism     int
+	Argon2MaxConcurrent   int
+	BcryptCost            int
+	PasswordMinLength     int
+	PasswordMaxLength     int
+	BreachedPasswordsFile string
+
+	// Password reset
+	PasswordResetURL      string
+	PasswordResetTTL      time.Duration
+	PasswordResetCooldown time.Duration
+
+	// Email verification
+	EmailVerificationURL       string
+	EmailVerificationTTL       time.Duration
+	VerificationResendCooldown time.Duration
+	RequireVerifiedEmail       bool
+
+	// Login protection
+	LoginMaxFailures     int
+	LoginLockoutDuration time.Duration
+	LoginDelayBase       time.Duration
+	LoginIPMaxFailures   int
+	LoginIPWindow        time.Duration
+
+	// Two-factor authentication
+	TwoFactorIssuer       string
+	TwoFactorChallengeTTL time.Duration
+	TwoFactorEnforced     bool
+
+	// Shipping
+	ShippingFlatRate    float64
+	FreeShippingMinimum float64
+
+	// Seller payouts
+	CommissionPercent   float64
+	PayoutMinimumAmount float64
+
+	// Payments
+	PaymentProvider         string
+	PaymentChannelProviders map[string]string
+	PaymentGatewayName      string
+	PaymentGatewayURL       string
+	PaymentGatewayKey       string
+	PaymentGatewayLocation  *time.Location // time zone of the gateway's timestamps
+	PaymentTTL              time.Duration
+	PaymentWebhookSecrets   map[string]string
+	PaymentExpiryInterval   time.Duration
+	UnpaidOrderTTL          time.Duration
+
+	// QRIS merchant
+	QRISMerchantName   string
+	QRISMerchantCity   string
+	QRISPostalCode     string
+	QRISAcquirerDomain string
+	QRISMerchantPAN    string
+	QRISMerchantID     string
+	QRISNMID           string
+	QRISMCC            string
+	QRISCriteria       string
+
+	// Virtual accounts
+	VAPrefixes     map[string]string
+	VANumberLength int
 }
 
 var AppConfig Config
@@ -27,17 +119,159 @@ func LoadConfig() error {
 
 	port, _ := strconv.Atoi(getEnv("SERVER_PORT", "8080"))
 	dbPort, _ := strconv.Atoi(getEnv("DB_PORT", "3306"))
+	shippingFlatRate, _ := strconv.ParseFloat(getEnv("SHIPPING_FLAT_RATE", "15000"), 64)
+	freeShippingMinimum, _ := strconv.ParseFloat(getEnv("FREE_SHIPPING_MINIMUM", "0"), 64)
+	paymentTTL, _ := time.ParseDuration(getEnv("PAYMENT_TTL", "24h"))
+	vaNumberLength, _ := strconv.Atoi(getEnv("VA_NUMBER_LENGTH", "16"))
+	paymentExpiryInterval, _ := time.ParseDuration(getEnv("PAYMENT_EXPIRY_INTERVAL", "1m"))
+	unpaidOrderTTL, _ := time.ParseDuration(getEnv("UNPAID_ORDER_TTL", "24h"))
+	accessTokenTTL, _ := time.ParseDuration(getEnv("ACCESS_TOKEN_TTL", "15m"))
+	refreshTokenTTL, _ := time.ParseDuration(getEnv("REFRESH_TOKEN_TTL", "720h"))
+	permissionCacheTTL, _ := time.ParseDuration(getEnv("PERMISSION_CACHE_TTL", "30s"))
+	smtpPort, _ := strconv.Atoi(getEnv("SMTP_PORT", "1025"))
+	outboxInterval, _ := time.ParseDuration(getEnv("OUTBOX_INTERVAL", "10s"))
+	outboxMaxAttempts, _ := strconv.Atoi(getEnv("OUTBOX_MAX_ATTEMPTS", "5"))
+	// Out-of-range hashing parameters make argon2 panic on every login,
+	// so they are refused at startup.
+	passwordHasher := getEnv("PASSWORD_HASHER", "argon2id")
+	if passwordHasher != "argon2id" && passwordHasher != "bcrypt" {
+		return fmt.Errorf("PASSWORD_HASHER must be argon2id or bcrypt, got %q", passwordHasher)
+	}
+	argon2Parallelism, err := intEnvInRange("ARGON2_PARALLELISM", "2", 1, 255)
+	if err != nil {
+		return err
+	}
+	argon2Memory, err := intEnvInRange("ARGON2_MEMORY", "65536", 8*argon2Parallelism, 4*1024*1024)
+	if err != nil {
+		return err
+	}
+	argon2Iterations, err := intEnvInRange("ARGON2_ITERATIONS", "3", 1, 100)
+	if err != nil {
+		return err
+	}
+	argon2MaxConcurrent, err := intEnvInRange("ARGON2_MAX_CONCURRENT", "4", 1, 1024)
+	if err != nil {
+		return err
+	}
+	bcryptCost, err := intEnvInRange("BCRYPT_COST", "12", 4, 31)
+	if err != nil {
+		return err
+	}
+	passwordMinLength, err := intEnvInRange("PASSWORD_MIN_LENGTH", "10", 1, 1024)
+	if err != nil {
+		return err
+	}
+	passwordMaxLength, err := intEnvInRange("PASSWORD_MAX_LENGTH", "128", passwordMinLength, 1024)
+	if err != nil {
+		return err
+	}
+	paymentGatewayLocation, err := time.LoadLocation(getEnv("PAYMENT_GATEWAY_TIMEZONE", "Asia/Jakarta"))
+	if err != nil {
+		return fmt.Errorf("PAYMENT_GATEWAY_TIMEZONE: %w", err)
+	}
+	passwordResetTTL, _ := time.ParseDuration(getEnv("PASSWORD_RESET_TTL", "1h"))
+	passwordResetCooldown, _ := time.ParseDuration(getEnv("PASSWORD_RESET_COOLDOWN", "1m"))
+	emailVerificationTTL, _ := time.ParseDuration(getEnv("EMAIL_VERIFICATION_TTL", "48h"))
+	verificationResendCooldown, _ := time.ParseDuration(getEnv("VERIFICATION_RESEND_COOLDOWN", "1m"))
+	requireVerifiedEmail, _ := strconv.ParseBool(getEnv("REQUIRE_VERIFIED_EMAIL", "false"))
+	loginMaxFailures, _ := strconv.Atoi(getEnv("LOGIN_MAX_FAILURES", "5"))
+	loginLockoutDuration, _ := time.ParseDuration(getEnv("LOGIN_LOCKOUT_DURATION", "15m"))
+	loginDelayBase, _ := time.ParseDuration(getEnv("LOGIN_DELAY_BASE", "1s"))
+	loginIPMaxFailures, _ := strconv.Atoi(getEnv("LOGIN_IP_MAX_FAILURES", "20"))
+	loginIPWindow, _ := time.ParseDuration(getEnv("LOGIN_IP_WINDOW", "15m"))
+	twoFactorChallengeTTL, _ := time.ParseDuration(getEnv("TWO_FACTOR_CHALLENGE_TTL", "5m"))
+	twoFactorEnforced, _ := strconv.ParseBool(getEnv("TWO_FACTOR_ENFORCED", "false"))
+	commissionPercent, _ := strconv.ParseFloat(getEnv("COMMISSION_PERCENT", "10"), 64)
+	payoutMinimumAmount, _ := strconv.ParseFloat(getEnv("PAYOUT_MINIMUM_AMOUNT", "50000"), 64)
 
 	AppConfig = Config{
-		DBHost:   getEnv("DB_HOST", "127.0.0.1"),
-		DBPort:   dbPort,
-		DBUser:   getEnv("DB_USER", "root"),
-		DBPass:   getEnv("DB_PASSWORD", ""),
-		DBName:   getEnv("DB_NAME", "emyu"),
-		Port:     port,
-		Env:      getEnv("SERVER_ENV", "development"),
-		JWTKey:   getEnv("JWT_SECRET", "secret"),
-		AppName:  getEnv("APP_NAME", "Emyu E-Commerce API"),
+		DBHost:  getEnv("DB_HOST", "127.0.0.1"),
+		DBPort:  dbPort,
+		DBUser:  getEnv("DB_USER", "root"),
+		DBPass:  getEnv("DB_PASSWORD", ""),
+		DBName:  getEnv("DB_NAME", "emyu"),
+		Port:    port,
+		Env:     getEnv("SERVER_ENV", "development"),
+		JWTKey:  getEnv("JWT_SECRET", "secret"),
+		AppName: getEnv("APP_NAME", "Emyu E-Commerce API"),
+
+		TrustedProxies: parseList(getEnv("TRUSTED_PROXIES", "")),
+
+		AccessTokenTTL:     accessTokenTTL,
+		RefreshTokenTTL:    refreshTokenTTL,
+		PermissionCacheTTL: permissionCacheTTL,
+
+		SMTPHost:          getEnv("SMTP_HOST", ""),
+		SMTPPort:          smtpPort,
+		SMTPUsername:      getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:      getEnv("SMTP_PASSWORD", ""),
+		MailFrom:          getEnv("MAIL_FROM", "no-reply@emyu.local"),
+		OutboxInterval:    outboxInterval,
+		OutboxMaxAttempts: outboxMaxAttempts,
+
+		PasswordHasher:        passwordHasher,
+		Argon2Memory:          argon2Memory,
+		Argon2Iterations:      argon2Iterations,
+		Argon2Parallelism:     argon2Parallelism,
+		Argon2MaxConcurrent:   argon2MaxConcurrent,
+		BcryptCost:            bcryptCost,
+		PasswordMinLength:     passwordMinLength,
+		PasswordMaxLength:     passwordMaxLength,
+		BreachedPasswordsFile: getEnv("BREACHED_PASSWORDS_FILE", "data/breached-passwords.txt"),
+
+		PasswordResetURL:      getEnv("PASSWORD_RESET_URL", "http://localhost:3000/reset-password"),
+		PasswordResetTTL:      passwordResetTTL,
+		PasswordResetCooldown: passwordResetCooldown,
+
+		EmailVerificationURL:       getEnv("EMAIL_VERIFICATION_URL", "http://localhost:3000/verify-email"),
+		EmailVerificationTTL:       emailVerificationTTL,
+		VerificationResendCooldown: verificationResendCooldown,
+		RequireVerifiedEmail:       requireVerifiedEmail,
+
+		LoginMaxFailures:     loginMaxFailures,
+		LoginLockoutDuration: loginLockoutDuration,
+		LoginDelayBase:       loginDelayBase,
+		LoginIPMaxFailures:   loginIPMaxFailures,
+		LoginIPWindow:        loginIPWindow,
+
+		TwoFactorIssuer:       getEnv("TWO_FACTOR_ISSUER", ""),
+		TwoFactorChallengeTTL: twoFactorChallengeTTL,
+		TwoFactorEnforced:     twoFactorEnforced,
+
+		ShippingFlatRate:    shippingFlatRate,
+		FreeShippingMinimum: freeShippingMinimum,
+
+		CommissionPercent:   commissionPercent,
+		PayoutMinimumAmount: payoutMinimumAmount,
+
+		PaymentProvider:         getEnv("PAYMENT_PROVIDER", "fake"),
+		PaymentChannelProviders: parseKeyValues(getEnv("PAYMENT_CHANNEL_PROVIDERS", "")),
+		PaymentGatewayName:      getEnv("PAYMENT_GATEWAY_NAME", "midtrans"),
+		PaymentGatewayURL:       getEnv("PAYMENT_GATEWAY_URL", ""),
+		PaymentGatewayKey:       getEnv("PAYMENT_GATEWAY_SERVER_KEY", ""),
+		PaymentGatewayLocation:  paymentGatewayLocation,
+		PaymentTTL:              paymentTTL,
+		PaymentWebhookSecrets:   parseKeyValues(getEnv("PAYMENT_WEBHOOK_SECRETS", "")),
+		PaymentExpiryInterval:   paymentExpiryInterval,
+		UnpaidOrderTTL:          unpaidOrderTTL,
+
+		QRISMerchantName:   getEnv("QRIS_MERCHANT_NAME", "EMYU"),
+		QRISMerchantCity:   getEnv("QRIS_MERCHANT_CITY", "JAKARTA"),
+		QRISPostalCode:     getEnv("QRIS_POSTAL_CODE", ""),
+		QRISAcquirerDomain: getEnv("QRIS_ACQUIRER_DOMAIN", "ID.CO.QRIS.WWW"),
+		QRISMerchantPAN:    getEnv("QRIS_MERCHANT_PAN", ""),
+		QRISMerchantID:     getEnv("QRIS_MERCHANT_ID", ""),
+		QRISNMID:           getEnv("QRIS_NMID", ""),
+		QRISMCC:            getEnv("QRIS_MCC", "5691"),
+		QRISCriteria:       getEnv("QRIS_MERCHANT_CRITERIA", "UMI"),
+
+		VAPrefixes: map[string]string{
+			"bca":     getEnv("VA_PREFIX_BCA", "39358"),
+			"bni":     getEnv("VA_PREFIX_BNI", "8808"),
+			"mandiri": getEnv("VA_PREFIX_MANDIRI", "88908"),
+			"bri":     getEnv("VA_PREFIX_BRI", "26215"),
+		},
+		VANumberLength: vaNumberLength,
 	}
 
 	return nil
@@ -50,7 +284,44 @@ func getEnv(key, defaultVal string) string {
 	return defaultVal
 }
 
+// intEnvInRange reads an integer setting and checks that it lies within
+// [min, max].
+func intEnvInRange(key, defaultVal string, min, max int) (int, error) {
+	raw := getEnv(key, defaultVal)
+	value, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil || value < min || value > max {
+		return 0, fmt.Errorf("%s must be an integer from %d to %d, got %q", key, min, max, raw)
+	}
+	return value, nil
+}
+
+// parseKeyValues parses "a=1,b=2" into a map.
+func parseKeyValues(s string) map[string]string {
+	values := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && key != "" {
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return values
+}
+
+// parseList parses "a, b" into a slice, or nil when s is empty.
+func parseList(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// GetDSN pins both the driver (loc) and the MySQL session (time_zone) to
+// UTC, so times written from Go, such as payment expiry, compare correctly
+// with NOW() in SQL whatever the server's time zone is.
 func (c Config) GetDSN() string {
-	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true&loc=UTC&time_zone=%%27%%2B00%%3A00%%27",
 		c.DBUser, c.DBPass, c.DBHost, c.DBPort, c.DBName)
 }
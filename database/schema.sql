@@ -27,11 +27,121 @@ CREATE TABLE IF NOT EXISTS users (
     role_id INT DEFAULT 2,
     password VARCHAR(255) NOT NULL,
     is_active BOOLEAN DEFAULT TRUE,
+    deactivated_at TIMESTAMP NULL,
+    deactivation_reason VARCHAR(255),
+    email_verified_at TIMESTAMP NULL,
+    pending_email VARCHAR(100) NULL,
+    totp_secret VARCHAR(64) NULL,
+    totp_enabled_at TIMESTAMP NULL,
+    totp_last_step BIGINT NULL,
+    failed_login_count INT NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP NULL,
+    erased_at TIMESTAMP NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     FOREIGN KEY (role_id) REFERENCES roles(id)
 );
 
+-- Create sessions table (one row per refresh token; rows of one login share a family_id)
+CREATE TABLE IF NOT EXISTS sessions (
+    id VARCHAR(36) PRIMARY KEY,
+    family_id VARCHAR(36) NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+    refresh_token_hash CHAR(64) UNIQUE NOT NULL,
+    user_agent VARCHAR(255),
+    ip_address VARCHAR(45),
+    expires_at TIMESTAMP NOT NULL,
+    rotated_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL,
+    revoked_reason VARCHAR(100),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Create revoked_tokens table (access token jti denylist)
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- Create login_history table (every login attempt, including unknown emails)
+CREATE TABLE IF NOT EXISTS login_history (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NULL,
+    email VARCHAR(100),
+    ip_address VARCHAR(45),
+    user_agent VARCHAR(255),
+    success BOOLEAN NOT NULL,
+    failure_reason VARCHAR(30),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Create password_reset_tokens table (single-use, stored as SHA-256 hashes)
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Create email_verification_tokens table (single-use, stored as SHA-256 hashes;
+-- email is the address the token confirms, which differs from users.email
+-- during an email change)
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+    email VARCHAR(100) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Create two_factor_recovery_codes table (single-use, stored as SHA-256 hashes)
+CREATE TABLE IF NOT EXISTS two_factor_recovery_codes (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_recovery_code (user_id, code_hash),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Create email_outbox table (mail queued in the same transaction as the
+-- change that triggers it, delivered by the outbox worker)
+CREATE TABLE IF NOT EXISTS email_outbox (
+    id VARCHAR(36) PRIMARY KEY,
+    to_email VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create erasure_requests table (account erasure under UU PDP; kept as an audit trail)
+CREATE TABLE IF NOT EXISTS erasure_requests (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    reason TEXT,
+    requested_by VARCHAR(36) NOT NULL,
+    processed_by VARCHAR(36) NULL,
+    rejection_reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    processed_at TIMESTAMP NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
 -- Create categories table
 CREATE TABLE IF NOT EXISTS categories (
     id VARCHAR(36) PRIMARY KEY,
@@ -48,10 +158,12 @@ CREATE TABLE IF NOT EXISTS products (
     description TEXT,
     price DECIMAL(10, 2) NOT NULL,
     category_id VARCHAR(36),
+    seller_id VARCHAR(36) NULL,
     is_customizable BOOLEAN DEFAULT FALSE,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-    FOREIGN KEY (category_id) REFERENCES categories(id)
+    FOREIGN KEY (category_id) REFERENCES categories(id),
+    FOREIGN KEY (seller_id) REFERENCES users(id) ON DELETE SET NULL
 );
 
 -- Create product_images table
@@ -69,7 +181,47 @@ CREATE TABLE IF NOT EXISTS product_variants (
     product_id VARCHAR(36) NOT NULL,
     name VARCHAR(100) NOT NULL,
     price_adjustment DECIMAL(10, 2) DEFAULT 0,
+    stock INT NOT NULL DEFAULT 0,
+    reserved INT NOT NULL DEFAULT 0,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE,
+    CHECK (reserved >= 0 AND reserved <= stock)
+);
+
+-- Create coupons table
+CREATE TABLE IF NOT EXISTS coupons (
+    id VARCHAR(36) PRIMARY KEY,
+    code VARCHAR(50) UNIQUE NOT NULL,
+    description TEXT,
+    discount_type VARCHAR(20) NOT NULL,
+    discount_value DECIMAL(10, 2) NOT NULL,
+    max_discount DECIMAL(10, 2) NULL,
+    min_spend DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    usage_limit INT NULL,
+    per_user_limit INT NULL,
+    used_count INT NOT NULL DEFAULT 0,
+    starts_at TIMESTAMP NULL,
+    ends_at TIMESTAMP NULL,
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+-- Create coupon_categories table (restricts a coupon to categories)
+CREATE TABLE IF NOT EXISTS coupon_categories (
+    coupon_id VARCHAR(36) NOT NULL,
+    category_id VARCHAR(36) NOT NULL,
+    PRIMARY KEY (coupon_id, category_id),
+    FOREIGN KEY (coupon_id) REFERENCES coupons(id) ON DELETE CASCADE,
+    FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE
+);
+
+-- Create coupon_products table (restricts a coupon to products)
+CREATE TABLE IF NOT EXISTS coupon_products (
+    coupon_id VARCHAR(36) NOT NULL,
+    product_id VARCHAR(36) NOT NULL,
+    PRIMARY KEY (coupon_id, product_id),
+    FOREIGN KEY (coupon_id) REFERENCES coupons(id) ON DELETE CASCADE,
     FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
 );
 
@@ -77,9 +229,11 @@ CREATE TABLE IF NOT EXISTS product_variants (
 CREATE TABLE IF NOT EXISTS carts (
     id VARCHAR(36) PRIMARY KEY,
     user_id VARCHAR(36) NOT NULL UNIQUE,
+    coupon_id VARCHAR(36) NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (coupon_id) REFERENCES coupons(id) ON DELETE SET NULL
 );
 
 -- Create cart_items table
@@ -115,8 +269,12 @@ CREATE TABLE IF NOT EXISTS orders (
     id VARCHAR(36) PRIMARY KEY,
     user_id VARCHAR(36) NOT NULL,
     order_number VARCHAR(50) UNIQUE NOT NULL,
+    subtotal DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    discount_amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
     total_amount DECIMAL(10, 2) NOT NULL,
     shipping_cost DECIMAL(10, 2) NOT NULL,
+    paid_amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    refunded_amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
     status VARCHAR(20) DEFAULT 'pending',
     payment_method VARCHAR(20),
     shipping_address_id VARCHAR(36),
@@ -133,23 +291,234 @@ CREATE TABLE IF NOT EXISTS order_items (
     product_variant_id VARCHAR(36) NOT NULL,
     quantity INT NOT NULL,
     price DECIMAL(10, 2) NOT NULL,
+    custom_name VARCHAR(50),
+    custom_number VARCHAR(5),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+    FOREIGN KEY (product_variant_id) REFERENCES product_variants(id)
+);
+
+-- Create order_discounts table (discount lines of an order)
+CREATE TABLE IF NOT EXISTS order_discounts (
+    id VARCHAR(36) PRIMARY KEY,
+    order_id VARCHAR(36) NOT NULL,
+    coupon_id VARCHAR(36) NULL,
+    code VARCHAR(50) NOT NULL,
+    description TEXT,
+    amount DECIMAL(10, 2) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+    FOREIGN KEY (coupon_id) REFERENCES coupons(id) ON DELETE SET NULL
+);
+
+-- Create coupon_redemptions table (counts coupon uses per user)
+CREATE TABLE IF NOT EXISTS coupon_redemptions (
+    id VARCHAR(36) PRIMARY KEY,
+    coupon_id VARCHAR(36) NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+    order_id VARCHAR(36) NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'applied',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_coupon_redemptions_order (coupon_id, order_id),
+    FOREIGN KEY (coupon_id) REFERENCES coupons(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+
+-- Create order_status_history table
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id VARCHAR(36) PRIMARY KEY,
+    order_id VARCHAR(36) NOT NULL,
+    from_status VARCHAR(20),
+    to_status VARCHAR(20) NOT NULL,
+    actor_type VARCHAR(20) NOT NULL,
+    actor_id VARCHAR(36),
+    note TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+
+-- Create stock_reservations table
+CREATE TABLE IF NOT EXISTS stock_reservations (
+    id VARCHAR(36) PRIMARY KEY,
+    order_id VARCHAR(36) NOT NULL,
+    product_variant_id VARCHAR(36) NOT NULL,
+    quantity INT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'reserved',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
     FOREIGN KEY (product_variant_id) REFERENCES product_variants(id)
 );
 
+-- Create stock_movements table (append-only ledger)
+CREATE TABLE IF NOT EXISTS stock_movements (
+    id VARCHAR(36) PRIMARY KEY,
+    product_variant_id VARCHAR(36) NOT NULL,
+    movement_type VARCHAR(20) NOT NULL,
+    stock_delta INT NOT NULL DEFAULT 0,
+    reserved_delta INT NOT NULL DEFAULT 0,
+    stock_after INT NOT NULL,
+    reserved_after INT NOT NULL,
+    order_id VARCHAR(36),
+    actor_id VARCHAR(36),
+    reason VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (product_variant_id) REFERENCES product_variants(id) ON DELETE CASCADE
+);
+
 -- Create payments table
 CREATE TABLE IF NOT EXISTS payments (
     id VARCHAR(36) PRIMARY KEY,
     order_id VARCHAR(36) NOT NULL,
     payment_status VARCHAR(20) DEFAULT 'pending',
     payment_code VARCHAR(50),
+    amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    channel VARCHAR(20),
+    bank VARCHAR(20),
+    va_number VARCHAR(30) UNIQUE,
+    provider VARCHAR(30),
+    provider_ref VARCHAR(100),
+    checkout_url VARCHAR(255),
+    qr_payload TEXT,
+    expires_at TIMESTAMP NULL,
     paid_at TIMESTAMP NULL,
+    -- Money arrived after the payment had expired or failed and needs a
+    -- refund.
+    paid_late BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+
+-- Create refunds table
+CREATE TABLE IF NOT EXISTS refunds (
+    id VARCHAR(36) PRIMARY KEY,
+    payment_id VARCHAR(36) NOT NULL,
+    order_id VARCHAR(36) NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    reason TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'requested',
+    provider_ref VARCHAR(100),
+    failure_reason TEXT,
+    requested_by VARCHAR(36) NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (payment_id) REFERENCES payments(id) ON DELETE CASCADE,
     FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
 );
 
+-- Create seller_payout_accounts table (where a seller's payouts are sent)
+CREATE TABLE IF NOT EXISTS seller_payout_accounts (
+    seller_id VARCHAR(36) PRIMARY KEY,
+    bank_name VARCHAR(50) NOT NULL,
+    account_number VARCHAR(34) NOT NULL,
+    account_name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (seller_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Create seller_balances table (what the platform owes each seller)
+CREATE TABLE IF NOT EXISTS seller_balances (
+    seller_id VARCHAR(36) PRIMARY KEY,
+    balance DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (seller_id) REFERENCES users(id)
+);
+
+-- Create payout_batches table
+CREATE TABLE IF NOT EXISTS payout_batches (
+    id VARCHAR(36) PRIMARY KEY,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    total_amount DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    item_count INT NOT NULL DEFAULT 0,
+    created_by VARCHAR(36) NOT NULL,
+    paid_reference VARCHAR(100),
+    paid_by VARCHAR(36),
+    paid_at TIMESTAMP NULL,
+    canceled_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+-- Create payout_items table (one transfer per seller in a batch)
+CREATE TABLE IF NOT EXISTS payout_items (
+    id VARCHAR(36) PRIMARY KEY,
+    batch_id VARCHAR(36) NOT NULL,
+    seller_id VARCHAR(36) NOT NULL,
+    amount DECIMAL(12, 2) NOT NULL,
+    bank_name VARCHAR(50) NOT NULL,
+    account_number VARCHAR(34) NOT NULL,
+    account_name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_payout_items_seller (batch_id, seller_id),
+    FOREIGN KEY (batch_id) REFERENCES payout_batches(id),
+    FOREIGN KEY (seller_id) REFERENCES users(id)
+);
+
+-- Create seller_ledger_entries table (append-only; every balance change)
+CREATE TABLE IF NOT EXISTS seller_ledger_entries (
+    id VARCHAR(36) PRIMARY KEY,
+    seller_id VARCHAR(36) NOT NULL,
+    entry_type VARCHAR(20) NOT NULL,
+    gross_amount DECIMAL(12, 2),
+    commission_amount DECIMAL(12, 2),
+    amount DECIMAL(12, 2) NOT NULL,
+    balance_after DECIMAL(12, 2) NOT NULL,
+    order_id VARCHAR(36),
+    order_item_id VARCHAR(36),
+    refund_id VARCHAR(36) NOT NULL DEFAULT '',
+    payout_batch_id VARCHAR(36),
+    description VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_ledger_order_item (order_item_id, entry_type, refund_id),
+    FOREIGN KEY (seller_id) REFERENCES users(id)
+);
+
+-- Create va_sequences table (per-bank virtual account suffix counter)
+CREATE TABLE IF NOT EXISTS va_sequences (
+    bank VARCHAR(20) PRIMARY KEY,
+    last_value BIGINT NOT NULL DEFAULT 0
+);
+
+-- Create bank_mutations table (imported bank statement lines)
+CREATE TABLE IF NOT EXISTS bank_mutations (
+    id VARCHAR(36) PRIMARY KEY,
+    bank VARCHAR(20) NOT NULL,
+    transaction_date VARCHAR(30),
+    description VARCHAR(255),
+    amount DECIMAL(12, 2) NOT NULL,
+    fingerprint CHAR(64) NOT NULL UNIQUE,
+    matched_payment_id VARCHAR(36),
+    match_note VARCHAR(255),
+    imported_by VARCHAR(36),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (matched_payment_id) REFERENCES payments(id) ON DELETE SET NULL
+);
+
+-- Create payment_notifications table (webhook idempotency log)
+CREATE TABLE IF NOT EXISTS payment_notifications (
+    id VARCHAR(36) PRIMARY KEY,
+    provider VARCHAR(30) NOT NULL,
+    notification_id VARCHAR(150) NOT NULL,
+    payment_id VARCHAR(36) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    payload TEXT,
+    received_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (payment_id) REFERENCES payments(id) ON DELETE CASCADE,
+    UNIQUE KEY unique_provider_notification (provider, notification_id)
+);
+
+-- Create worker_leases table (one holder per background job across replicas)
+CREATE TABLE IF NOT EXISTS worker_leases (
+    name VARCHAR(50) PRIMARY KEY,
+    holder VARCHAR(100) NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
 -- Create reviews table
 CREATE TABLE IF NOT EXISTS reviews (
     id VARCHAR(36) PRIMARY KEY,
@@ -164,16 +533,39 @@ CREATE TABLE IF NOT EXISTS reviews (
 
 -- Create indexes for better performance
 CREATE INDEX idx_users_role ON users(role_id);
+CREATE INDEX idx_sessions_family ON sessions(family_id, revoked_at);
+CREATE INDEX idx_sessions_user ON sessions(user_id, revoked_at);
+CREATE INDEX idx_revoked_tokens_expiry ON revoked_tokens(expires_at);
+CREATE INDEX idx_login_history_user ON login_history(user_id, created_at);
+CREATE INDEX idx_login_history_ip ON login_history(ip_address, success, created_at);
+CREATE INDEX idx_password_reset_tokens_user ON password_reset_tokens(user_id, used_at);
+CREATE INDEX idx_email_verification_tokens_user ON email_verification_tokens(user_id, created_at);
+CREATE INDEX idx_email_outbox_due ON email_outbox(status, next_attempt_at);
+CREATE INDEX idx_erasure_requests_user ON erasure_requests(user_id, status);
+CREATE INDEX idx_erasure_requests_status ON erasure_requests(status, created_at);
 CREATE INDEX idx_role_permissions_role ON role_permissions(role_id);
 CREATE INDEX idx_role_permissions_permission ON role_permissions(permission);
 CREATE INDEX idx_products_category ON products(category_id);
+CREATE INDEX idx_products_seller ON products(seller_id);
 CREATE INDEX idx_product_images_product ON product_images(product_id);
 CREATE INDEX idx_product_variants_product ON product_variants(product_id);
 CREATE INDEX idx_cart_items_cart ON cart_items(cart_id);
 CREATE INDEX idx_cart_items_variant ON cart_items(product_variant_id);
 CREATE INDEX idx_orders_user ON orders(user_id);
 CREATE INDEX idx_order_items_order ON order_items(order_id);
+CREATE INDEX idx_order_discounts_order ON order_discounts(order_id);
+CREATE INDEX idx_coupon_redemptions_user ON coupon_redemptions(coupon_id, user_id, status);
+CREATE INDEX idx_order_status_history_order ON order_status_history(order_id, created_at);
+CREATE INDEX idx_stock_reservations_order ON stock_reservations(order_id, status);
+CREATE INDEX idx_stock_movements_variant ON stock_movements(product_variant_id, created_at);
 CREATE INDEX idx_payments_order ON payments(order_id);
+CREATE INDEX idx_payments_provider_ref ON payments(provider, provider_ref);
+CREATE INDEX idx_refunds_payment ON refunds(payment_id, created_at);
+CREATE INDEX idx_payments_status_expiry ON payments(payment_status, expires_at);
+CREATE INDEX idx_orders_status_created ON orders(status, created_at);
+CREATE INDEX idx_ledger_seller ON seller_ledger_entries(seller_id, created_at);
+CREATE INDEX idx_ledger_order ON seller_ledger_entries(order_id);
+CREATE INDEX idx_payout_items_batch ON payout_items(batch_id);
 CREATE INDEX idx_reviews_user ON reviews(user_id);
 CREATE INDEX idx_reviews_product ON reviews(product_id);
 
@@ -187,22 +579,24 @@ ON DUPLICATE KEY UPDATE id=id;
 -- Insert default permissions for admin
 INSERT INTO role_permissions (role_id, permission) VALUES
 (1, 'manage_products'),
+(1, 'manage_all_products'),
 (1, 'manage_categories'),
 (1, 'manage_orders'),
 (1, 'manage_users'),
 (1, 'manage_payments'),
+(1, 'manage_coupons'),
+(1, 'manage_payouts'),
 (1, 'view_reports'),
-(1, 'manage_roles');
+(1, 'manage_roles'),
+(1, 'view_orders'),
+(1, 'view_sales_reports');
 
 -- Insert default permissions for seller
 INSERT INTO role_permissions (role_id, permission) VALUES
 (3, 'manage_products'),
 (3, 'view_orders'),
-(3, 'view_sales_reports');
+(3, 'view_sales_reports'),
+(3, 'manage_payout_account');
 
--- Insert default permissions for user (customer)
-INSERT INTO role_permissions (role_id, permission) VALUES
-(2, 'view_products'),
-(2, 'create_orders'),
-(2, 'view_own_orders'),
-(2, 'create_reviews');
+-- Customers (role 2) need no permissions: shopping, their own orders and
+-- reviews only require a login.
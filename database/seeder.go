@@ -113,7 +113,7 @@ func seedAdminUser() error {
 	}
 
 	_, err = DB.Exec(
-		"INSERT INTO users (id, name, email, phone, role_id, password, is_active) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		"INSERT INTO users (id, name, email, phone, role_id, password, is_active, email_verified_at) VALUES (?, ?, ?, ?, ?, ?, ?, NOW())",
 		adminID, "Admin User", adminEmail, "08111111111", 1, hashedPassword, true,
 	)
 	if err != nil {
@@ -165,7 +165,7 @@ func seedRegularUsers() error {
 		}
 
 		_, err = DB.Exec(
-			"INSERT INTO users (id, name, email, phone, role_id, password, is_active) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			"INSERT INTO users (id, name, email, phone, role_id, password, is_active, email_verified_at) VALUES (?, ?, ?, ?, ?, ?, ?, NOW())",
 			userID, user["name"], user["email"], user["phone"], 2, hashedPassword, true,
 		)
 		if err != nil {
@@ -314,8 +314,8 @@ func seedProductVariants() error {
 		for _, variant := range variants {
 			variantID := utils.GenerateID()
 			_, err = DB.Exec(
-				"INSERT INTO product_variants (id, product_id, name, price_adjustment) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE id=id",
-				variantID, productID, variant["name"], variant["price_adjustment"],
+				"INSERT INTO product_variants (id, product_id, name, price_adjustment, stock) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE id=id",
+				variantID, productID, variant["name"], variant["price_adjustment"], 50,
 			)
 			if err != nil {
 				return fmt.Errorf("failed to seed variant: %w", err)
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/middleware"
+	"github.com/emyu/ecommer-be/models"
+	"github.com/emyu/ecommer-be/services"
+	"github.com/gin-gonic/gin"
+)
+
+// UpdateMyProfile changes the current user's name and phone number.
+func UpdateMyProfile(c *gin.Context) {
+	var req models.UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err := database.DB.Exec(
+		"UPDATE users SET name = ?, phone = ? WHERE id = ?",
+		req.Name, req.Phone, middleware.GetUserID(c),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	GetMyProfile(c)
+}
+
+// ChangeMyEmail starts an email change. The new address gets a
+// verification link and becomes the login email once it is opened.
+func ChangeMyEmail(c *gin.Context) {
+	var req models.ChangeEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := services.RequestEmailChange(middleware.GetUserID(c), req.Password, req.NewEmail, c.ClientIP(), c.Request.UserAgent())
+	var throttled *services.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		respondThrottled(c, throttled)
+		return
+	case errors.Is(err, services.ErrWrongPassword):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrSameEmail):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent to the new address"})
+}
+
+// CancelMyEmailChange drops a pending email change.
+func CancelMyEmailChange(c *gin.Context) {
+	if err := services.CancelEmailChange(middleware.GetUserID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel email change"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email change canceled"})
+}
+
+// ChangeMyPassword sets a new password and logs out every other session.
+func ChangeMyPassword(c *gin.Context) {
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := services.ChangePassword(middleware.GetUserID(c), req.CurrentPassword, req.NewPassword, middleware.GetSessionID(c),
+		c.ClientIP(), c.Request.UserAgent())
+	var throttled *services.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		respondThrottled(c, throttled)
+		return
+	case errors.Is(err, services.ErrWrongPassword):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrWeakPassword):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed, other sessions have been logged out"})
+}
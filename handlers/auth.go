@@ -2,10 +2,14 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/middleware"
 	"github.com/emyu/ecommer-be/models"
+	"github.com/emyu/ecommer-be/services"
 	"github.com/emyu/ecommer-be/utils"
 	"github.com/gin-gonic/gin"
 )
@@ -25,6 +29,11 @@ func Register(c *gin.Context) {
 		return
 	}
 
+	if err := services.CheckPasswordPolicy(req.Password, req.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Hash password
 	hashedPassword, err := utils.HashPassword(req.Password)
 	if err != nil {
@@ -44,7 +53,14 @@ func Register(c *gin.Context) {
 		Password: hashedPassword,
 	}
 
-	_, err = database.DB.Exec(
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
 		"INSERT INTO users (id, name, email, phone, role_id, password) VALUES (?, ?, ?, ?, ?, ?)",
 		user.ID, user.Name, user.Email, user.Phone, user.RoleID, user.Password,
 	)
@@ -53,36 +69,20 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	// Get role info for token
-	var roleID int
-	var roleName string
-	var permissions []string
-	err = database.DB.QueryRow(
-		"SELECT id, name FROM roles WHERE id = ?",
-		user.RoleID,
-	).Scan(&roleID, &roleName)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user role"})
+	// The account is usable right away; the verification email only
+	// confirms the address.
+	if err := services.SendVerificationEmail(tx, user.ID, user.Name, user.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
 
-	// Fetch role permissions
-	permRows, err := database.DB.Query(
-		"SELECT permission FROM role_permissions WHERE role_id = ?",
-		roleID,
-	)
-	if err == nil {
-		defer permRows.Close()
-		for permRows.Next() {
-			var perm string
-			if err := permRows.Scan(&perm); err == nil {
-				permissions = append(permissions, perm)
-			}
-		}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
 	}
+	user.IsActive = true
 
-	// Generate token
-	token, err := utils.GenerateToken(user.ID, user.Email, roleID, roleName, permissions)
+	pair, err := services.StartSession(user.ID, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
@@ -92,8 +92,11 @@ func Register(c *gin.Context) {
 	user.Password = ""
 
 	c.JSON(http.StatusCreated, gin.H{
-		"user":  user,
-		"token": token,
+		"user":               user,
+		"token":              pair.AccessToken,
+		"expires_at":         pair.AccessExpiresAt,
+		"refresh_token":      pair.RefreshToken,
+		"refresh_expires_at": pair.RefreshExpiresAt,
 	})
 }
 
@@ -105,65 +108,292 @@ func Login(c *gin.Context) {
 	}
 
 	var user models.User
+	var twoFactorEnabled bool
 	err := database.DB.QueryRow(
-		"SELECT id, name, email, phone, role_id, password FROM users WHERE email = ?",
+		"SELECT id, name, email, phone, role_id, password, is_active, email_verified_at, totp_enabled_at IS NOT NULL FROM users WHERE email = ?",
 		req.Email,
-	).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.RoleID, &user.Password)
+	).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.RoleID, &user.Password, &user.IsActive, &user.EmailVerifiedAt, &twoFactorEnabled)
+
+	if err != nil && err != sql.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+		return
+	}
+
+	userAgent, ip := c.Request.UserAgent(), c.ClientIP()
+	if !checkLoginAllowed(c, user.ID, req.Email) {
+		return
+	}
 
 	if err == sql.ErrNoRows {
+		utils.CompareDummyPassword(req.Password)
+		if err := services.RecordLoginFailure("", req.Email, ip, userAgent, services.LoginFailureUnknownEmail); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
 	if !utils.ComparePassword(user.Password, req.Password) {
+		if err := services.RecordLoginFailure(user.ID, req.Email, ip, userAgent, services.LoginFailureBadPassword); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
-	// Get role info for token
-	var roleID int
-	var roleName string
-	var permissions []string
-	err = database.DB.QueryRow(
-		"SELECT id, name FROM roles WHERE id = ?",
-		user.RoleID,
-	).Scan(&roleID, &roleName)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user role"})
+	services.RehashPasswordIfNeeded(user.ID, user.Password, req.Password)
+
+	// Only reveal the deactivation to someone who knows the password.
+	if !user.IsActive {
+		if err := services.RecordLoginFailure(user.ID, req.Email, ip, userAgent, services.LoginFailureInactive); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is deactivated"})
 		return
 	}
 
-	// Fetch role permissions
-	permRows, err := database.DB.Query(
-		"SELECT permission FROM role_permissions WHERE role_id = ?",
-		roleID,
-	)
-	if err == nil {
-		defer permRows.Close()
-		for permRows.Next() {
-			var perm string
-			if err := permRows.Scan(&perm); err == nil {
-				permissions = append(permissions, perm)
-			}
+	// With 2FA on, the password only earns a challenge token that
+	// POST /api/login/2fa exchanges, together with a code, for a session.
+	if twoFactorEnabled {
+		challenge, expiresAt, err := utils.GenerateChallengeToken(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
 		}
+		c.JSON(http.StatusOK, gin.H{
+			"two_factor_required":  true,
+			"challenge_token":      challenge,
+			"challenge_expires_at": expiresAt,
+		})
+		return
 	}
 
-	// Generate token
-	token, err := utils.GenerateToken(user.ID, user.Email, roleID, roleName, permissions)
+	if err := services.RecordLoginSuccess(user.ID, req.Email, ip, userAgent); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+		return
+	}
+
+	pair, err := services.StartSession(user.ID, userAgent, ip)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
 	user.Password = ""
+	user.EmailVerified = user.EmailVerifiedAt != nil
 
 	c.JSON(http.StatusOK, gin.H{
-		"user":  user,
-		"token": token,
+		"user":               user,
+		"token":              pair.AccessToken,
+		"expires_at":         pair.AccessExpiresAt,
+		"refresh_token":      pair.RefreshToken,
+		"refresh_expires_at": pair.RefreshExpiresAt,
+	})
+}
+
+// LoginTwoFactor completes a login for a user with 2FA enabled.
+func LoginTwoFactor(c *gin.Context) {
+	var req models.TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := utils.ValidateChallengeToken(req.ChallengeToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge token"})
+		return
+	}
+
+	if !checkLoginAllowed(c, userID, "") {
+		return
+	}
+
+	userAgent, ip := c.Request.UserAgent(), c.ClientIP()
+	pair, err := services.CompleteTwoFactorLogin(userID, req.Code, userAgent, ip)
+	if errors.Is(err, services.ErrInvalidTwoFactorCode) {
+		if err := services.RecordLoginFailure(userID, "", ip, userAgent, services.LoginFailureBad2FACode); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrUserInactive) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is deactivated"})
+		return
+	}
+	if errors.Is(err, services.ErrUserNotFound) || errors.Is(err, services.ErrTwoFactorNotEnabled) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	if err := services.RecordLoginSuccess(userID, "", ip, userAgent); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+		return
+	}
+
+	c.JSON(http.StatusOK, pair)
+}
+
+// checkLoginAllowed answers 429 with Retry-After when the account or the
+// client IP is throttled. Refused attempts are recorded but not counted.
+func checkLoginAllowed(c *gin.Context, userID, email string) bool {
+	err := services.CheckLoginAllowed(userID, c.ClientIP())
+	if err == nil {
+		return true
+	}
+
+	var throttled *services.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+		return false
+	}
+
+	if err := services.RecordLoginFailure(userID, email, c.ClientIP(), c.Request.UserAgent(), services.LoginFailureThrottled); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+		return false
+	}
+
+	respondThrottled(c, throttled)
+	return false
+}
+
+// respondThrottled answers a refused login or password check with 429.
+func respondThrottled(c *gin.Context, throttled *services.LoginThrottledError) {
+	c.Header("Retry-After", strconv.Itoa(int(throttled.RetryAfter.Seconds())))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       throttled.Error(),
+		"locked":      throttled.Locked,
+		"retry_after": int(throttled.RetryAfter.Seconds()),
 	})
 }
 
+// RefreshToken exchanges a refresh token for a new access and refresh token.
+// The old refresh token stops working; using it again revokes the session.
+func RefreshToken(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pair, err := services.RefreshSession(req.RefreshToken, c.Request.UserAgent(), c.ClientIP())
+	if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, pair)
+}
+
+// Logout revokes the current session and the access token used to call it.
 func Logout(c *gin.Context) {
-	// JWT is stateless, logout is handled by frontend (delete token)
+	if err := services.RevokeSession(middleware.GetSessionID(c), "logout"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+	if err := services.RevokeAccessToken(middleware.GetTokenID(c), middleware.GetTokenExpiry(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
+
+// ForgotPassword queues a password reset email. The response is the same
+// whether or not the address belongs to an account.
+func ForgotPassword(c *gin.Context) {
+	var req models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.RequestPasswordReset(req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request password reset"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the email is registered, a password reset link has been sent"})
+}
+
+// ResetPassword sets a new password using the token from the reset email and
+// logs the user out everywhere.
+func ResetPassword(c *gin.Context) {
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := services.ResetPassword(req.Token, req.NewPassword)
+	if errors.Is(err, services.ErrInvalidResetToken) || errors.Is(err, services.ErrWeakPassword) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset, please log in again"})
+}
+
+// VerifyEmail confirms the user's email address with the token from the
+// verification email.
+func VerifyEmail(c *gin.Context) {
+	var req models.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := services.VerifyEmail(req.Token)
+	if errors.Is(err, services.ErrInvalidVerificationToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrEmailTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+// ResendVerificationEmail sends the current user a new verification link.
+func ResendVerificationEmail(c *gin.Context) {
+	err := services.ResendVerificationEmail(middleware.GetUserID(c))
+	switch {
+	case errors.Is(err, services.ErrEmailAlreadyVerified):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrVerificationCooldown):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent"})
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/emyu/ecommer-be/database"
 	"github.com/emyu/ecommer-be/models"
 	"github.com/emyu/ecommer-be/middleware"
+	"github.com/emyu/ecommer-be/services"
 	"github.com/emyu/ecommer-be/utils"
 )
 
@@ -15,10 +16,12 @@ func GetUserCart(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 	var cart models.Cart
 
+	var couponCode sql.NullString
 	err := database.DB.QueryRow(
-		"SELECT id, user_id, created_at, updated_at FROM carts WHERE user_id = ?",
+		"SELECT c.id, c.user_id, cp.code, c.created_at, c.updated_at FROM carts c LEFT JOIN coupons cp ON c.coupon_id = cp.id WHERE c.user_id = ?",
 		userID,
-	).Scan(&cart.ID, &cart.UserID, &cart.CreatedAt, &cart.UpdatedAt)
+	).Scan(&cart.ID, &cart.UserID, &couponCode, &cart.CreatedAt, &cart.UpdatedAt)
+	cart.CouponCode = couponCode.String
 
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
@@ -72,6 +75,10 @@ func AddToCart(c *gin.Context) {
 		return
 	}
 
+	if !authorizeOwner(c, services.ResourceCart, req.CartID) {
+		return
+	}
+
 	itemID := utils.GenerateID()
 	_, err := database.DB.Exec(`
 		INSERT INTO cart_items (id, cart_id, product_variant_id, quantity, custom_name, custom_number)
@@ -88,6 +95,10 @@ func AddToCart(c *gin.Context) {
 
 func RemoveFromCart(c *gin.Context) {
 	itemID := c.Param("itemId")
+	if !authorizeOwner(c, services.ResourceCartItem, itemID) {
+		return
+	}
+
 	_, err := database.DB.Exec("DELETE FROM cart_items WHERE id = ?", itemID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove item"})
@@ -108,6 +119,10 @@ func UpdateCartItem(c *gin.Context) {
 		return
 	}
 
+	if !authorizeOwner(c, services.ResourceCartItem, itemID) {
+		return
+	}
+
 	_, err := database.DB.Exec("UPDATE cart_items SET quantity = ? WHERE id = ?", req.Quantity, itemID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update item"})
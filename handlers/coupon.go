@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/middleware"
+	"github.com/emyu/ecommer-be/models"
+	"github.com/emyu/ecommer-be/services"
+	"github.com/emyu/ecommer-be/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const couponColumns = `id, code, description, discount_type, discount_value, max_discount, min_spend, usage_limit, per_user_limit, used_count, starts_at, ends_at, is_active, created_at, updated_at`
+
+type couponRequest struct {
+	Code          string     `json:"code" binding:"required,max=50"`
+	Description   string     `json:"description"`
+	DiscountType  string     `json:"discount_type" binding:"required,oneof=percentage fixed"`
+	DiscountValue float64    `json:"discount_value" binding:"required,gt=0"`
+	MaxDiscount   *float64   `json:"max_discount" binding:"omitempty,gt=0"`
+	MinSpend      float64    `json:"min_spend" binding:"gte=0"`
+	UsageLimit    *int       `json:"usage_limit" binding:"omitempty,min=1"`
+	PerUserLimit  *int       `json:"per_user_limit" binding:"omitempty,min=1"`
+	StartsAt      *time.Time `json:"starts_at"`
+	EndsAt        *time.Time `json:"ends_at"`
+	IsActive      *bool      `json:"is_active"`
+	CategoryIDs   []string   `json:"category_ids"`
+	ProductIDs    []string   `json:"product_ids"`
+}
+
+func (r couponRequest) validate() string {
+	if r.DiscountType == services.CouponPercentage && r.DiscountValue > 100 {
+		return "Percentage discount cannot exceed 100"
+	}
+	if r.StartsAt != nil && r.EndsAt != nil && !r.EndsAt.After(*r.StartsAt) {
+		return "ends_at must be after starts_at"
+	}
+	return ""
+}
+
+// Helper function to scan a coupons row selected with couponColumns
+func scanCoupon(row rowScanner) (models.Coupon, error) {
+	var coupon models.Coupon
+	var description sql.NullString
+	var maxDiscount sql.NullFloat64
+	var usageLimit, perUserLimit sql.NullInt64
+	err := row.Scan(&coupon.ID, &coupon.Code, &description, &coupon.DiscountType, &coupon.DiscountValue, &maxDiscount, &coupon.MinSpend,
+		&usageLimit, &perUserLimit, &coupon.UsedCount, &coupon.StartsAt, &coupon.EndsAt, &coupon.IsActive, &coupon.CreatedAt, &coupon.UpdatedAt)
+	coupon.Description = description.String
+	if maxDiscount.Valid {
+		coupon.MaxDiscount = &maxDiscount.Float64
+	}
+	if usageLimit.Valid {
+		limit := int(usageLimit.Int64)
+		coupon.UsageLimit = &limit
+	}
+	if perUserLimit.Valid {
+		limit := int(perUserLimit.Int64)
+		coupon.PerUserLimit = &limit
+	}
+	return coupon, err
+}
+
+// Helper function to load the category and product restrictions of a coupon
+func getCouponTargets(coupon *models.Coupon) error {
+	coupon.CategoryIDs = []string{}
+	coupon.ProductIDs = []string{}
+
+	targets := []struct {
+		query string
+		ids   *[]string
+	}{
+		{"SELECT category_id FROM coupon_categories WHERE coupon_id = ?", &coupon.CategoryIDs},
+		{"SELECT product_id FROM coupon_products WHERE coupon_id = ?", &coupon.ProductIDs},
+	}
+	for _, t := range targets {
+		rows, err := database.DB.Query(t.query, coupon.ID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			*t.ids = append(*t.ids, id)
+		}
+		rows.Close()
+	}
+	return nil
+}
+
+// Helper function to replace the restrictions of a coupon
+func saveCouponTargets(tx *sql.Tx, couponID string, req couponRequest) error {
+	if _, err := tx.Exec("DELETE FROM coupon_categories WHERE coupon_id = ?", couponID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM coupon_products WHERE coupon_id = ?", couponID); err != nil {
+		return err
+	}
+	for _, id := range req.CategoryIDs {
+		if _, err := tx.Exec("INSERT IGNORE INTO coupon_categories (coupon_id, category_id) VALUES (?, ?)", couponID, id); err != nil {
+			return err
+		}
+	}
+	for _, id := range req.ProductIDs {
+		if _, err := tx.Exec("INSERT IGNORE INTO coupon_products (coupon_id, product_id) VALUES (?, ?)", couponID, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Helper function to check whether another coupon already uses a code
+func couponCodeTaken(tx *sql.Tx, code, exceptID string) bool {
+	var taken bool
+	tx.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM coupons WHERE code = ? AND id <> ?)",
+		services.NormalizeCouponCode(code), exceptID,
+	).Scan(&taken)
+	return taken
+}
+
+func GetCoupons(c *gin.Context) {
+	rows, err := database.DB.Query(`SELECT ` + couponColumns + ` FROM coupons ORDER BY created_at DESC`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch coupons"})
+		return
+	}
+	defer rows.Close()
+
+	var coupons []models.Coupon
+	for rows.Next() {
+		coupon, err := scanCoupon(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan coupon"})
+			return
+		}
+		coupons = append(coupons, coupon)
+	}
+
+	for i := range coupons {
+		if err := getCouponTargets(&coupons[i]); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch coupon restrictions"})
+			return
+		}
+	}
+
+	if coupons == nil {
+		coupons = []models.Coupon{}
+	}
+
+	c.JSON(http.StatusOK, coupons)
+}
+
+func GetCouponByID(c *gin.Context) {
+	coupon, err := scanCoupon(database.DB.QueryRow(`SELECT `+couponColumns+` FROM coupons WHERE id = ?`, c.Param("id")))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch coupon"})
+		return
+	}
+
+	if err := getCouponTargets(&coupon); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch coupon restrictions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, coupon)
+}
+
+func CreateCoupon(c *gin.Context) {
+	var req couponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create coupon"})
+		return
+	}
+	defer tx.Rollback()
+
+	if couponCodeTaken(tx, req.Code, "") {
+		c.JSON(http.StatusConflict, gin.H{"error": "Coupon code already exists"})
+		return
+	}
+
+	couponID := utils.GenerateID()
+	_, err = tx.Exec(`
+		INSERT INTO coupons (id, code, description, discount_type, discount_value, max_discount, min_spend, usage_limit, per_user_limit, starts_at, ends_at, is_active)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, couponID, services.NormalizeCouponCode(req.Code), req.Description, req.DiscountType, req.DiscountValue, req.MaxDiscount, req.MinSpend,
+		req.UsageLimit, req.PerUserLimit, req.StartsAt, req.EndsAt, isActive)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create coupon"})
+		return
+	}
+
+	if err := saveCouponTargets(tx, couponID, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown category or product"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create coupon"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"id": couponID, "message": "Coupon created"})
+}
+
+func UpdateCoupon(c *gin.Context) {
+	couponID := c.Param("id")
+	var req couponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update coupon"})
+		return
+	}
+	defer tx.Rollback()
+
+	var found int
+	tx.QueryRow("SELECT COUNT(*) FROM coupons WHERE id = ? FOR UPDATE", couponID).Scan(&found)
+	if found == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found"})
+		return
+	}
+	if couponCodeTaken(tx, req.Code, couponID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Coupon code already exists"})
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE coupons SET code = ?, description = ?, discount_type = ?, discount_value = ?, max_discount = ?, min_spend = ?,
+		       usage_limit = ?, per_user_limit = ?, starts_at = ?, ends_at = ?, is_active = ?
+		WHERE id = ?
+	`, services.NormalizeCouponCode(req.Code), req.Description, req.DiscountType, req.DiscountValue, req.MaxDiscount, req.MinSpend,
+		req.UsageLimit, req.PerUserLimit, req.StartsAt, req.EndsAt, isActive, couponID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update coupon"})
+		return
+	}
+
+	if err := saveCouponTargets(tx, couponID, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown category or product"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update coupon"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Coupon updated"})
+}
+
+// DeleteCoupon removes a coupon that was never redeemed. Redeemed coupons
+// are kept for reporting and can be deactivated instead.
+func DeleteCoupon(c *gin.Context) {
+	couponID := c.Param("id")
+
+	var redeemed bool
+	database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM coupon_redemptions WHERE coupon_id = ?)", couponID).Scan(&redeemed)
+	if redeemed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Coupon has been redeemed; deactivate it instead"})
+		return
+	}
+
+	res, err := database.DB.Exec("DELETE FROM coupons WHERE id = ?", couponID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete coupon"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Coupon deleted"})
+}
+
+// ApplyCartCoupon validates a coupon code against the user's cart and keeps
+// it on the cart for checkout.
+func ApplyCartCoupon(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	preview, err := services.ApplyCouponToCart(middleware.GetUserID(c), req.Code)
+	if err != nil {
+		respondOrderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, preview)
+}
+
+func RemoveCartCoupon(c *gin.Context) {
+	if err := services.RemoveCouponFromCart(middleware.GetUserID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove coupon"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Coupon removed from cart"})
+}
+
+func isCouponError(err error) bool {
+	for _, target := range []error{
+		services.ErrCouponNotFound, services.ErrCouponInactive, services.ErrCouponNotValid,
+		services.ErrCouponUsageLimit, services.ErrCouponMinSpend, services.ErrCouponNotApplicable,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func respondCouponError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrCouponNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found"})
+		return
+	}
+	c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+}
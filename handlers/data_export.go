@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/middleware"
+	"github.com/emyu/ecommer-be/models"
+	"github.com/gin-gonic/gin"
+)
+
+// ExportMyData returns everything the shop holds about the current user as
+// a download. ?format=zip returns one JSON file per section instead of a
+// single JSON document.
+func ExportMyData(c *gin.Context) {
+	writeDataExport(c, middleware.GetUserID(c))
+}
+
+// ExportUserData - Admin endpoint returning a user's data export, e.g. to
+// answer an access request received by email
+func ExportUserData(c *gin.Context) {
+	writeDataExport(c, c.Param("id"))
+}
+
+func writeDataExport(c *gin.Context, userID string) {
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "zip" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or zip"})
+		return
+	}
+
+	export, err := buildDataExport(userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export user data"})
+		return
+	}
+
+	filename := fmt.Sprintf("user-data-%s-%s", userID, export.ExportedAt.Format("20060102"))
+	if format == "json" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		c.IndentedJSON(http.StatusOK, export)
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+	c.Status(http.StatusOK)
+
+	sections := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"shipping_addresses.json", export.ShippingAddresses},
+		{"orders.json", export.Orders},
+		{"reviews.json", export.Reviews},
+		{"login_history.json", export.LoginHistory},
+		{"erasure_requests.json", export.ErasureRequests},
+	}
+
+	zw := zip.NewWriter(c.Writer)
+	for _, s := range sections {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: s.name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err != nil {
+			return
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(s.data); err != nil {
+			return
+		}
+	}
+	zw.Close()
+}
+
+// buildDataExport collects a user's data. It returns sql.ErrNoRows if the
+// user does not exist.
+func buildDataExport(userID string) (*models.DataExport, error) {
+	export := &models.DataExport{ExportedAt: time.Now()}
+
+	user := &export.Profile
+	err := database.DB.QueryRow(`
+		SELECT id, name, email, COALESCE(phone, ''), role_id, is_active, email_verified_at, COALESCE(pending_email, ''), created_at, updated_at
+		FROM users WHERE id = ?
+	`, userID).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.RoleID, &user.IsActive, &user.EmailVerifiedAt, &user.PendingEmail, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	user.EmailVerified = user.EmailVerifiedAt != nil
+
+	if export.ShippingAddresses, err = exportShippingAddresses(userID); err != nil {
+		return nil, err
+	}
+	if export.Orders, err = exportOrders(userID); err != nil {
+		return nil, err
+	}
+	if export.Reviews, err = exportReviews(userID); err != nil {
+		return nil, err
+	}
+	if export.LoginHistory, err = exportLoginHistory(userID); err != nil {
+		return nil, err
+	}
+	if export.ErasureRequests, err = getErasureRequests("WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+func exportShippingAddresses(userID string) ([]models.ShippingAddress, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, user_id, address, city, province, postal_code, phone, created_at, updated_at
+		FROM shipping_addresses WHERE user_id = ? ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := []models.ShippingAddress{}
+	for rows.Next() {
+		var a models.ShippingAddress
+		if err := rows.Scan(&a.ID, &a.UserID, &a.Address, &a.City, &a.Province, &a.PostalCode, &a.Phone, &a.CreatedAt, &a.UpdatedAt); err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, a)
+	}
+	return addresses, rows.Err()
+}
+
+func exportOrders(userID string) ([]models.Order, error) {
+	rows, err := database.DB.Query("SELECT id FROM orders WHERE user_id = ? ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	orders := []models.Order{}
+	for _, id := range ids {
+		order, err := getOrderDetails(id)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, *order)
+	}
+	return orders, nil
+}
+
+func exportReviews(userID string) ([]models.Review, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, user_id, product_id, rating, comment, created_at
+		FROM reviews WHERE user_id = ? ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []models.Review{}
+	for rows.Next() {
+		var r models.Review
+		var comment sql.NullString
+		if err := rows.Scan(&r.ID, &r.UserID, &r.ProductID, &r.Rating, &comment, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		r.Comment = comment.String
+		reviews = append(reviews, r)
+	}
+	return reviews, rows.Err()
+}
+
+func exportLoginHistory(userID string) ([]models.LoginHistoryEntry, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, ip_address, user_agent, success, failure_reason, created_at
+		FROM login_history WHERE user_id = ? ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.LoginHistoryEntry{}
+	for rows.Next() {
+		var e models.LoginHistoryEntry
+		var ip, userAgent, reason sql.NullString
+		if err := rows.Scan(&e.ID, &ip, &userAgent, &e.Success, &reason, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.IPAddress, e.UserAgent, e.FailureReason = ip.String, userAgent.String, reason.String
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
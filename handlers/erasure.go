@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/middleware"
+	"github.com/emyu/ecommer-be/models"
+	"github.com/emyu/ecommer-be/services"
+	"github.com/gin-gonic/gin"
+)
+
+// RequestMyErasure asks for the current user's account to be erased. An
+// admin approves the request once open orders and seller payouts are
+// settled.
+func RequestMyErasure(c *gin.Context) {
+	var req models.EraseAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := services.RequestErasure(middleware.GetUserID(c), req.Password, req.Reason, c.ClientIP(), c.Request.UserAgent())
+	var throttled *services.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		respondThrottled(c, throttled)
+		return
+	case errors.Is(err, services.ErrWrongPassword):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrErasureRequestPending):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request erasure"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"id": id, "message": "Erasure request received"})
+}
+
+// GetMyErasureRequests lists the current user's erasure requests, newest
+// first.
+func GetMyErasureRequests(c *gin.Context) {
+	requests, err := getErasureRequests("WHERE user_id = ?", middleware.GetUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch erasure requests"})
+		return
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+// CancelMyErasure withdraws the current user's pending erasure request.
+func CancelMyErasure(c *gin.Context) {
+	err := services.CancelErasureRequest(middleware.GetUserID(c))
+	if errors.Is(err, services.ErrErasureRequestNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No pending erasure request"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel erasure request"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Erasure request canceled"})
+}
+
+// GetErasureRequests - Admin endpoint listing erasure requests, optionally
+// filtered by ?status=
+func GetErasureRequests(c *gin.Context) {
+	var requests []models.ErasureRequest
+	var err error
+	if status := c.Query("status"); status != "" {
+		requests, err = getErasureRequests("WHERE status = ?", status)
+	} else {
+		requests, err = getErasureRequests("")
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch erasure requests"})
+		return
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+// ApproveErasureRequest - Admin endpoint erasing the account of a pending
+// request
+func ApproveErasureRequest(c *gin.Context) {
+	err := services.ProcessErasureRequest(c.Param("id"), middleware.GetUserID(c))
+	if err != nil {
+		respondErasureError(c, err, "Failed to erase account")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account erased"})
+}
+
+// RejectErasureRequest - Admin endpoint declining a pending request with a
+// reason for the user
+func RejectErasureRequest(c *gin.Context) {
+	var req models.RejectErasureRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := services.RejectErasureRequest(c.Param("id"), middleware.GetUserID(c), req.Reason)
+	if err != nil {
+		respondErasureError(c, err, "Failed to reject erasure request")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Erasure request rejected"})
+}
+
+func respondErasureError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrErasureRequestNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Erasure request not found"})
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, services.ErrCannotEraseSelf):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrErasureRequestFinished),
+		errors.Is(err, services.ErrErasureBlocked),
+		errors.Is(err, services.ErrUserErased),
+		errors.Is(err, services.ErrLastUserManager):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// getErasureRequests lists erasure requests matching an optional WHERE
+// clause, newest first.
+func getErasureRequests(where string, args ...interface{}) ([]models.ErasureRequest, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, user_id, status, reason, requested_by, processed_by, rejection_reason, created_at, processed_at
+		FROM erasure_requests `+where+` ORDER BY created_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []models.ErasureRequest{}
+	for rows.Next() {
+		var r models.ErasureRequest
+		var reason, processedBy, rejectionReason sql.NullString
+		if err := rows.Scan(&r.ID, &r.UserID, &r.Status, &reason, &r.RequestedBy, &processedBy, &rejectionReason, &r.CreatedAt, &r.ProcessedAt); err != nil {
+			return nil, err
+		}
+		r.Reason, r.ProcessedBy, r.RejectionReason = reason.String, processedBy.String, rejectionReason.String
+		requests = append(requests, r)
+	}
+	return requests, rows.Err()
+}
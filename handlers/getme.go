@@ -14,7 +14,7 @@ func GetMyProfile(c *gin.Context) {
     var user models.User
 
     err := database.DB.QueryRow(`
-        SELECT id, name, email, phone, role_id, is_active, created_at, updated_at
+        SELECT id, name, email, phone, role_id, is_active, email_verified_at, COALESCE(pending_email, ''), created_at, updated_at
         FROM users WHERE id = ?
     `, userID).Scan(
         &user.ID,
@@ -23,6 +23,8 @@ func GetMyProfile(c *gin.Context) {
         &user.Phone,
         &user.RoleID,
         &user.IsActive,
+        &user.EmailVerifiedAt,
+        &user.PendingEmail,
         &user.CreatedAt,
         &user.UpdatedAt,
     )
@@ -41,5 +43,7 @@ func GetMyProfile(c *gin.Context) {
         return
     }
 
+    user.EmailVerified = user.EmailVerifiedAt != nil
+
     c.JSON(http.StatusOK, user)
 }
\ No newline at end of file
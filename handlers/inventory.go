@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/middleware"
+	"github.com/emyu/ecommer-be/models"
+	"github.com/emyu/ecommer-be/services"
+	"github.com/gin-gonic/gin"
+)
+
+func GetVariantStock(c *gin.Context) {
+	variantID := c.Param("id")
+	if !authorizeVariant(c, variantID) {
+		return
+	}
+
+	var variant models.ProductVariant
+
+	err := database.DB.QueryRow(`
+		SELECT id, product_id, name, price_adjustment, stock, reserved, created_at
+		FROM product_variants WHERE id = ?
+	`, variantID).Scan(&variant.ID, &variant.ProductID, &variant.Name, &variant.PriceAdjustment, &variant.Stock, &variant.Reserved, &variant.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product variant not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product variant"})
+		return
+	}
+
+	c.JSON(http.StatusOK, variant)
+}
+
+// AdjustVariantStock - Admin endpoint to add or remove on-hand stock
+func AdjustVariantStock(c *gin.Context) {
+	variantID := c.Param("id")
+	var req struct {
+		Quantity int    `json:"quantity" binding:"required,ne=0"`
+		Reason   string `json:"reason" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !authorizeVariant(c, variantID) {
+		return
+	}
+
+	err := services.AdjustStock(variantID, req.Quantity, middleware.GetUserID(c), req.Reason)
+	if errors.Is(err, services.ErrVariantNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product variant not found"})
+		return
+	}
+	if errors.Is(err, services.ErrInsufficientStock) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adjust stock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stock adjusted"})
+}
+
+// GetStockMovements - Admin endpoint to read the stock ledger of a variant
+func GetStockMovements(c *gin.Context) {
+	variantID := c.Param("id")
+	if !authorizeVariant(c, variantID) {
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, product_variant_id, movement_type, stock_delta, reserved_delta, stock_after, reserved_after, order_id, actor_id, reason, created_at
+		FROM stock_movements WHERE product_variant_id = ? ORDER BY created_at DESC, id
+	`, variantID)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock movements"})
+		return
+	}
+	defer rows.Close()
+
+	var movements []models.StockMovement
+	for rows.Next() {
+		var m models.StockMovement
+		var orderID, actorID, reason sql.NullString
+		err := rows.Scan(&m.ID, &m.ProductVariantID, &m.MovementType, &m.StockDelta, &m.ReservedDelta, &m.StockAfter, &m.ReservedAfter, &orderID, &actorID, &reason, &m.CreatedAt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan stock movement"})
+			return
+		}
+		m.OrderID = orderID.String
+		m.ActorID = actorID.String
+		m.Reason = reason.String
+		movements = append(movements, m)
+	}
+
+	if movements == nil {
+		movements = []models.StockMovement{}
+	}
+
+	c.JSON(http.StatusOK, movements)
+}
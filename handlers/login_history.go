@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/middleware"
+	"github.com/emyu/ecommer-be/models"
+	"github.com/gin-gonic/gin"
+)
+
+// GetMyLoginHistory lists the current user's recent login attempts, newest
+// first. ?limit= defaults to 50 and is capped at 200.
+func GetMyLoginHistory(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 {
+		limit = 50
+	}
+	if limit > 200 {
+		limit = 200
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, ip_address, user_agent, success, failure_reason, created_at
+		FROM login_history WHERE user_id = ?
+		ORDER BY created_at DESC LIMIT ?
+	`, middleware.GetUserID(c), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch login history"})
+		return
+	}
+	defer rows.Close()
+
+	entries := []models.LoginHistoryEntry{}
+	for rows.Next() {
+		var e models.LoginHistoryEntry
+		var ip, userAgent, reason sql.NullString
+		if err := rows.Scan(&e.ID, &ip, &userAgent, &e.Success, &reason, &e.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan login history"})
+			return
+		}
+		e.IPAddress, e.UserAgent, e.FailureReason = ip.String, userAgent.String, reason.String
+		entries = append(entries, e)
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
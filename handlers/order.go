@@ -2,19 +2,20 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
 
 	"github.com/emyu/ecommer-be/database"
 	"github.com/emyu/ecommer-be/middleware"
 	"github.com/emyu/ecommer-be/models"
-	"github.com/emyu/ecommer-be/utils"
+	"github.com/emyu/ecommer-be/services"
 	"github.com/gin-gonic/gin"
 )
 
 func GetUserOrders(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 	rows, err := database.DB.Query(`
-		SELECT id, user_id, order_number, total_amount, shipping_cost, status, payment_method, shipping_address_id, created_at, updated_at
+		SELECT id, user_id, order_number, subtotal, discount_amount, total_amount, shipping_cost, paid_amount, refunded_amount, status, payment_method, shipping_address_id, created_at, updated_at
 		FROM orders WHERE user_id = ? ORDER BY created_at DESC
 	`, userID)
 
@@ -27,7 +28,7 @@ func GetUserOrders(c *gin.Context) {
 	var orders []models.Order
 	for rows.Next() {
 		var order models.Order
-		err := rows.Scan(&order.ID, &order.UserID, &order.OrderNumber, &order.TotalAmount, &order.ShippingCost, &order.Status, &order.PaymentMethod, &order.ShippingAddressID, &order.CreatedAt, &order.UpdatedAt)
+		err := rows.Scan(&order.ID, &order.UserID, &order.OrderNumber, &order.Subtotal, &order.DiscountAmount, &order.TotalAmount, &order.ShippingCost, &order.PaidAmount, &order.RefundedAmount, &order.Status, &order.PaymentMethod, &order.ShippingAddressID, &order.CreatedAt, &order.UpdatedAt)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan order"})
 			return
@@ -57,7 +58,7 @@ func GetUserOrders(c *gin.Context) {
 // GetAllOrders - Admin endpoint to get all orders
 func GetAllOrders(c *gin.Context) {
 	rows, err := database.DB.Query(`
-		SELECT id, user_id, order_number, total_amount, shipping_cost, status, payment_method, shipping_address_id, created_at, updated_at
+		SELECT id, user_id, order_number, subtotal, discount_amount, total_amount, shipping_cost, paid_amount, refunded_amount, status, payment_method, shipping_address_id, created_at, updated_at
 		FROM orders ORDER BY created_at DESC
 	`)
 
@@ -70,7 +71,7 @@ func GetAllOrders(c *gin.Context) {
 	var orders []models.Order
 	for rows.Next() {
 		var order models.Order
-		err := rows.Scan(&order.ID, &order.UserID, &order.OrderNumber, &order.TotalAmount, &order.ShippingCost, &order.Status, &order.PaymentMethod, &order.ShippingAddressID, &order.CreatedAt, &order.UpdatedAt)
+		err := rows.Scan(&order.ID, &order.UserID, &order.OrderNumber, &order.Subtotal, &order.DiscountAmount, &order.TotalAmount, &order.ShippingCost, &order.PaidAmount, &order.RefundedAmount, &order.Status, &order.PaymentMethod, &order.ShippingAddressID, &order.CreatedAt, &order.UpdatedAt)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan order"})
 			return
@@ -100,7 +101,7 @@ func GetAllOrders(c *gin.Context) {
 // Helper function to get order items with product details
 func getOrderItems(orderID string) ([]models.OrderItem, error) {
 	rows, err := database.DB.Query(`
-		SELECT oi.id, oi.order_id, oi.product_variant_id, oi.quantity, oi.price,
+		SELECT oi.id, oi.order_id, oi.product_variant_id, oi.quantity, oi.price, oi.custom_name, oi.custom_number,
 		       pv.id, pv.product_id, pv.name,
 		       p.id, p.name, p.price
 		FROM order_items oi
@@ -119,10 +120,13 @@ func getOrderItems(orderID string) ([]models.OrderItem, error) {
 		var variantID, variantName, productID, productName sql.NullString
 		var productPrice sql.NullFloat64
 		var variantProductID sql.NullString
+		var customName, customNumber sql.NullString
 
-		rows.Scan(&item.ID, &item.OrderID, &item.ProductVariantID, &item.Quantity, &item.Price,
+		rows.Scan(&item.ID, &item.OrderID, &item.ProductVariantID, &item.Quantity, &item.Price, &customName, &customNumber,
 			&variantID, &variantProductID, &variantName,
 			&productID, &productName, &productPrice)
+		item.CustomName = customName.String
+		item.CustomNumber = customNumber.String
 
 		// Build ProductVariant with product info - but we need the product name in the item
 		// For now, just return the items with the variant
@@ -162,34 +166,110 @@ func getShippingAddressDetails(addressID string) (*models.ShippingAddress, error
 	return &address, nil
 }
 
-func GetOrderByID(c *gin.Context) {
-	orderID := c.Param("id")
+// Helper function to load an order with its items and shipping address
+func getOrderDetails(orderID string) (*models.Order, error) {
 	var order models.Order
-
 	err := database.DB.QueryRow(`
-		SELECT id, user_id, order_number, total_amount, shipping_cost, status, payment_method, shipping_address_id, created_at, updated_at
+		SELECT id, user_id, order_number, subtotal, discount_amount, total_amount, shipping_cost, paid_amount, refunded_amount, status, payment_method, shipping_address_id, created_at, updated_at
 		FROM orders WHERE id = ?
-	`, orderID).Scan(&order.ID, &order.UserID, &order.OrderNumber, &order.TotalAmount, &order.ShippingCost, &order.Status, &order.PaymentMethod, &order.ShippingAddressID, &order.CreatedAt, &order.UpdatedAt)
+	`, orderID).Scan(&order.ID, &order.UserID, &order.OrderNumber, &order.Subtotal, &order.DiscountAmount, &order.TotalAmount, &order.ShippingCost, &order.PaidAmount, &order.RefundedAmount, &order.Status, &order.PaymentMethod, &order.ShippingAddressID, &order.CreatedAt, &order.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	order.Items, _ = getOrderItems(order.ID)
+	order.ShippingAddress, _ = getShippingAddressDetails(order.ShippingAddressID)
+	order.StatusHistory, _ = getOrderStatusHistory(order.ID)
+	order.Discounts, _ = getOrderDiscounts(order.ID)
+
+	return &order, nil
+}
+
+// Helper function to get the discount lines of an order
+func getOrderDiscounts(orderID string) ([]models.OrderDiscount, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, order_id, coupon_id, code, description, amount, created_at
+		FROM order_discounts WHERE order_id = ? ORDER BY created_at, id
+	`, orderID)
+	if err != nil {
+		return []models.OrderDiscount{}, err
+	}
+	defer rows.Close()
+
+	var discounts []models.OrderDiscount
+	for rows.Next() {
+		var d models.OrderDiscount
+		var couponID, description sql.NullString
+		if err := rows.Scan(&d.ID, &d.OrderID, &couponID, &d.Code, &description, &d.Amount, &d.CreatedAt); err != nil {
+			return discounts, err
+		}
+		d.CouponID = couponID.String
+		d.Description = description.String
+		discounts = append(discounts, d)
+	}
+
+	return discounts, rows.Err()
+}
 
+// Helper function to get the status timeline of an order
+func getOrderStatusHistory(orderID string) ([]models.OrderStatusHistory, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, order_id, from_status, to_status, actor_type, actor_id, note, created_at
+		FROM order_status_history WHERE order_id = ? ORDER BY created_at, id
+	`, orderID)
+	if err != nil {
+		return []models.OrderStatusHistory{}, err
+	}
+	defer rows.Close()
+
+	var history []models.OrderStatusHistory
+	for rows.Next() {
+		var entry models.OrderStatusHistory
+		var fromStatus, actorID, note sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.OrderID, &fromStatus, &entry.ToStatus, &entry.ActorType, &actorID, &note, &entry.CreatedAt); err != nil {
+			return history, err
+		}
+		entry.FromStatus = fromStatus.String
+		entry.ActorID = actorID.String
+		entry.Note = note.String
+		history = append(history, entry)
+	}
+
+	return history, rows.Err()
+}
+
+func GetOrderByID(c *gin.Context) {
+	orderID := c.Param("id")
+	if !authorizeOwner(c, services.ResourceOrder, orderID) {
+		return
+	}
+
+	order, err := getOrderDetails(orderID)
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
 	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return
+	}
 
 	c.JSON(http.StatusOK, order)
 }
 
+// CreateOrder places an order for an explicit list of variants. Prices,
+// shipping cost and totals are always computed on the server.
 func CreateOrder(c *gin.Context) {
 	var req struct {
-		TotalAmount       float64 `json:"total_amount" binding:"required"`
-		ShippingCost      float64 `json:"shipping_cost" binding:"required"`
-		PaymentMethod     string  `json:"payment_method" binding:"required,oneof=qris bank_transfer ewallet credit_card e_wallet"`
-		ShippingAddressID string  `json:"shipping_address_id" binding:"required"`
+		PaymentMethod     string `json:"payment_method" binding:"required,oneof=qris bank_transfer ewallet credit_card e_wallet"`
+		ShippingAddressID string `json:"shipping_address_id" binding:"required"`
+		CouponCode        string `json:"coupon_code"`
 		Items             []struct {
-			ProductVariantID string  `json:"product_variant_id" binding:"required"`
-			Quantity         int     `json:"quantity" binding:"required,min=1"`
-			Price            float64 `json:"price" binding:"required"`
-		} `json:"items" binding:"required"`
+			ProductVariantID string `json:"product_variant_id" binding:"required"`
+			Quantity         int    `json:"quantity" binding:"required,min=1"`
+			CustomName       string `json:"custom_name"`
+			CustomNumber     string `json:"custom_number"`
+		} `json:"items" binding:"required,min=1,dive"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -197,56 +277,86 @@ func CreateOrder(c *gin.Context) {
 		return
 	}
 
-	userID := middleware.GetUserID(c)
-	orderID := utils.GenerateID()
-	orderNumber := utils.GenerateOrderNumber()
+	lines := make([]services.OrderLine, 0, len(req.Items))
+	for _, item := range req.Items {
+		lines = append(lines, services.OrderLine{
+			ProductVariantID: item.ProductVariantID,
+			Quantity:         item.Quantity,
+			CustomName:       item.CustomName,
+			CustomNumber:     item.CustomNumber,
+		})
+	}
 
-	// Begin transaction
-	tx, err := database.DB.Begin()
+	userID := middleware.GetUserID(c)
+	orderID, err := services.CreateOrder(userID, req.PaymentMethod, req.ShippingAddressID, lines, req.CouponCode)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		respondOrderError(c, err)
 		return
 	}
-	defer tx.Rollback()
 
-	// Create order
-	_, err = tx.Exec(`
-		INSERT INTO orders (id, user_id, order_number, total_amount, shipping_cost, status, payment_method, shipping_address_id)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`, orderID, userID, orderNumber, req.TotalAmount, req.ShippingCost, "pending", req.PaymentMethod, req.ShippingAddressID)
+	respondCreatedOrder(c, orderID)
+}
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
-		return
+// Checkout builds an order from the user's cart and empties the cart.
+func Checkout(c *gin.Context) {
+	var req struct {
+		PaymentMethod     string `json:"payment_method" binding:"required,oneof=qris bank_transfer ewallet credit_card e_wallet"`
+		ShippingAddressID string `json:"shipping_address_id" binding:"required"`
 	}
 
-	// Add order items
-	for _, item := range req.Items {
-		itemID := utils.GenerateID()
-		_, err := tx.Exec(`
-			INSERT INTO order_items (id, order_id, product_variant_id, quantity, price)
-			VALUES (?, ?, ?, ?, ?)
-		`, itemID, orderID, item.ProductVariantID, item.Quantity, item.Price)
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add order items"})
-			return
-		}
+	userID := middleware.GetUserID(c)
+	orderID, err := services.CheckoutCart(userID, req.PaymentMethod, req.ShippingAddressID)
+	if err != nil {
+		respondOrderError(c, err)
+		return
 	}
 
-	// Commit transaction
-	if err := tx.Commit(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+	respondCreatedOrder(c, orderID)
+}
+
+func respondCreatedOrder(c *gin.Context, orderID string) {
+	order, err := getOrderDetails(orderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Order created but could not be loaded"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"id": orderID, "order_number": orderNumber, "message": "Order created successfully"})
+	c.JSON(http.StatusCreated, order)
+}
+
+func respondOrderError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrEmailNotVerified):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCartEmpty):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+	case errors.Is(err, services.ErrInvalidQuantity):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrVariantNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrShippingAddressInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Shipping address not found"})
+	case errors.Is(err, services.ErrPaymentMethodDisabled):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Payment method is not available"})
+	case errors.Is(err, services.ErrInsufficientStock):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case isCouponError(err):
+		respondCouponError(c, err)
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+	}
 }
 
 func UpdateOrderStatus(c *gin.Context) {
 	orderID := c.Param("id")
 	var req struct {
 		Status string `json:"status" binding:"required,oneof=pending paid packed shipped delivered canceled"`
+		Note   string `json:"note"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -254,22 +364,47 @@ func UpdateOrderStatus(c *gin.Context) {
 		return
 	}
 
-	_, err := database.DB.Exec("UPDATE orders SET status = ? WHERE id = ?", req.Status, orderID)
+	err := services.ChangeOrderStatus(orderID, req.Status, services.ActorAdmin, middleware.GetUserID(c), req.Note)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+		respondTransitionError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Order status updated"})
 }
 
-func DeleteOrder(c *gin.Context) {
+// CancelOrder lets a customer cancel their own order while it is still pending.
+func CancelOrder(c *gin.Context) {
 	orderID := c.Param("id")
-	_, err := database.DB.Exec("DELETE FROM orders WHERE id = ?", orderID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete order"})
+	var req struct {
+		Reason string `json:"reason"`
+	}
+
+	// Body is optional
+	_ = c.ShouldBindJSON(&req)
+
+	note := "Canceled by customer"
+	if req.Reason != "" {
+		note = req.Reason
+	}
+
+	if err := services.CancelOrderByCustomer(orderID, middleware.GetUserID(c), note); err != nil {
+		respondTransitionError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Order deleted"})
+	c.JSON(http.StatusOK, gin.H{"message": "Order canceled"})
+}
+
+func respondTransitionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrOrderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+	case errors.Is(err, services.ErrInvalidTransition):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTransitionNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+	}
 }
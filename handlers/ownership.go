@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/emyu/ecommer-be/middleware"
+	"github.com/emyu/ecommer-be/services"
+	"github.com/gin-gonic/gin"
+)
+
+// authorizeOwner checks that the caller owns the resource, or holds the
+// permission that overrides ownership, and writes the error response if
+// not: 404 when the resource does not exist, 403 when it is someone else's.
+func authorizeOwner(c *gin.Context, res services.Resource, id string) bool {
+	err := services.CheckOwnership(res, id, middleware.GetUserID(c), middleware.GetPermissions(c))
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrResourceNotFound):
+		name := string(res)
+		c.JSON(http.StatusNotFound, gin.H{"error": strings.ToUpper(name[:1]) + name[1:] + " not found"})
+	case errors.Is(err, services.ErrNotOwner):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check access"})
+	}
+	return false
+}
+
+// canAccessAll reports whether the caller may see every user's resources
+// of this kind rather than only their own.
+func canAccessAll(c *gin.Context, res services.Resource) bool {
+	return services.CanAccessAll(res, middleware.GetPermissions(c))
+}
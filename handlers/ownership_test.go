@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// ownerDB is an in-memory database/sql driver that answers the owner
+// lookups of services.CheckOwnership from a fixed table and fails every
+// other statement, recording it. A handler that gets past the ownership
+// check therefore shows up as a recorded statement and a 500.
+type ownerDB struct {
+	mu       sync.Mutex
+	owners   map[string]string // resource id -> owner user id
+	executed []recordedStmt
+}
+
+type recordedStmt struct {
+	query string
+	args  []driver.Value
+}
+
+// ownerLookups are the queries CheckOwnership uses, by prefix.
+var ownerLookups = []string{
+	"SELECT user_id FROM carts WHERE id",
+	"SELECT c.user_id FROM cart_items ci JOIN carts c",
+	"SELECT user_id FROM orders WHERE id",
+	"SELECT o.user_id FROM payments p JOIN orders o",
+}
+
+var errUnexpectedStmt = errors.New("statement not supported by the test database")
+
+var testDB = &ownerDB{owners: map[string]string{
+	"cart-alice":    "alice",
+	"cart-bob":      "bob",
+	"item-alice":    "alice",
+	"item-bob":      "bob",
+	"order-alice":   "alice",
+	"order-bob":     "bob",
+	"payment-alice": "alice",
+	"payment-bob":   "bob",
+}}
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	sql.Register("ownertest", testDB)
+}
+
+func (d *ownerDB) Open(string) (driver.Conn, error) { return ownerConn{d}, nil }
+
+func (d *ownerDB) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.executed = nil
+}
+
+func (d *ownerDB) statements() []recordedStmt {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]recordedStmt(nil), d.executed...)
+}
+
+type ownerConn struct{ db *ownerDB }
+
+func (c ownerConn) Prepare(query string) (driver.Stmt, error) { return ownerStmt{c.db, query}, nil }
+func (c ownerConn) Close() error                              { return nil }
+func (c ownerConn) Begin() (driver.Tx, error)                 { return nil, errUnexpectedStmt }
+
+type ownerStmt struct {
+	db    *ownerDB
+	query string
+}
+
+func (s ownerStmt) Close() error  { return nil }
+func (s ownerStmt) NumInput() int { return -1 }
+
+func (s ownerStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.record(args)
+	return nil, errUnexpectedStmt
+}
+
+func (s ownerStmt) Query(args []driver.Value) (driver.Rows, error) {
+	query := strings.Join(strings.Fields(s.query), " ")
+	for _, prefix := range ownerLookups {
+		if strings.HasPrefix(query, prefix) {
+			id, _ := args[0].(string)
+			owner, ok := s.db.owners[id]
+			return &ownerRows{owner: owner, found: ok}, nil
+		}
+	}
+	s.record(args)
+	return nil, errUnexpectedStmt
+}
+
+func (s ownerStmt) record(args []driver.Value) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.executed = append(s.db.executed, recordedStmt{s.query, args})
+}
+
+type ownerRows struct {
+	owner string
+	found bool
+	done  bool
+}
+
+func (r *ownerRows) Columns() []string { return []string{"user_id"} }
+func (r *ownerRows) Close() error      { return nil }
+
+func (r *ownerRows) Next(dest []driver.Value) error {
+	if !r.found || r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.owner
+	return nil
+}
+
+// useTestDB points database.DB at the test database for one test.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	db, err := sql.Open("ownertest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := database.DB
+	database.DB = db
+	testDB.reset()
+	t.Cleanup(func() {
+		database.DB = previous
+		db.Close()
+	})
+}
+
+// serve runs one request against handler, as if the auth middleware had
+// authenticated userID with permissions.
+func serve(method, pattern, path, body, userID string, permissions []string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Handle(method, pattern, func(c *gin.Context) {
+		c.Set("userID", userID)
+		c.Set("permissions", permissions)
+		c.Next()
+	}, handler)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestOwnershipChecks(t *testing.T) {
+	type endpoint struct {
+		name     string
+		method   string
+		pattern  string
+		path     func(id string) string
+		body     func(id string) string
+		handler  gin.HandlerFunc
+		override string
+	}
+	noBody := func(string) string { return "" }
+
+	endpoints := []endpoint{
+		{
+			name: "RemoveFromCart", method: "DELETE", pattern: "/cart-items/:itemId",
+			path: func(id string) string { return "/cart-items/" + id }, body: noBody,
+			handler: RemoveFromCart, override: utils.PermManageOrders,
+		},
+		{
+			name: "UpdateCartItem", method: "PUT", pattern: "/cart-items/:itemId",
+			path: func(id string) string { return "/cart-items/" + id }, body: func(string) string { return `{"quantity": 2}` },
+			handler: UpdateCartItem, override: utils.PermManageOrders,
+		},
+		{
+			name: "AddToCart", method: "POST", pattern: "/cart-items",
+			path: func(string) string { return "/cart-items" },
+			body: func(id string) string {
+				return `{"cart_id": "` + id + `", "product_variant_id": "variant-1", "quantity": 1}`
+			},
+			handler: AddToCart, override: utils.PermManageOrders,
+		},
+		{
+			name: "GetOrderByID", method: "GET", pattern: "/orders/:id",
+			path: func(id string) string { return "/orders/" + id }, body: noBody,
+			handler: GetOrderByID, override: utils.PermManageOrders,
+		},
+		{
+			name: "GetPaymentByID", method: "GET", pattern: "/payments/:id",
+			path: func(id string) string { return "/payments/" + id }, body: noBody,
+			handler: GetPaymentByID, override: utils.PermManagePayments,
+		},
+		{
+			name: "GetPaymentQRImage", method: "GET", pattern: "/payments/:id/qris",
+			path: func(id string) string { return "/payments/" + id + "/qris" }, body: noBody,
+			handler: GetPaymentQRImage, override: utils.PermManagePayments,
+		},
+	}
+
+	// Resource ids per endpoint: the cart item endpoints take item ids,
+	// AddToCart a cart id, and so on.
+	ids := map[string]struct{ own, foreign string }{
+		"RemoveFromCart":    {"item-alice", "item-bob"},
+		"UpdateCartItem":    {"item-alice", "item-bob"},
+		"AddToCart":         {"cart-alice", "cart-bob"},
+		"GetOrderByID":      {"order-alice", "order-bob"},
+		"GetPaymentByID":    {"payment-alice", "payment-bob"},
+		"GetPaymentQRImage": {"payment-alice", "payment-bob"},
+	}
+
+	for _, ep := range endpoints {
+		cases := []struct {
+			name        string
+			id          string
+			permissions []string
+			wantStatus  int  // 0 means "got past the ownership check"
+			wantPass    bool // the handler went on to its own queries
+		}{
+			{"own resource", ids[ep.name].own, nil, 0, true},
+			{"foreign resource", ids[ep.name].foreign, nil, http.StatusForbidden, false},
+			{"unknown resource", "missing", nil, http.StatusNotFound, false},
+			{"foreign resource with override", ids[ep.name].foreign, []string{ep.override}, 0, true},
+			{"unknown resource with override", "missing", []string{ep.override}, http.StatusNotFound, false},
+			{"foreign resource with unrelated permission", ids[ep.name].foreign, []string{utils.PermViewReports}, http.StatusForbidden, false},
+		}
+
+		for _, tc := range cases {
+			t.Run(ep.name+"/"+tc.name, func(t *testing.T) {
+				useTestDB(t)
+				w := serve(ep.method, ep.pattern, ep.path(tc.id), ep.body(tc.id), "alice", tc.permissions, ep.handler)
+
+				if tc.wantStatus != 0 && w.Code != tc.wantStatus {
+					t.Fatalf("status = %d, want %d (body %s)", w.Code, tc.wantStatus, w.Body)
+				}
+				if tc.wantStatus == 0 && (w.Code == http.StatusForbidden || w.Code == http.StatusNotFound) {
+					t.Fatalf("status = %d, want the ownership check to pass (body %s)", w.Code, w.Body)
+				}
+				if passed := len(testDB.statements()) > 0; passed != tc.wantPass {
+					t.Fatalf("handler ran its own statements = %v, want %v", passed, tc.wantPass)
+				}
+			})
+		}
+	}
+}
+
+func TestGetPaymentsScoping(t *testing.T) {
+	cases := []struct {
+		name        string
+		permissions []string
+		wantAll     bool
+	}{
+		{"customer sees own payments", nil, false},
+		{"manage_orders does not widen payments", []string{utils.PermManageOrders}, false},
+		{"manage_payments sees all payments", []string{utils.PermManagePayments}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			useTestDB(t)
+			serve("GET", "/payments", "/payments", "", "alice", tc.permissions, GetPayments)
+
+			stmts := testDB.statements()
+			if len(stmts) != 1 {
+				t.Fatalf("got %d statements, want 1", len(stmts))
+			}
+			args := stmts[0].args
+			if len(args) != 2 {
+				t.Fatalf("got %d query args, want 2", len(args))
+			}
+			if all, _ := args[0].(bool); all != tc.wantAll {
+				t.Errorf("all-payments flag = %v, want %v", args[0], tc.wantAll)
+			}
+			if args[1] != "alice" {
+				t.Errorf("user filter = %v, want alice", args[1])
+			}
+		})
+	}
+}
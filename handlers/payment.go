@@ -2,20 +2,85 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/emyu/ecommer-be/config"
 	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/middleware"
 	"github.com/emyu/ecommer-be/models"
-	"github.com/emyu/ecommer-be/utils"
+	"github.com/emyu/ecommer-be/payment"
+	"github.com/emyu/ecommer-be/services"
 	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
 )
 
-func GetPayments(c *gin.Context) {
+const paymentColumns = `id, order_id, payment_status, payment_code, amount, channel, bank, va_number, provider, provider_ref, checkout_url, qr_payload, expires_at, paid_at, paid_late, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Helper function to scan a payments row selected with paymentColumns
+func scanPayment(row rowScanner) (models.Payment, error) {
+	var p models.Payment
+	var code, channel, bank, vaNumber, provider, providerRef, checkoutURL, qrPayload sql.NullString
+	err := row.Scan(&p.ID, &p.OrderID, &p.PaymentStatus, &code, &p.Amount, &channel, &bank, &vaNumber, &provider, &providerRef, &checkoutURL, &qrPayload, &p.ExpiresAt, &p.PaidAt, &p.PaidLate, &p.CreatedAt, &p.UpdatedAt)
+	p.PaymentCode = code.String
+	p.Channel = channel.String
+	p.Bank = bank.String
+	p.VANumber = vaNumber.String
+	p.Provider = provider.String
+	p.ProviderRef = providerRef.String
+	p.CheckoutURL = checkoutURL.String
+	p.QRPayload = qrPayload.String
+	p.Refunds = []models.Refund{}
+	return p, err
+}
+
+// Helper function to load the refund history of a payment
+func getPaymentRefunds(paymentID string) ([]models.Refund, error) {
 	rows, err := database.DB.Query(`
-		SELECT id, order_id, payment_status, payment_code, paid_at, created_at, updated_at
-		FROM payments ORDER BY created_at DESC
-	`)
+		SELECT id, payment_id, order_id, amount, reason, status, provider_ref, failure_reason, requested_by, created_at, updated_at
+		FROM refunds WHERE payment_id = ? ORDER BY created_at, id
+	`, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := []models.Refund{}
+	for rows.Next() {
+		var r models.Refund
+		var providerRef, failureReason sql.NullString
+		err := rows.Scan(&r.ID, &r.PaymentID, &r.OrderID, &r.Amount, &r.Reason, &r.Status, &providerRef, &failureReason, &r.RequestedBy, &r.CreatedAt, &r.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		r.ProviderRef = providerRef.String
+		r.FailureReason = failureReason.String
+		refunds = append(refunds, r)
+	}
+
+	return refunds, rows.Err()
+}
+
+// GetPayments lists the caller's payments; staff with manage_payments see
+// every payment. ?paid_late=true keeps only payments that were paid after
+// they had expired or failed.
+func GetPayments(c *gin.Context) {
+	query := `SELECT ` + paymentColumns + ` FROM payments
+		WHERE (? OR order_id IN (SELECT id FROM orders WHERE user_id = ?))`
+	args := []interface{}{canAccessAll(c, services.ResourcePayment), middleware.GetUserID(c)}
+	if c.Query("paid_late") == "true" {
+		query += " AND paid_late = TRUE"
+	}
+
+	rows, err := database.DB.Query(query+" ORDER BY created_at DESC", args...)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payments"})
@@ -25,9 +90,21 @@ func GetPayments(c *gin.Context) {
 
 	var payments []models.Payment
 	for rows.Next() {
-		var payment models.Payment
-		rows.Scan(&payment.ID, &payment.OrderID, &payment.PaymentStatus, &payment.PaymentCode, &payment.PaidAt, &payment.CreatedAt, &payment.UpdatedAt)
-		payments = append(payments, payment)
+		p, err := scanPayment(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan payment"})
+			return
+		}
+		payments = append(payments, p)
+	}
+
+	for i := range payments {
+		refunds, err := getPaymentRefunds(payments[i].ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch refunds"})
+			return
+		}
+		payments[i].Refunds = refunds
 	}
 
 	if payments == nil {
@@ -39,24 +116,34 @@ func GetPayments(c *gin.Context) {
 
 func GetPaymentByID(c *gin.Context) {
 	paymentID := c.Param("id")
-	var payment models.Payment
-
-	err := database.DB.QueryRow(`
-		SELECT id, order_id, payment_status, payment_code, paid_at, created_at, updated_at
-		FROM payments WHERE id = ?
-	`, paymentID).Scan(&payment.ID, &payment.OrderID, &payment.PaymentStatus, &payment.PaymentCode, &payment.PaidAt, &payment.CreatedAt, &payment.UpdatedAt)
+	if !authorizeOwner(c, services.ResourcePayment, paymentID) {
+		return
+	}
 
+	p, err := scanPayment(database.DB.QueryRow(`SELECT `+paymentColumns+` FROM payments WHERE id = ?`, paymentID))
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
 		return
 	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment"})
+		return
+	}
+
+	if p.Refunds, err = getPaymentRefunds(p.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch refunds"})
+		return
+	}
 
-	c.JSON(http.StatusOK, payment)
+	c.JSON(http.StatusOK, p)
 }
 
+// CreatePayment opens a charge for the order at the provider that handles
+// its payment method.
 func CreatePayment(c *gin.Context) {
 	var req struct {
 		OrderID string `json:"order_id" binding:"required"`
+		Bank    string `json:"bank" binding:"omitempty,oneof=bca bni mandiri bri"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -64,26 +151,218 @@ func CreatePayment(c *gin.Context) {
 		return
 	}
 
-	paymentID := utils.GenerateID()
-	paymentCode := utils.GeneratePaymentCode()
+	paymentID, err := services.CreatePayment(c.Request.Context(), req.OrderID, middleware.GetUserID(c), req.Bank)
+	switch {
+	case errors.Is(err, services.ErrOrderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	case errors.Is(err, services.ErrOrderNotPayable):
+		c.JSON(http.StatusConflict, gin.H{"error": "Order is not awaiting payment"})
+		return
+	case errors.Is(err, services.ErrUnsupportedBank):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrPaymentMethodDisabled):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Payment method is not available"})
+		return
+	case errors.Is(err, services.ErrPaymentProvider):
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Payment provider unavailable"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment"})
+		return
+	}
+
+	p, err := scanPayment(database.DB.QueryRow(`SELECT `+paymentColumns+` FROM payments WHERE id = ?`, paymentID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Payment created but could not be loaded"})
+		return
+	}
 
-	_, err := database.DB.Exec(`
-		INSERT INTO payments (id, order_id, payment_status, payment_code)
-		VALUES (?, ?, ?, ?)
-	`, paymentID, req.OrderID, "pending", paymentCode)
+	c.JSON(http.StatusCreated, p)
+}
+
+// FakePaymentAction - Development endpoint that settles, fails or expires a
+// charge held by the fake provider and delivers the result back.
+func FakePaymentAction(c *gin.Context) {
+	paymentID := c.Param("id")
+	action := c.Param("action")
+	if !authorizeOwner(c, services.ResourcePayment, paymentID) {
+		return
+	}
 
+	var providerName, providerRef string
+	err := database.DB.QueryRow("SELECT provider, provider_ref FROM payments WHERE id = ?", paymentID).Scan(&providerName, &providerRef)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment"})
+		return
+	}
+
+	provider, _ := payment.Get(providerName)
+	fake, ok := provider.(*payment.FakeProvider)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment is not handled by the fake provider"})
+		return
+	}
+
+	switch action {
+	case "settle":
+		err = fake.Settle(providerRef)
+	case "fail":
+		err = fake.Fail(providerRef)
+	case "expire":
+		err = fake.Expire(providerRef)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Action must be settle, fail or expire"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Deliver the change the way the provider would: as a signed webhook
+	// when a secret is configured, otherwise by polling the charge status.
+	if secret := config.AppConfig.PaymentWebhookSecrets[fake.Name()]; secret != "" {
+		body, nerr := fake.NotificationFor(providerRef)
+		if nerr == nil {
+			_, err = services.HandlePaymentNotification(fake.Name(), body, payment.Sign(secret, body))
+		} else {
+			err = nerr
+		}
+	} else {
+		err = services.SyncPaymentStatus(c.Request.Context(), paymentID)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync payment status"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"id": paymentID, "payment_code": paymentCode, "message": "Payment created"})
+	c.JSON(http.StatusOK, gin.H{"message": "Fake charge updated", "action": action})
 }
 
-func UpdatePaymentStatus(c *gin.Context) {
+// GetPaymentQRImage renders a payment's QRIS payload as a PNG (default) or
+// SVG image. The image is only served while the payment is pending and
+// unexpired, and is cacheable until the payment expires.
+func GetPaymentQRImage(c *gin.Context) {
+	paymentID := c.Param("id")
+	if !authorizeOwner(c, services.ResourcePayment, paymentID) {
+		return
+	}
+
+	p, err := scanPayment(database.DB.QueryRow(`SELECT `+paymentColumns+` FROM payments WHERE id = ?`, paymentID))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment"})
+		return
+	}
+
+	if p.QRPayload == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment has no QR code"})
+		return
+	}
+	if p.PaymentStatus != services.PaymentStatusPending || (p.ExpiresAt != nil && time.Now().After(*p.ExpiresAt)) {
+		c.JSON(http.StatusGone, gin.H{"error": "QR code has expired"})
+		return
+	}
+
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "320"))
+	if size < 128 || size > 1024 {
+		size = 320
+	}
+
+	if p.ExpiresAt != nil {
+		maxAge := int(time.Until(*p.ExpiresAt).Seconds())
+		c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+		c.Header("Expires", p.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+
+	switch c.DefaultQuery("format", "png") {
+	case "png":
+		png, err := qrcode.Encode(p.QRPayload, qrcode.Medium, size)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render QR code"})
+			return
+		}
+		c.Data(http.StatusOK, "image/png", png)
+	case "svg":
+		qr, err := qrcode.New(p.QRPayload, qrcode.Medium)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render QR code"})
+			return
+		}
+		c.Data(http.StatusOK, "image/svg+xml", renderQRSVG(qr.Bitmap(), size))
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be png or svg"})
+	}
+}
+
+// Helper function to draw a QR bitmap as an SVG of unit squares
+func renderQRSVG(bitmap [][]bool, size int) []byte {
+	var b strings.Builder
+	n := len(bitmap)
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, n, n)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return []byte(b.String())
+}
+
+// ImportBankMutations - Admin endpoint that imports a bank statement CSV and
+// settles pending virtual account payments it can match. The CSV is sent as
+// the "file" field of a multipart form, with the bank in the "bank" field.
+func ImportBankMutations(c *gin.Context) {
+	bank := c.PostForm("bank")
+	if bank == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bank is required"})
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CSV file is required"})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read CSV file"})
+		return
+	}
+	defer file.Close()
+
+	result, err := services.ImportBankMutations(bank, file, middleware.GetUserID(c))
+	switch {
+	case errors.Is(err, services.ErrUnsupportedBank), errors.Is(err, services.ErrInvalidMutationFile):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import bank mutations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// CreateRefund - Admin endpoint that refunds all or part of a successful
+// payment. Leaving out amount refunds whatever has not been refunded yet.
+func CreateRefund(c *gin.Context) {
 	paymentID := c.Param("id")
 	var req struct {
-		Status string `json:"status" binding:"required,oneof=pending success failed"`
+		Amount float64 `json:"amount" binding:"gte=0"`
+		Reason string  `json:"reason" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -91,21 +370,62 @@ func UpdatePaymentStatus(c *gin.Context) {
 		return
 	}
 
-	var paidAt *time.Time
-	if req.Status == "success" {
-		now := time.Now()
-		paidAt = &now
+	refundID, err := services.RequestRefund(c.Request.Context(), paymentID, req.Amount, req.Reason, middleware.GetUserID(c))
+	switch {
+	case errors.Is(err, services.ErrPaymentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	case errors.Is(err, services.ErrPaymentNotRefundable), errors.Is(err, services.ErrInvalidRefundAmount):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrPaymentProvider):
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "refund_id": refundID})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refund payment"})
+		return
+	}
+
+	refunds, err := getPaymentRefunds(paymentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Refund created but could not be loaded"})
+		return
+	}
+	for _, r := range refunds {
+		if r.ID == refundID {
+			c.JSON(http.StatusCreated, r)
+			return
+		}
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Refund created but could not be loaded"})
+}
+
+// UpdateRefundStatus - Admin endpoint to record the outcome of a refund the
+// provider is still processing, e.g. after checking the gateway dashboard.
+func UpdateRefundStatus(c *gin.Context) {
+	refundID := c.Param("id")
+	var req struct {
+		Status        string `json:"status" binding:"required,oneof=succeeded failed"`
+		FailureReason string `json:"failure_reason"`
 	}
 
-	_, err := database.DB.Exec(
-		"UPDATE payments SET payment_status = ?, paid_at = ? WHERE id = ?",
-		req.Status, paidAt, paymentID,
-	)
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment"})
+	err := services.ApplyRefundStatus(refundID, req.Status, "", req.FailureReason)
+	switch {
+	case errors.Is(err, services.ErrRefundNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Refund not found"})
+		return
+	case errors.Is(err, services.ErrRefundFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update refund"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Payment status updated"})
+	c.JSON(http.StatusOK, gin.H{"message": "Refund updated"})
 }
@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/emyu/ecommer-be/config"
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/middleware"
+	"github.com/emyu/ecommer-be/models"
+	"github.com/emyu/ecommer-be/services"
+	"github.com/gin-gonic/gin"
+)
+
+const payoutBatchColumns = `id, status, total_amount, item_count, created_by, paid_reference, paid_by, paid_at, canceled_at, created_at, updated_at`
+
+func scanPayoutBatch(row rowScanner) (models.PayoutBatch, error) {
+	var b models.PayoutBatch
+	var reference, paidBy sql.NullString
+	err := row.Scan(&b.ID, &b.Status, &b.TotalAmount, &b.ItemCount, &b.CreatedBy, &reference, &paidBy, &b.PaidAt, &b.CanceledAt, &b.CreatedAt, &b.UpdatedAt)
+	b.PaidReference = reference.String
+	b.PaidBy = paidBy.String
+	return b, err
+}
+
+// Helper function to load the transfers of a payout batch
+func getPayoutItems(batchID string) ([]models.PayoutItem, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, batch_id, seller_id, amount, bank_name, account_number, account_name, created_at
+		FROM payout_items WHERE batch_id = ? ORDER BY seller_id
+	`, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.PayoutItem{}
+	for rows.Next() {
+		var item models.PayoutItem
+		if err := rows.Scan(&item.ID, &item.BatchID, &item.SellerID, &item.Amount, &item.BankName, &item.AccountNumber, &item.AccountName, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// Helper function to load ledger entries, newest first; an empty sellerID
+// means every seller
+func getLedgerEntries(sellerID string) ([]models.LedgerEntry, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, seller_id, entry_type, gross_amount, commission_amount, amount, balance_after, order_id, order_item_id, refund_id, payout_batch_id, description, created_at
+		FROM seller_ledger_entries WHERE (? = '' OR seller_id = ?)
+		ORDER BY created_at DESC, id
+	`, sellerID, sellerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.LedgerEntry{}
+	for rows.Next() {
+		var e models.LedgerEntry
+		var gross, commission sql.NullFloat64
+		var orderID, orderItemID, batchID sql.NullString
+		err := rows.Scan(&e.ID, &e.SellerID, &e.EntryType, &gross, &commission, &e.Amount, &e.BalanceAfter, &orderID, &orderItemID, &e.RefundID, &batchID, &e.Description, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if gross.Valid {
+			e.GrossAmount = &gross.Float64
+		}
+		if commission.Valid {
+			e.CommissionAmount = &commission.Float64
+		}
+		e.OrderID = orderID.String
+		e.OrderItemID = orderItemID.String
+		e.PayoutBatchID = batchID.String
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// GetSellerBalances - Admin endpoint listing what is owed to each seller
+func GetSellerBalances(c *gin.Context) {
+	rows, err := database.DB.Query(`
+		SELECT sb.seller_id, u.name, u.email, sb.balance, a.seller_id IS NOT NULL, sb.updated_at
+		FROM seller_balances sb
+		JOIN users u ON u.id = sb.seller_id
+		LEFT JOIN seller_payout_accounts a ON a.seller_id = sb.seller_id
+		ORDER BY sb.balance DESC
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch seller balances"})
+		return
+	}
+	defer rows.Close()
+
+	balances := []models.SellerBalance{}
+	for rows.Next() {
+		var b models.SellerBalance
+		if err := rows.Scan(&b.SellerID, &b.SellerName, &b.SellerEmail, &b.Balance, &b.HasPayoutAccount, &b.UpdatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan seller balance"})
+			return
+		}
+		balances = append(balances, b)
+	}
+
+	c.JSON(http.StatusOK, balances)
+}
+
+// GetLedger - Admin endpoint to read the seller ledger, optionally for one seller
+func GetLedger(c *gin.Context) {
+	entries, err := getLedgerEntries(c.Query("seller_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ledger"})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+func GetPayoutBatches(c *gin.Context) {
+	rows, err := database.DB.Query(`SELECT ` + payoutBatchColumns + ` FROM payout_batches ORDER BY created_at DESC`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payout batches"})
+		return
+	}
+	defer rows.Close()
+
+	batches := []models.PayoutBatch{}
+	for rows.Next() {
+		b, err := scanPayoutBatch(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan payout batch"})
+			return
+		}
+		batches = append(batches, b)
+	}
+
+	c.JSON(http.StatusOK, batches)
+}
+
+func GetPayoutBatchByID(c *gin.Context) {
+	b, err := scanPayoutBatch(database.DB.QueryRow(`SELECT `+payoutBatchColumns+` FROM payout_batches WHERE id = ?`, c.Param("id")))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payout batch not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payout batch"})
+		return
+	}
+
+	if b.Items, err = getPayoutItems(b.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payout items"})
+		return
+	}
+
+	c.JSON(http.StatusOK, b)
+}
+
+// CreatePayoutBatch - Admin endpoint that moves every due seller balance
+// into a new pending batch
+func CreatePayoutBatch(c *gin.Context) {
+	var req struct {
+		MinAmount *float64 `json:"min_amount"`
+	}
+
+	// Body is optional
+	_ = c.ShouldBindJSON(&req)
+
+	minAmount := config.AppConfig.PayoutMinimumAmount
+	if req.MinAmount != nil {
+		if *req.MinAmount < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "min_amount cannot be negative"})
+			return
+		}
+		minAmount = *req.MinAmount
+	}
+
+	batchID, skipped, err := services.CreatePayoutBatch(minAmount, middleware.GetUserID(c))
+	if errors.Is(err, services.ErrNothingToPay) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "skipped_sellers": skipped})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payout batch"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"id": batchID, "skipped_sellers": skipped, "message": "Payout batch created"})
+}
+
+// ExportPayoutBatch - Admin endpoint returning the transfers of a batch as
+// CSV for the bank
+func ExportPayoutBatch(c *gin.Context) {
+	batchID := c.Param("id")
+	var exists int
+	if err := database.DB.QueryRow("SELECT COUNT(*) FROM payout_batches WHERE id = ?", batchID).Scan(&exists); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payout batch"})
+		return
+	}
+	if exists == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payout batch not found"})
+		return
+	}
+
+	items, err := getPayoutItems(batchID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payout items"})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="payout-%s.csv"`, batchID))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"seller_id", "bank_name", "account_number", "account_name", "amount", "reference"})
+	for _, item := range items {
+		w.Write([]string{
+			item.SellerID,
+			csvCell(item.BankName),
+			csvCell(item.AccountNumber),
+			csvCell(item.AccountName),
+			strconv.FormatFloat(item.Amount, 'f', 2, 64),
+			"PAYOUT-" + batchID,
+		})
+	}
+	w.Flush()
+}
+
+// csvCell keeps seller-entered text from being read as a formula when the
+// export is opened in a spreadsheet.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func MarkPayoutBatchPaid(c *gin.Context) {
+	var req struct {
+		Reference string `json:"reference" binding:"required,max=100"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.MarkPayoutBatchPaid(c.Param("id"), req.Reference, middleware.GetUserID(c)); err != nil {
+		respondPayoutError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payout batch marked as paid"})
+}
+
+func CancelPayoutBatch(c *gin.Context) {
+	if err := services.CancelPayoutBatch(c.Param("id")); err != nil {
+		respondPayoutError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payout batch canceled"})
+}
+
+// GetMySellerBalance - Seller endpoint with the current balance and ledger
+func GetMySellerBalance(c *gin.Context) {
+	sellerID := middleware.GetUserID(c)
+
+	var balance float64
+	err := database.DB.QueryRow("SELECT balance FROM seller_balances WHERE seller_id = ?", sellerID).Scan(&balance)
+	if err != nil && err != sql.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch balance"})
+		return
+	}
+
+	entries, err := getLedgerEntries(sellerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ledger"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"seller_id":          sellerID,
+		"balance":            balance,
+		"commission_percent": config.AppConfig.CommissionPercent,
+		"ledger":             entries,
+	})
+}
+
+func GetMyPayoutAccount(c *gin.Context) {
+	var a models.PayoutAccount
+	err := database.DB.QueryRow(`
+		SELECT seller_id, bank_name, account_number, account_name, updated_at
+		FROM seller_payout_accounts WHERE seller_id = ?
+	`, middleware.GetUserID(c)).Scan(&a.SellerID, &a.BankName, &a.AccountNumber, &a.AccountName, &a.UpdatedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No payout account set"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payout account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, a)
+}
+
+// UpdateMyPayoutAccount - Seller endpoint to set where payouts are sent.
+// Batches that already exist keep the account they were created with.
+func UpdateMyPayoutAccount(c *gin.Context) {
+	var req struct {
+		BankName      string `json:"bank_name" binding:"required,max=50"`
+		AccountNumber string `json:"account_number" binding:"required,numeric,max=34"`
+		AccountName   string `json:"account_name" binding:"required,max=100"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err := database.DB.Exec(`
+		INSERT INTO seller_payout_accounts (seller_id, bank_name, account_number, account_name)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE bank_name = VALUES(bank_name), account_number = VALUES(account_number), account_name = VALUES(account_name)
+	`, middleware.GetUserID(c), req.BankName, req.AccountNumber, req.AccountName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save payout account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payout account saved"})
+}
+
+func respondPayoutError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrPayoutBatchNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payout batch not found"})
+	case errors.Is(err, services.ErrPayoutBatchFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payout batch"})
+	}
+}
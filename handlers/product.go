@@ -2,20 +2,24 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/middleware"
 	"github.com/emyu/ecommer-be/models"
+	"github.com/emyu/ecommer-be/services"
 	"github.com/emyu/ecommer-be/utils"
 	"github.com/gin-gonic/gin"
 )
 
 func GetAllProducts(c *gin.Context) {
+	sellerID := c.Query("seller_id")
 	rows, err := database.DB.Query(`
-		SELECT id, name, description, price, category_id, is_customizable, created_at, updated_at
-		FROM products ORDER BY created_at DESC
-	`)
+		SELECT id, name, description, price, category_id, seller_id, is_customizable, created_at, updated_at
+		FROM products WHERE (? = '' OR seller_id = ?) ORDER BY created_at DESC
+	`, sellerID, sellerID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
 		return
@@ -25,9 +29,11 @@ func GetAllProducts(c *gin.Context) {
 	var products []models.Product
 	for rows.Next() {
 		var p models.Product
-		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.CategoryID, &p.IsCustomizable, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		var sellerID sql.NullString
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.CategoryID, &sellerID, &p.IsCustomizable, &p.CreatedAt, &p.UpdatedAt); err != nil {
 			continue
 		}
+		p.SellerID = sellerID.String
 		products = append(products, p)
 	}
 
@@ -41,16 +47,18 @@ func GetAllProducts(c *gin.Context) {
 func GetProductByID(c *gin.Context) {
 	id := c.Param("id")
 	var p models.Product
+	var sellerID sql.NullString
 
 	err := database.DB.QueryRow(`
-		SELECT id, name, description, price, category_id, is_customizable, created_at, updated_at
+		SELECT id, name, description, price, category_id, seller_id, is_customizable, created_at, updated_at
 		FROM products WHERE id = ?
-	`, id).Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.CategoryID, &p.IsCustomizable, &p.CreatedAt, &p.UpdatedAt)
+	`, id).Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.CategoryID, &sellerID, &p.IsCustomizable, &p.CreatedAt, &p.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
+	p.SellerID = sellerID.String
 
 	c.JSON(http.StatusOK, p)
 }
@@ -61,6 +69,7 @@ func CreateProduct(c *gin.Context) {
 		Description    string  `json:"description"`
 		Price          float64 `json:"price" binding:"required"`
 		CategoryID     *string `json:"category_id"`
+		SellerID       *string `json:"seller_id"`
 		IsCustomizable bool    `json:"is_customizable"`
 	}
 
@@ -71,6 +80,19 @@ func CreateProduct(c *gin.Context) {
 
 	productID := utils.GenerateID()
 
+	// Sellers always own what they create; admins may list a product for a
+	// seller or leave it as a store product.
+	var sellerID interface{} = middleware.GetUserID(c)
+	if canManageAllProducts(c) {
+		sellerID = nil
+		if req.SellerID != nil && *req.SellerID != "" {
+			if !checkSeller(c, *req.SellerID) {
+				return
+			}
+			sellerID = *req.SellerID
+		}
+	}
+
 	// Handle nullable category_id
 	var categoryID interface{}
 	if req.CategoryID != nil && *req.CategoryID != "" {
@@ -80,9 +102,9 @@ func CreateProduct(c *gin.Context) {
 	}
 
 	_, err := database.DB.Exec(`
-		INSERT INTO products (id, name, description, price, category_id, is_customizable)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, productID, req.Name, req.Description, req.Price, categoryID, req.IsCustomizable)
+		INSERT INTO products (id, name, description, price, category_id, seller_id, is_customizable)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, productID, req.Name, req.Description, req.Price, categoryID, sellerID, req.IsCustomizable)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
@@ -99,6 +121,7 @@ func UpdateProduct(c *gin.Context) {
 		Description    *string  `json:"description"`
 		Price          *float64 `json:"price"`
 		CategoryID     *string  `json:"category_id"`
+		SellerID       *string  `json:"seller_id"`
 		IsCustomizable *bool    `json:"is_customizable"`
 	}
 
@@ -107,6 +130,10 @@ func UpdateProduct(c *gin.Context) {
 		return
 	}
 
+	if !authorizeProduct(c, id) {
+		return
+	}
+
 	var updates []string
 	var args []interface{}
 
@@ -134,6 +161,21 @@ func UpdateProduct(c *gin.Context) {
 		updates = append(updates, "is_customizable = ?")
 		args = append(args, *req.IsCustomizable)
 	}
+	if req.SellerID != nil {
+		if !canManageAllProducts(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can change the seller of a product"})
+			return
+		}
+		if *req.SellerID != "" {
+			if !checkSeller(c, *req.SellerID) {
+				return
+			}
+			updates = append(updates, "seller_id = ?")
+			args = append(args, *req.SellerID)
+		} else {
+			updates = append(updates, "seller_id = NULL")
+		}
+	}
 
 	args = append(args, id)
 
@@ -150,6 +192,10 @@ func UpdateProduct(c *gin.Context) {
 
 func DeleteProduct(c *gin.Context) {
 	id := c.Param("id")
+	if !authorizeProduct(c, id) {
+		return
+	}
+
 	_, err := database.DB.Exec("DELETE FROM products WHERE id = ?", id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product"})
@@ -158,3 +204,67 @@ func DeleteProduct(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Product deleted"})
 }
+
+// canManageAllProducts reports whether the caller may manage products of
+// every seller rather than only their own.
+func canManageAllProducts(c *gin.Context) bool {
+	return utils.HasPermission(middleware.GetPermissions(c), utils.PermManageAllProducts)
+}
+
+// checkSeller checks that an admin-supplied seller_id names a seller and
+// writes the error response if not.
+func checkSeller(c *gin.Context, sellerID string) bool {
+	err := services.CheckSeller(sellerID)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrInvalidSeller):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check seller"})
+	}
+	return false
+}
+
+// authorizeProduct checks that the caller may change a product and writes
+// the error response if not.
+func authorizeProduct(c *gin.Context, productID string) bool {
+	var sellerID sql.NullString
+	err := database.DB.QueryRow("SELECT seller_id FROM products WHERE id = ?", productID).Scan(&sellerID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return false
+	}
+	return authorizeSeller(c, sellerID.String, "Product")
+}
+
+// authorizeVariant is authorizeProduct for the product of a variant.
+func authorizeVariant(c *gin.Context, variantID string) bool {
+	var sellerID sql.NullString
+	err := database.DB.QueryRow(`
+		SELECT p.seller_id FROM product_variants pv
+		JOIN products p ON p.id = pv.product_id
+		WHERE pv.id = ?
+	`, variantID).Scan(&sellerID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product variant not found"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product variant"})
+		return false
+	}
+	return authorizeSeller(c, sellerID.String, "Product variant")
+}
+
+func authorizeSeller(c *gin.Context, sellerID, what string) bool {
+	if canManageAllProducts(c) || sellerID == middleware.GetUserID(c) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": what + " belongs to another seller"})
+	return false
+}
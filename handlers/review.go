@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"database/sql"
 	"net/http"
 	"time"
 
@@ -9,6 +8,7 @@ import (
 	"github.com/emyu/ecommer-be/database"
 	"github.com/emyu/ecommer-be/models"
 	"github.com/emyu/ecommer-be/middleware"
+	"github.com/emyu/ecommer-be/services"
 	"github.com/emyu/ecommer-be/utils"
 )
 
@@ -106,22 +106,11 @@ func UpdateReview(c *gin.Context) {
 		return
 	}
 
-	userID := middleware.GetUserID(c)
-
-	// Check if review belongs to user
-	var ownerID string
-	err := database.DB.QueryRow("SELECT user_id FROM reviews WHERE id = ?", reviewID).Scan(&ownerID)
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
-		return
-	}
-
-	if ownerID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized"})
+	if !authorizeOwner(c, services.ResourceReview, reviewID) {
 		return
 	}
 
-	_, err = database.DB.Exec(`
+	_, err := database.DB.Exec(`
 		UPDATE reviews SET rating = ?, comment = ? WHERE id = ?
 	`, req.Rating, req.Comment, reviewID)
 
@@ -135,22 +124,11 @@ func UpdateReview(c *gin.Context) {
 
 func DeleteReview(c *gin.Context) {
 	reviewID := c.Param("id")
-	userID := middleware.GetUserID(c)
-
-	// Check if review belongs to user
-	var ownerID string
-	err := database.DB.QueryRow("SELECT user_id FROM reviews WHERE id = ?", reviewID).Scan(&ownerID)
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
-		return
-	}
-
-	if ownerID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized"})
+	if !authorizeOwner(c, services.ResourceReview, reviewID) {
 		return
 	}
 
-	_, err = database.DB.Exec("DELETE FROM reviews WHERE id = ?", reviewID)
+	_, err := database.DB.Exec("DELETE FROM reviews WHERE id = ?", reviewID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete review"})
 		return
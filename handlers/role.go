@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/models"
+	"github.com/emyu/ecommer-be/services"
+	"github.com/emyu/ecommer-be/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// Helper function to load the permissions granted to a role
+func getRolePermissions(roleID int) ([]string, error) {
+	rows, err := database.DB.Query("SELECT permission FROM role_permissions WHERE role_id = ? ORDER BY permission", roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var perm string
+		if err := rows.Scan(&perm); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, perm)
+	}
+	return permissions, rows.Err()
+}
+
+func GetRoles(c *gin.Context) {
+	rows, err := database.DB.Query("SELECT id, name, description, is_active, created_at, updated_at FROM roles ORDER BY id")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch roles"})
+		return
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		var role models.Role
+		var description sql.NullString
+		if err := rows.Scan(&role.ID, &role.Name, &description, &role.IsActive, &role.CreatedAt, &role.UpdatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan role"})
+			return
+		}
+		role.Description = description.String
+		roles = append(roles, role)
+	}
+
+	for i := range roles {
+		if roles[i].Permissions, err = getRolePermissions(roles[i].ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch role permissions"})
+			return
+		}
+	}
+
+	if roles == nil {
+		roles = []models.Role{}
+	}
+
+	c.JSON(http.StatusOK, roles)
+}
+
+func GetRoleByID(c *gin.Context) {
+	roleID, ok := roleIDParam(c)
+	if !ok {
+		return
+	}
+
+	var role models.Role
+	var description sql.NullString
+	err := database.DB.QueryRow(
+		"SELECT id, name, description, is_active, created_at, updated_at FROM roles WHERE id = ?", roleID,
+	).Scan(&role.ID, &role.Name, &description, &role.IsActive, &role.CreatedAt, &role.UpdatedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch role"})
+		return
+	}
+	role.Description = description.String
+
+	if role.Permissions, err = getRolePermissions(role.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch role permissions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+func CreateRole(c *gin.Context) {
+	var req struct {
+		Name        string   `json:"name" binding:"required,max=50"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	roleID, err := services.CreateRole(req.Name, req.Description, req.Permissions)
+	if err != nil {
+		respondRoleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"id": roleID, "message": "Role created"})
+}
+
+func UpdateRole(c *gin.Context) {
+	roleID, ok := roleIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name        string `json:"name" binding:"required,max=50"`
+		Description string `json:"description"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.UpdateRole(roleID, req.Name, req.Description); err != nil {
+		respondRoleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated"})
+}
+
+func ActivateRole(c *gin.Context) {
+	setRoleActive(c, true)
+}
+
+func DeactivateRole(c *gin.Context) {
+	setRoleActive(c, false)
+}
+
+func setRoleActive(c *gin.Context, active bool) {
+	roleID, ok := roleIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := services.SetRoleActive(roleID, active); err != nil {
+		respondRoleError(c, err)
+		return
+	}
+
+	if active {
+		c.JSON(http.StatusOK, gin.H{"message": "Role activated"})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "Role deactivated"})
+	}
+}
+
+func DeleteRole(c *gin.Context) {
+	roleID, ok := roleIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := services.DeleteRole(roleID); err != nil {
+		respondRoleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted"})
+}
+
+func AddRolePermission(c *gin.Context) {
+	roleID, ok := roleIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Permission string `json:"permission" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.GrantPermission(roleID, req.Permission); err != nil {
+		respondRoleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Permission added"})
+}
+
+func RemoveRolePermission(c *gin.Context) {
+	roleID, ok := roleIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := services.RevokePermission(roleID, c.Param("permission")); err != nil {
+		respondRoleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Permission removed"})
+}
+
+// GetPermissions lists every permission that can be granted to a role.
+func GetPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, utils.PermissionRegistry)
+}
+
+func roleIDParam(c *gin.Context) (int, bool) {
+	roleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return 0, false
+	}
+	return roleID, true
+}
+
+func respondRoleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrRoleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+	case errors.Is(err, services.ErrUnknownPermission):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRoleNameTaken), errors.Is(err, services.ErrRoleInUse), errors.Is(err, services.ErrLastRoleManager):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+	}
+}
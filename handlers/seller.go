@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/middleware"
+	"github.com/emyu/ecommer-be/models"
+	"github.com/emyu/ecommer-be/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Orders in these statuses count as sales.
+var soldStatuses = []interface{}{
+	services.OrderStatusPaid,
+	services.OrderStatusPacked,
+	services.OrderStatusShipped,
+	services.OrderStatusDelivered,
+}
+
+// GetSellerOrders - Seller endpoint listing orders that contain the
+// seller's products, with only the seller's lines
+func GetSellerOrders(c *gin.Context) {
+	sellerID := sellerScope(c)
+	status := c.Query("status")
+
+	rows, err := database.DB.Query(`
+		SELECT DISTINCT o.id, o.order_number, o.status, o.shipping_address_id, o.created_at
+		FROM orders o
+		JOIN order_items oi ON oi.order_id = o.id
+		JOIN product_variants pv ON pv.id = oi.product_variant_id
+		JOIN products p ON p.id = pv.product_id
+		WHERE (? = '' OR p.seller_id = ?) AND (? = '' OR o.status = ?)
+		ORDER BY o.created_at DESC
+	`, sellerID, sellerID, status, status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
+		return
+	}
+	defer rows.Close()
+
+	var orders []models.SellerOrder
+	var addressIDs []string
+	for rows.Next() {
+		var order models.SellerOrder
+		var addressID string
+		if err := rows.Scan(&order.ID, &order.OrderNumber, &order.Status, &addressID, &order.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan order"})
+			return
+		}
+		orders = append(orders, order)
+		addressIDs = append(addressIDs, addressID)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reading orders"})
+		return
+	}
+	rows.Close()
+
+	for i := range orders {
+		orders[i].Items, err = getSellerOrderItems(orders[i].ID, sellerID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order items"})
+			return
+		}
+		for _, item := range orders[i].Items {
+			orders[i].SellerSubtotal += item.Price * float64(item.Quantity)
+		}
+		orders[i].ShippingAddress, _ = getShippingAddressDetails(addressIDs[i])
+	}
+
+	if orders == nil {
+		orders = []models.SellerOrder{}
+	}
+
+	c.JSON(http.StatusOK, orders)
+}
+
+// GetSellerSalesReport - Seller endpoint summarising units sold and revenue
+// per product over an optional from/to date range (YYYY-MM-DD, inclusive).
+// Revenue is the line total before order-level coupon discounts.
+func GetSellerSalesReport(c *gin.Context) {
+	report := models.SalesReport{
+		SellerID: sellerScope(c),
+		From:     c.Query("from"),
+		To:       c.Query("to"),
+		Products: []models.ProductSales{},
+	}
+	for _, date := range []string{report.From, report.To} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Dates must be in YYYY-MM-DD format"})
+			return
+		}
+	}
+
+	where := `
+		FROM order_items oi
+		JOIN orders o ON o.id = oi.order_id
+		JOIN product_variants pv ON pv.id = oi.product_variant_id
+		JOIN products p ON p.id = pv.product_id
+		WHERE o.status IN (?, ?, ?, ?)
+		  AND (? = '' OR p.seller_id = ?)
+		  AND (? = '' OR o.created_at >= ?)
+		  AND (? = '' OR o.created_at < DATE_ADD(?, INTERVAL 1 DAY))
+	`
+	args := append([]interface{}{}, soldStatuses...)
+	args = append(args,
+		report.SellerID, report.SellerID,
+		report.From, report.From,
+		report.To, report.To,
+	)
+
+	err := database.DB.QueryRow(`
+		SELECT COUNT(DISTINCT o.id), COALESCE(SUM(oi.quantity), 0), COALESCE(SUM(oi.price * oi.quantity), 0)
+	`+where, args...).Scan(&report.Orders, &report.UnitsSold, &report.Revenue)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build sales report"})
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT p.id, p.name, SUM(oi.quantity), SUM(oi.price * oi.quantity)
+	`+where+`
+		GROUP BY p.id, p.name
+		ORDER BY SUM(oi.price * oi.quantity) DESC
+	`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build sales report"})
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line models.ProductSales
+		if err := rows.Scan(&line.ProductID, &line.ProductName, &line.UnitsSold, &line.Revenue); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan sales report"})
+			return
+		}
+		report.Products = append(report.Products, line)
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// sellerScope is the seller whose data the caller may see: always the
+// caller, except that admins may pick any seller with ?seller_id= or leave
+// it empty to see every seller.
+func sellerScope(c *gin.Context) string {
+	if canManageAllProducts(c) {
+		return c.Query("seller_id")
+	}
+	return middleware.GetUserID(c)
+}
+
+// Helper function to get the lines of an order that belong to a seller
+func getSellerOrderItems(orderID, sellerID string) ([]models.OrderItem, error) {
+	rows, err := database.DB.Query(`
+		SELECT oi.id, oi.order_id, oi.product_variant_id, oi.quantity, oi.price, oi.custom_name, oi.custom_number, oi.created_at,
+		       pv.product_id, pv.name
+		FROM order_items oi
+		JOIN product_variants pv ON oi.product_variant_id = pv.id
+		JOIN products p ON pv.product_id = p.id
+		WHERE oi.order_id = ? AND (? = '' OR p.seller_id = ?)
+	`, orderID, sellerID, sellerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.OrderItem{}
+	for rows.Next() {
+		var item models.OrderItem
+		var customName, customNumber sql.NullString
+		variant := &models.ProductVariant{}
+		if err := rows.Scan(&item.ID, &item.OrderID, &item.ProductVariantID, &item.Quantity, &item.Price, &customName, &customNumber, &item.CreatedAt,
+			&variant.ProductID, &variant.Name); err != nil {
+			return nil, err
+		}
+		item.CustomName = customName.String
+		item.CustomNumber = customNumber.String
+		variant.ID = item.ProductVariantID
+		item.ProductVariant = variant
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
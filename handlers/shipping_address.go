@@ -7,6 +7,7 @@ import (
 	"github.com/emyu/ecommer-be/database"
 	"github.com/emyu/ecommer-be/middleware"
 	"github.com/emyu/ecommer-be/models"
+	"github.com/emyu/ecommer-be/services"
 	"github.com/emyu/ecommer-be/utils"
 	"github.com/gin-gonic/gin"
 )
@@ -101,22 +102,11 @@ func UpdateShippingAddress(c *gin.Context) {
 		return
 	}
 
-	userID := middleware.GetUserID(c)
-
-	// Check if address belongs to user
-	var ownerID string
-	err := database.DB.QueryRow("SELECT user_id FROM shipping_addresses WHERE id = ?", addressID).Scan(&ownerID)
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Shipping address not found"})
+	if !authorizeOwner(c, services.ResourceShippingAddress, addressID) {
 		return
 	}
 
-	if ownerID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized"})
-		return
-	}
-
-	_, err = database.DB.Exec(`
+	_, err := database.DB.Exec(`
 		UPDATE shipping_addresses SET address = ?, city = ?, province = ?, postal_code = ?, phone = ? WHERE id = ?
 	`, req.Address, req.City, req.Province, req.PostalCode, req.Phone, addressID)
 
@@ -130,22 +120,11 @@ func UpdateShippingAddress(c *gin.Context) {
 
 func DeleteShippingAddress(c *gin.Context) {
 	addressID := c.Param("id")
-	userID := middleware.GetUserID(c)
-
-	// Check if address belongs to user
-	var ownerID string
-	err := database.DB.QueryRow("SELECT user_id FROM shipping_addresses WHERE id = ?", addressID).Scan(&ownerID)
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Shipping address not found"})
-		return
-	}
-
-	if ownerID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized"})
+	if !authorizeOwner(c, services.ResourceShippingAddress, addressID) {
 		return
 	}
 
-	_, err = database.DB.Exec("DELETE FROM shipping_addresses WHERE id = ?", addressID)
+	_, err := database.DB.Exec("DELETE FROM shipping_addresses WHERE id = ?", addressID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete shipping address"})
 		return
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/emyu/ecommer-be/middleware"
+	"github.com/emyu/ecommer-be/models"
+	"github.com/emyu/ecommer-be/services"
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+// GetTwoFactorStatus shows whether the current user has 2FA enabled and
+// whether the policy requires it.
+func GetTwoFactorStatus(c *gin.Context) {
+	status, err := services.GetTwoFactorStatus(middleware.GetUserID(c), middleware.GetPermissions(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch two-factor status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// SetupTwoFactor creates a new TOTP secret for the current user. The user
+// adds it to an authenticator app, then confirms it with EnableTwoFactor.
+func SetupTwoFactor(c *gin.Context) {
+	secret, uri, err := services.BeginTwoFactorSetup(middleware.GetUserID(c))
+	if err != nil {
+		respondTwoFactorError(c, err, "Failed to start two-factor setup")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": uri,
+		"qr_code_url": "/api/me/2fa/qr",
+	})
+}
+
+// GetTwoFactorQRImage renders the pending provisioning URI as a PNG.
+func GetTwoFactorQRImage(c *gin.Context) {
+	uri, err := services.PendingTwoFactorURI(middleware.GetUserID(c))
+	if err != nil {
+		respondTwoFactorError(c, err, "Failed to fetch two-factor setup")
+		return
+	}
+
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render QR code"})
+		return
+	}
+	// The image contains the secret
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+// EnableTwoFactor confirms the pending secret with a code from the app and
+// returns the recovery codes. They are shown only this once.
+func EnableTwoFactor(c *gin.Context) {
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := services.EnableTwoFactor(middleware.GetUserID(c), req.Code, middleware.GetSessionID(c))
+	if err != nil {
+		respondTwoFactorError(c, err, "Failed to enable two-factor authentication")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor turns 2FA off with a current code or a recovery code.
+func DisableTwoFactor(c *gin.Context) {
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := services.DisableTwoFactor(middleware.GetUserID(c), req.Code, middleware.GetPermissions(c),
+		c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		respondTwoFactorError(c, err, "Failed to disable two-factor authentication")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes.
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := services.RegenerateRecoveryCodes(middleware.GetUserID(c), req.Code, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		respondTwoFactorError(c, err, "Failed to regenerate recovery codes")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func respondTwoFactorError(c *gin.Context, err error, fallback string) {
+	var throttled *services.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		respondThrottled(c, throttled)
+	case errors.Is(err, services.ErrInvalidTwoFactorCode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTwoFactorAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTwoFactorNotEnabled), errors.Is(err, services.ErrTwoFactorNotSetUp):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTwoFactorRequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -2,16 +2,19 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
 
 	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/middleware"
 	"github.com/emyu/ecommer-be/models"
+	"github.com/emyu/ecommer-be/services"
 	"github.com/gin-gonic/gin"
 )
 
 func GetAllUsers(c *gin.Context) {
 	rows, err := database.DB.Query(`
-		SELECT id, name, email, phone, role_id, created_at, updated_at
+		SELECT id, name, email, phone, role_id, is_active, deactivated_at, COALESCE(deactivation_reason, ''), email_verified_at, failed_login_count, locked_until, created_at, updated_at
 		FROM users ORDER BY created_at DESC
 	`)
 
@@ -24,11 +27,12 @@ func GetAllUsers(c *gin.Context) {
 	var users []models.User
 	for rows.Next() {
 		var user models.User
-		err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.RoleID, &user.CreatedAt, &user.UpdatedAt)
+		err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.RoleID, &user.IsActive, &user.DeactivatedAt, &user.DeactivationReason, &user.EmailVerifiedAt, &user.FailedLoginCount, &user.LockedUntil, &user.CreatedAt, &user.UpdatedAt)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan user"})
 			return
 		}
+		user.EmailVerified = user.EmailVerifiedAt != nil
 		users = append(users, user)
 	}
 
@@ -49,9 +53,9 @@ func GetUserByID(c *gin.Context) {
 	var user models.User
 
 	err := database.DB.QueryRow(`
-		SELECT id, name, email, phone, role_id, created_at, updated_at
+		SELECT id, name, email, phone, role_id, is_active, deactivated_at, COALESCE(deactivation_reason, ''), email_verified_at, failed_login_count, locked_until, created_at, updated_at
 		FROM users WHERE id = ?
-	`, userID).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.RoleID, &user.CreatedAt, &user.UpdatedAt)
+	`, userID).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.RoleID, &user.IsActive, &user.DeactivatedAt, &user.DeactivationReason, &user.EmailVerifiedAt, &user.FailedLoginCount, &user.LockedUntil, &user.CreatedAt, &user.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
@@ -61,6 +65,7 @@ func GetUserByID(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
 		return
 	}
+	user.EmailVerified = user.EmailVerifiedAt != nil
 
 	c.JSON(http.StatusOK, user)
 }
@@ -79,24 +84,105 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
-	_, err := database.DB.Exec(`
-		UPDATE users SET name = ?, email = ?, phone = ?, role_id = ? WHERE id = ?
-	`, req.Name, req.Email, req.Phone, req.RoleID, userID)
-
-	if err != nil {
+	emailChanged, err := services.UpdateUser(userID, services.UserUpdate{
+		Name:   req.Name,
+		Email:  req.Email,
+		Phone:  req.Phone,
+		RoleID: req.RoleID,
+	}, middleware.GetPermissions(c))
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	case errors.Is(err, services.ErrRoleChangeForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrRoleNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role not found"})
+		return
+	case errors.Is(err, services.ErrLastUserManager):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
 		return
 	}
 
+	// A changed email means a different login; outstanding sessions must go.
+	if emailChanged {
+		if err := services.RevokeUserSessions(userID, "email changed by admin"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "User updated but sessions could not be revoked"})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "User updated"})
 }
 
-func DeleteUser(c *gin.Context) {
-	userID := c.Param("id")
+// DeactivateUser blocks a user from logging in and revokes their sessions.
+func DeactivateUser(c *gin.Context) {
+	var req models.DeactivateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := services.DeactivateUser(c.Param("id"), req.Reason, middleware.GetUserID(c))
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	case errors.Is(err, services.ErrCannotDeactivateSelf):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate user"})
+		return
+	}
 
-	_, err := database.DB.Exec("DELETE FROM users WHERE id = ?", userID)
+	c.JSON(http.StatusOK, gin.H{"message": "User deactivated"})
+}
+
+// ActivateUser lets a deactivated user log in again.
+func ActivateUser(c *gin.Context) {
+	err := services.ActivateUser(c.Param("id"))
+	if errors.Is(err, services.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if errors.Is(err, services.ErrUserErased) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to activate user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User activated"})
+}
+
+// UnlockUser lifts a login lockout and resets the failed login count.
+func UnlockUser(c *gin.Context) {
+	err := services.UnlockAccount(c.Param("id"))
+	if errors.Is(err, services.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked"})
+}
+
+// DeleteUser erases a user's personal data. Orders and payments are kept
+// for accounting and point to an anonymous placeholder account instead.
+func DeleteUser(c *gin.Context) {
+	err := services.EraseUser(c.Param("id"), middleware.GetUserID(c), "deleted by admin")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		respondErasureError(c, err, "Failed to delete user")
 		return
 	}
 
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/emyu/ecommer-be/payment"
+	"github.com/emyu/ecommer-be/services"
+	"github.com/gin-gonic/gin"
+)
+
+// PaymentWebhook receives asynchronous payment notifications. It is not
+// behind AuthMiddleware; requests are authenticated by an HMAC-SHA256
+// signature of the raw body in the X-Signature header.
+func PaymentWebhook(c *gin.Context) {
+	providerName := c.Param("provider")
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
+
+	duplicate, err := services.HandlePaymentNotification(providerName, body, c.GetHeader("X-Signature"))
+	switch {
+	case errors.Is(err, services.ErrInvalidSignature):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		return
+	case errors.Is(err, payment.ErrUnknownProvider), errors.Is(err, services.ErrWebhookNotSupported):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown payment provider"})
+		return
+	case errors.Is(err, payment.ErrInvalidNotification):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrPaymentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	case errors.Is(err, services.ErrNotificationMismatch):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process notification"})
+		return
+	}
+
+	if duplicate {
+		c.JSON(http.StatusOK, gin.H{"message": "Notification already processed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification processed"})
+}
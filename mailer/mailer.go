@@ -0,0 +1,111 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emyu/ecommer-be/config"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+var ErrInvalidHeader = errors.New("mail header contains a line break")
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers a message or returns why it could not.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+var current Mailer
+
+// Init picks the mailer from config: SMTP when SMTP_HOST is set, otherwise
+// a mailer that only logs, which is refused in production.
+func Init() error {
+	cfg := config.AppConfig
+	switch {
+	case cfg.SMTPHost != "":
+		current = NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	case cfg.Env != "production":
+		current = LogMailer{}
+	default:
+		return errors.New("SMTP_HOST is required in production")
+	}
+	return nil
+}
+
+// Send delivers msg with the configured mailer.
+func Send(msg Message) error {
+	if current == nil {
+		return errors.New("mailer not initialized")
+	}
+	return current.Send(msg)
+}
+
+// SMTPMailer sends mail through an SMTP server, e.g. MailHog on port 1025
+// in development. Authentication is only used when a username is set.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(host string, port int, username, password, from string) *SMTPMailer {
+	m := &SMTPMailer{addr: host + ":" + strconv.Itoa(port), from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	data, err := m.compose(msg)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, data)
+}
+
+func (m *SMTPMailer) compose(msg Message) ([]byte, error) {
+	for _, h := range []string{m.from, msg.To, msg.Subject} {
+		if strings.ContainsAny(h, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+
+	domain := "localhost"
+	if _, d, ok := strings.Cut(m.from, "@"); ok {
+		domain = d
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", utils.GenerateSecureToken(16), domain)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String()), nil
+}
+
+// LogMailer writes messages to the log instead of sending them. It is only
+// used in development when no SMTP server is configured.
+type LogMailer struct{}
+
+func (LogMailer) Send(msg Message) error {
+	log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -1,9 +1,12 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/emyu/ecommer-be/services"
 	"github.com/emyu/ecommer-be/utils"
 	"github.com/gin-gonic/gin"
 )
@@ -32,11 +35,63 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		c.Set("userID", claims.ID)
-		c.Set("userEmail", claims.Email)
-		c.Set("roleID", claims.RoleID)
-		c.Set("roleName", claims.RoleName)
-		c.Set("permissions", claims.Permissions)
+		revoked, err := services.IsAccessRevoked(claims.RegisteredClaims.ID, claims.SessionID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify token"})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			c.Abort()
+			return
+		}
+
+		principal, err := services.ResolvePrincipal(claims.ID)
+		if errors.Is(err, services.ErrUserNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User no longer exists"})
+			c.Abort()
+			return
+		}
+		if errors.Is(err, services.ErrUserInactive) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is deactivated"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user permissions"})
+			c.Abort()
+			return
+		}
+
+		c.Set("userID", principal.UserID)
+		c.Set("userEmail", principal.Email)
+		c.Set("roleID", principal.RoleID)
+		c.Set("roleName", principal.RoleName)
+		c.Set("permissions", principal.Permissions)
+		c.Set("twoFactorEnabled", principal.TwoFactorEnabled)
+		c.Set("sessionID", claims.SessionID)
+		c.Set("tokenID", claims.RegisteredClaims.ID)
+		c.Set("tokenExpiresAt", claims.ExpiresAt.Time)
+		c.Next()
+	}
+}
+
+// TwoFactorPolicyMiddleware blocks users who must use two-factor
+// authentication (see TWO_FACTOR_ENFORCED) until they have enabled it.
+// Enabling 2FA revokes the user's other sessions, so every remaining
+// session of an enrolled user passed the second factor.
+func TwoFactorPolicyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if services.TwoFactorRequired(GetPermissions(c)) && !c.GetBool("twoFactorEnabled") {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":     "Two-factor authentication must be enabled for this account",
+				"setup_url": "/api/me/2fa/setup",
+			})
+			c.Abort()
+			return
+		}
+
 		c.Next()
 	}
 }
@@ -99,19 +154,6 @@ func PermissionMiddleware(requiredPermissions ...string) gin.HandlerFunc {
 	}
 }
 
-// AdminMiddleware checks if user is admin
-func AdminMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		roleName, exists := c.Get("roleName")
-		if !exists || roleName != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
-			c.Abort()
-			return
-		}
-		c.Next()
-	}
-}
-
 func GetUserID(c *gin.Context) string {
 	userID, exists := c.Get("userID")
 	if !exists {
@@ -143,3 +185,15 @@ func GetPermissions(c *gin.Context) []string {
 	}
 	return permissions.([]string)
 }
+
+func GetSessionID(c *gin.Context) string {
+	return c.GetString("sessionID")
+}
+
+func GetTokenID(c *gin.Context) string {
+	return c.GetString("tokenID")
+}
+
+func GetTokenExpiry(c *gin.Context) time.Time {
+	return c.GetTime("tokenExpiresAt")
+}
@@ -23,16 +23,60 @@ type RolePermission struct {
 
 // User
 type User struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Phone     string    `json:"phone"`
-	RoleID    int       `json:"role_id"`
-	Role      *Role     `json:"role,omitempty"`
-	Password  string    `json:"-"`
-	IsActive  bool      `json:"is_active"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Phone    string `json:"phone"`
+	RoleID   int    `json:"role_id"`
+	Role     *Role  `json:"role,omitempty"`
+	Password string `json:"-"`
+	IsActive bool   `json:"is_active"`
+	// EmailVerified mirrors EmailVerifiedAt != nil for clients.
+	EmailVerified   bool       `json:"email_verified"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// PendingEmail is an email change waiting for verification (own profile only)
+	PendingEmail string `json:"pending_email,omitempty"`
+	// Set only on admin user endpoints
+	DeactivatedAt      *time.Time `json:"deactivated_at,omitempty"`
+	DeactivationReason string     `json:"deactivation_reason,omitempty"`
+	FailedLoginCount   int        `json:"failed_login_count,omitempty"`
+	LockedUntil        *time.Time `json:"locked_until,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// LoginHistoryEntry is one login attempt on an account
+type LoginHistoryEntry struct {
+	ID            string    `json:"id"`
+	IPAddress     string    `json:"ip_address"`
+	UserAgent     string    `json:"user_agent"`
+	Success       bool      `json:"success"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// ErasureRequest is a request to erase an account's personal data
+type ErasureRequest struct {
+	ID              string     `json:"id"`
+	UserID          string     `json:"user_id"`
+	Status          string     `json:"status"`
+	Reason          string     `json:"reason,omitempty"`
+	RequestedBy     string     `json:"requested_by"`
+	ProcessedBy     string     `json:"processed_by,omitempty"`
+	RejectionReason string     `json:"rejection_reason,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	ProcessedAt     *time.Time `json:"processed_at"`
+}
+
+// DataExport is the personal data held about a user
+type DataExport struct {
+	ExportedAt        time.Time           `json:"exported_at"`
+	Profile           User                `json:"profile"`
+	ShippingAddresses []ShippingAddress   `json:"shipping_addresses"`
+	Orders            []Order             `json:"orders"`
+	Reviews           []Review            `json:"reviews"`
+	LoginHistory      []LoginHistoryEntry `json:"login_history"`
+	ErasureRequests   []ErasureRequest    `json:"erasure_requests"`
 }
 
 // Category
@@ -51,6 +95,7 @@ type Product struct {
 	Description    string           `json:"description"`
 	Price          float64          `json:"price"`
 	CategoryID     string           `json:"category_id"`
+	SellerID       string           `json:"seller_id,omitempty"`
 	IsCustomizable bool             `json:"is_customizable"`
 	CreatedAt      time.Time        `json:"created_at"`
 	UpdatedAt      time.Time        `json:"updated_at"`
@@ -73,16 +118,34 @@ type ProductVariant struct {
 	ProductID       string    `json:"product_id"`
 	Name            string    `json:"name"`
 	PriceAdjustment float64   `json:"price_adjustment"`
+	Stock           int       `json:"stock"`
+	Reserved        int       `json:"reserved"`
 	CreatedAt       time.Time `json:"created_at"`
 }
 
+// StockMovement
+type StockMovement struct {
+	ID               string    `json:"id"`
+	ProductVariantID string    `json:"product_variant_id"`
+	MovementType     string    `json:"movement_type"` // adjustment, reserve, commit, release, return
+	StockDelta       int       `json:"stock_delta"`
+	ReservedDelta    int       `json:"reserved_delta"`
+	StockAfter       int       `json:"stock_after"`
+	ReservedAfter    int       `json:"reserved_after"`
+	OrderID          string    `json:"order_id,omitempty"`
+	ActorID          string    `json:"actor_id,omitempty"`
+	Reason           string    `json:"reason"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
 // Cart
 type Cart struct {
-	ID        string     `json:"id"`
-	UserID    string     `json:"user_id"`
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	Items     []CartItem `json:"items,omitempty"`
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	CouponCode string     `json:"coupon_code,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Items      []CartItem `json:"items,omitempty"`
 }
 
 // CartItem
@@ -113,20 +176,37 @@ type ShippingAddress struct {
 
 // Order
 type Order struct {
-	ID                string           `json:"id"`
-	UserID            string           `json:"user_id"`
-	OrderNumber       string           `json:"order_number"`
-	TotalAmount       float64          `json:"total_amount"`
-	ShippingCost      float64          `json:"shipping_cost"`
-	Status            string           `json:"status"`         // pending, paid, packed, shipped, delivered, canceled
-	PaymentMethod     string           `json:"payment_method"` // qris, bank_transfer, ewallet
-	ShippingAddressID string           `json:"shipping_address_id"`
-	CreatedAt         time.Time        `json:"created_at"`
-	UpdatedAt         time.Time        `json:"updated_at"`
-	User              *User            `json:"user,omitempty"`
-	ShippingAddress   *ShippingAddress `json:"shipping_address,omitempty"`
-	Items             []OrderItem      `json:"items,omitempty"`
-	Payment           *Payment         `json:"payment,omitempty"`
+	ID                string               `json:"id"`
+	UserID            string               `json:"user_id"`
+	OrderNumber       string               `json:"order_number"`
+	Subtotal          float64              `json:"subtotal"`
+	DiscountAmount    float64              `json:"discount_amount"`
+	TotalAmount       float64              `json:"total_amount"`
+	ShippingCost      float64              `json:"shipping_cost"`
+	PaidAmount        float64              `json:"paid_amount"`
+	RefundedAmount    float64              `json:"refunded_amount"`
+	Status            string               `json:"status"`         // pending, paid, packed, shipped, delivered, canceled, refunded
+	PaymentMethod     string               `json:"payment_method"` // qris, bank_transfer, ewallet
+	ShippingAddressID string               `json:"shipping_address_id"`
+	CreatedAt         time.Time            `json:"created_at"`
+	UpdatedAt         time.Time            `json:"updated_at"`
+	User              *User                `json:"user,omitempty"`
+	ShippingAddress   *ShippingAddress     `json:"shipping_address,omitempty"`
+	Items             []OrderItem          `json:"items,omitempty"`
+	Payment           *Payment             `json:"payment,omitempty"`
+	StatusHistory     []OrderStatusHistory `json:"status_history,omitempty"`
+	Discounts         []OrderDiscount      `json:"discounts,omitempty"`
+}
+
+// OrderDiscount is a discount line of an order
+type OrderDiscount struct {
+	ID          string    `json:"id"`
+	OrderID     string    `json:"order_id"`
+	CouponID    string    `json:"coupon_id,omitempty"`
+	Code        string    `json:"code"`
+	Description string    `json:"description"`
+	Amount      float64   `json:"amount"`
+	CreatedAt   time.Time `json:"created_at"`
 }
 
 // OrderItem
@@ -136,17 +216,173 @@ type OrderItem struct {
 	ProductVariantID string          `json:"product_variant_id"`
 	Quantity         int             `json:"quantity"`
 	Price            float64         `json:"price"`
+	CustomName       string          `json:"custom_name"`
+	CustomNumber     string          `json:"custom_number"`
 	CreatedAt        time.Time       `json:"created_at"`
 	ProductVariant   *ProductVariant `json:"product_variant,omitempty"`
 }
 
+// SellerOrder is an order as one seller sees it: only the lines of their
+// products, and the subtotal of those lines.
+type SellerOrder struct {
+	ID              string           `json:"id"`
+	OrderNumber     string           `json:"order_number"`
+	Status          string           `json:"status"`
+	SellerSubtotal  float64          `json:"seller_subtotal"`
+	CreatedAt       time.Time        `json:"created_at"`
+	ShippingAddress *ShippingAddress `json:"shipping_address,omitempty"`
+	Items           []OrderItem      `json:"items"`
+}
+
+// SalesReport
+type SalesReport struct {
+	SellerID  string         `json:"seller_id,omitempty"`
+	From      string         `json:"from,omitempty"`
+	To        string         `json:"to,omitempty"`
+	Orders    int            `json:"orders"`
+	UnitsSold int            `json:"units_sold"`
+	Revenue   float64        `json:"revenue"`
+	Products  []ProductSales `json:"products"`
+}
+
+// ProductSales is one product's line in a SalesReport
+type ProductSales struct {
+	ProductID   string  `json:"product_id"`
+	ProductName string  `json:"product_name"`
+	UnitsSold   int     `json:"units_sold"`
+	Revenue     float64 `json:"revenue"`
+}
+
+// OrderStatusHistory
+type OrderStatusHistory struct {
+	ID         string    `json:"id"`
+	OrderID    string    `json:"order_id"`
+	FromStatus string    `json:"from_status,omitempty"`
+	ToStatus   string    `json:"to_status"`
+	ActorType  string    `json:"actor_type"` // admin, payment, customer, system
+	ActorID    string    `json:"actor_id,omitempty"`
+	Note       string    `json:"note,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
 // Payment
 type Payment struct {
 	ID            string     `json:"id"`
 	OrderID       string     `json:"order_id"`
-	PaymentStatus string     `json:"payment_status"` // pending, success, failed
+	PaymentStatus string     `json:"payment_status"` // pending, success, failed, expired
 	PaymentCode   string     `json:"payment_code"`
+	Amount        float64    `json:"amount"`
+	Channel       string     `json:"channel"` // qris, bank_transfer, ewallet, credit_card
+	Bank          string     `json:"bank,omitempty"`
+	VANumber      string     `json:"va_number,omitempty"`
+	Provider      string     `json:"provider"`
+	ProviderRef   string     `json:"provider_ref,omitempty"`
+	CheckoutURL   string     `json:"checkout_url,omitempty"`
+	QRPayload     string     `json:"qr_payload,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at"`
 	PaidAt        *time.Time `json:"paid_at"`
+	PaidLate      bool       `json:"paid_late"` // paid after it expired or failed; needs a refund
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	Refunds       []Refund   `json:"refunds"`
+}
+
+// Refund
+type Refund struct {
+	ID            string    `json:"id"`
+	PaymentID     string    `json:"payment_id"`
+	OrderID       string    `json:"order_id"`
+	Amount        float64   `json:"amount"`
+	Reason        string    `json:"reason"`
+	Status        string    `json:"status"` // requested, processing, succeeded, failed
+	ProviderRef   string    `json:"provider_ref,omitempty"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	RequestedBy   string    `json:"requested_by"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// SellerBalance is what the platform currently owes a seller
+type SellerBalance struct {
+	SellerID         string    `json:"seller_id"`
+	SellerName       string    `json:"seller_name,omitempty"`
+	SellerEmail      string    `json:"seller_email,omitempty"`
+	Balance          float64   `json:"balance"`
+	HasPayoutAccount bool      `json:"has_payout_account"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// LedgerEntry is one change to a seller's balance
+type LedgerEntry struct {
+	ID               string    `json:"id"`
+	SellerID         string    `json:"seller_id"`
+	EntryType        string    `json:"entry_type"` // earning, refund, payout, payout_reversal
+	GrossAmount      *float64  `json:"gross_amount,omitempty"`
+	CommissionAmount *float64  `json:"commission_amount,omitempty"`
+	Amount           float64   `json:"amount"`
+	BalanceAfter     float64   `json:"balance_after"`
+	OrderID          string    `json:"order_id,omitempty"`
+	OrderItemID      string    `json:"order_item_id,omitempty"`
+	RefundID         string    `json:"refund_id,omitempty"`
+	PayoutBatchID    string    `json:"payout_batch_id,omitempty"`
+	Description      string    `json:"description"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// PayoutAccount is the bank account a seller is paid to
+type PayoutAccount struct {
+	SellerID      string    `json:"seller_id"`
+	BankName      string    `json:"bank_name"`
+	AccountNumber string    `json:"account_number"`
+	AccountName   string    `json:"account_name"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// PayoutBatch
+type PayoutBatch struct {
+	ID            string       `json:"id"`
+	Status        string       `json:"status"` // pending, paid, canceled
+	TotalAmount   float64      `json:"total_amount"`
+	ItemCount     int          `json:"item_count"`
+	CreatedBy     string       `json:"created_by"`
+	PaidReference string       `json:"paid_reference,omitempty"`
+	PaidBy        string       `json:"paid_by,omitempty"`
+	PaidAt        *time.Time   `json:"paid_at,omitempty"`
+	CanceledAt    *time.Time   `json:"canceled_at,omitempty"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+	Items         []PayoutItem `json:"items,omitempty"`
+}
+
+// PayoutItem is one seller's transfer in a payout batch
+type PayoutItem struct {
+	ID            string    `json:"id"`
+	BatchID       string    `json:"batch_id"`
+	SellerID      string    `json:"seller_id"`
+	Amount        float64   `json:"amount"`
+	BankName      string    `json:"bank_name"`
+	AccountNumber string    `json:"account_number"`
+	AccountName   string    `json:"account_name"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Coupon
+type Coupon struct {
+	ID            string     `json:"id"`
+	Code          string     `json:"code"`
+	Description   string     `json:"description"`
+	DiscountType  string     `json:"discount_type"` // percentage, fixed
+	DiscountValue float64    `json:"discount_value"`
+	MaxDiscount   *float64   `json:"max_discount"`
+	MinSpend      float64    `json:"min_spend"`
+	UsageLimit    *int       `json:"usage_limit"`
+	PerUserLimit  *int       `json:"per_user_limit"`
+	UsedCount     int        `json:"used_count"`
+	StartsAt      *time.Time `json:"starts_at"`
+	EndsAt        *time.Time `json:"ends_at"`
+	IsActive      bool       `json:"is_active"`
+	CategoryIDs   []string   `json:"category_ids"`
+	ProductIDs    []string   `json:"product_ids"`
 	CreatedAt     time.Time  `json:"created_at"`
 	UpdatedAt     time.Time  `json:"updated_at"`
 }
@@ -166,7 +402,7 @@ type Review struct {
 type RegisterRequest struct {
 	Name     string `json:"name" binding:"required"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"`
 	Phone    string `json:"phone"`
 }
 
@@ -175,6 +411,57 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	// Code is a TOTP code or a recovery code.
+	Code string `json:"code" binding:"required"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type UpdateProfileRequest struct {
+	Name  string `json:"name" binding:"required,max=100"`
+	Phone string `json:"phone" binding:"max=20"`
+}
+
+type ChangeEmailRequest struct {
+	NewEmail string `json:"new_email" binding:"required,email,max=100"`
+	Password string `json:"password" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type DeactivateUserRequest struct {
+	Reason string `json:"reason" binding:"required,max=255"`
+}
+
+type EraseAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+	Reason   string `json:"reason" binding:"max=1000"`
+}
+
+type RejectErasureRequest struct {
+	Reason string `json:"reason" binding:"required,max=1000"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
 type AuthResponse struct {
 	User  *User  `json:"user"`
 	Token string `json:"token"`
@@ -0,0 +1,171 @@
+package payment
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FakeProvider is an in-process provider for development and tests. Charges
+// stay pending until Settle, Fail or Expire is called, or until their
+// expiry time passes.
+type FakeProvider struct {
+	mu       sync.Mutex
+	charges  map[string]*Charge
+	refunded map[string]float64
+}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{
+		charges:  map[string]*Charge{},
+		refunded: map[string]float64{},
+	}
+}
+
+func (f *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (f *FakeProvider) CreateCharge(ctx context.Context, req ChargeRequest) (*Charge, error) {
+	ref := "fake-" + randomHex(8)
+	charge := &Charge{
+		ProviderRef: ref,
+		Status:      StatusPending,
+		Channel:     req.Channel,
+		Amount:      req.Amount,
+		ExpiresAt:   req.ExpiresAt,
+	}
+
+	switch req.Channel {
+	case ChannelBankTransfer:
+		charge.PaymentCode = req.VANumber
+		if charge.PaymentCode == "" {
+			charge.PaymentCode = "8808" + fmt.Sprintf("%012d", time.Now().UnixNano()%1e12)
+		}
+	case ChannelEWallet, ChannelCreditCard:
+		charge.CheckoutURL = "https://fake-pay.local/checkout/" + ref
+	}
+
+	f.mu.Lock()
+	f.charges[ref] = charge
+	f.mu.Unlock()
+
+	out := *charge
+	return &out, nil
+}
+
+func (f *FakeProvider) GetStatus(ctx context.Context, providerRef string) (*Charge, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	charge, ok := f.charges[providerRef]
+	if !ok {
+		return nil, ErrChargeNotFound
+	}
+	if charge.Status == StatusPending && !charge.ExpiresAt.IsZero() && time.Now().After(charge.ExpiresAt) {
+		charge.Status = StatusExpired
+	}
+
+	out := *charge
+	return &out, nil
+}
+
+func (f *FakeProvider) Cancel(ctx context.Context, providerRef string) error {
+	return f.setStatus(providerRef, StatusCanceled)
+}
+
+func (f *FakeProvider) Refund(ctx context.Context, providerRef string, req RefundRequest) (*Refund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	charge, ok := f.charges[providerRef]
+	if !ok {
+		return nil, ErrChargeNotFound
+	}
+	if charge.Status != StatusSuccess {
+		return nil, fmt.Errorf("%w: charge is %s", ErrRefundRejected, charge.Status)
+	}
+	if req.Amount <= 0 || f.refunded[providerRef]+req.Amount > charge.Amount {
+		return nil, fmt.Errorf("%w: amount exceeds refundable balance", ErrRefundRejected)
+	}
+
+	f.refunded[providerRef] += req.Amount
+	return &Refund{ProviderRef: "fake-rf-" + randomHex(8), Status: RefundSucceeded, Amount: req.Amount}, nil
+}
+
+// Settle marks a pending charge as paid.
+func (f *FakeProvider) Settle(providerRef string) error {
+	return f.setStatus(providerRef, StatusSuccess)
+}
+
+// Fail marks a pending charge as failed.
+func (f *FakeProvider) Fail(providerRef string) error {
+	return f.setStatus(providerRef, StatusFailed)
+}
+
+// Expire marks a pending charge as expired.
+func (f *FakeProvider) Expire(providerRef string) error {
+	return f.setStatus(providerRef, StatusExpired)
+}
+
+func (f *FakeProvider) setStatus(providerRef string, status Status) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	charge, ok := f.charges[providerRef]
+	if !ok {
+		return ErrChargeNotFound
+	}
+	if charge.Status != StatusPending {
+		return fmt.Errorf("%w: charge is %s", ErrChargeNotPending, charge.Status)
+	}
+
+	charge.Status = status
+	return nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type fakeNotification struct {
+	NotificationID string  `json:"notification_id"`
+	ProviderRef    string  `json:"provider_ref"`
+	Status         Status  `json:"status"`
+	Amount         float64 `json:"amount"`
+}
+
+func (f *FakeProvider) ParseNotification(body []byte) (*Notification, error) {
+	var n fakeNotification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+	if n.NotificationID == "" || n.ProviderRef == "" || n.Status == "" {
+		return nil, fmt.Errorf("%w: notification_id, provider_ref and status are required", ErrInvalidNotification)
+	}
+	return &Notification{ID: n.NotificationID, ProviderRef: n.ProviderRef, Status: n.Status, Amount: n.Amount}, nil
+}
+
+// NotificationFor builds the webhook body the fake provider would send for
+// the current state of a charge.
+func (f *FakeProvider) NotificationFor(providerRef string) ([]byte, error) {
+	f.mu.Lock()
+	charge, ok := f.charges[providerRef]
+	f.mu.Unlock()
+	if !ok {
+		return nil, ErrChargeNotFound
+	}
+
+	return json.Marshal(fakeNotification{
+		NotificationID: "fake-ntf-" + randomHex(8),
+		ProviderRef:    charge.ProviderRef,
+		Status:         charge.Status,
+		Amount:         charge.Amount,
+	})
+}
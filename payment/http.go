@@ -0,0 +1,289 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HTTPProvider talks to a Midtrans-style core API: charges are created with
+// POST /v2/charge and addressed afterwards by the order_id we sent, which is
+// our payment ID. Xendit and similar gateways can be fronted the same way.
+type HTTPProvider struct {
+	name      string
+	baseURL   string
+	serverKey string
+	location  *time.Location // zone of the gateway's timestamps, which carry none
+	client    *http.Client
+}
+
+func NewHTTPProvider(name, baseURL, serverKey string, location *time.Location) *HTTPProvider {
+	return &HTTPProvider{
+		name:      name,
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		serverKey: serverKey,
+		location:  location,
+		client:    &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (h *HTTPProvider) Name() string {
+	return h.name
+}
+
+type gatewayTransaction struct {
+	StatusCode        string `json:"status_code"`
+	StatusMessage     string `json:"status_message"`
+	TransactionID     string `json:"transaction_id"`
+	OrderID           string `json:"order_id"`
+	GrossAmount       string `json:"gross_amount"`
+	PaymentType       string `json:"payment_type"`
+	TransactionStatus string `json:"transaction_status"`
+	FraudStatus       string `json:"fraud_status"`
+	VANumbers         []struct {
+		Bank     string `json:"bank"`
+		VANumber string `json:"va_number"`
+	} `json:"va_numbers"`
+	PaymentCode string `json:"payment_code"`
+	QRString    string `json:"qr_string"`
+	Actions     []struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	} `json:"actions"`
+	RedirectURL string `json:"redirect_url"`
+	ExpiryTime  string `json:"expiry_time"`
+	RefundKey   string `json:"refund_key"`
+}
+
+func (h *HTTPProvider) CreateCharge(ctx context.Context, req ChargeRequest) (*Charge, error) {
+	body := map[string]interface{}{
+		"transaction_details": map[string]interface{}{
+			"order_id":     req.Reference,
+			"gross_amount": int64(math.Round(req.Amount)),
+		},
+		"customer_details": map[string]interface{}{
+			"first_name": req.CustomerName,
+			"email":      req.CustomerEmail,
+		},
+	}
+	if !req.ExpiresAt.IsZero() {
+		minutes := int(time.Until(req.ExpiresAt).Minutes())
+		if minutes < 1 {
+			minutes = 1
+		}
+		body["custom_expiry"] = map[string]interface{}{"expiry_duration": minutes, "unit": "minute"}
+	}
+
+	switch req.Channel {
+	case ChannelQRIS:
+		body["payment_type"] = "qris"
+	case ChannelBankTransfer:
+		bank := req.Bank
+		if bank == "" {
+			bank = "bca"
+		}
+		bankTransfer := map[string]interface{}{"bank": bank}
+		if req.VANumber != "" {
+			bankTransfer["va_number"] = req.VANumber
+		}
+		body["payment_type"] = "bank_transfer"
+		body["bank_transfer"] = bankTransfer
+	case ChannelEWallet:
+		body["payment_type"] = "gopay"
+	case ChannelCreditCard:
+		body["payment_type"] = "credit_card"
+		body["credit_card"] = map[string]interface{}{"secure": true}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownChannel, req.Channel)
+	}
+
+	var tx gatewayTransaction
+	if err := h.do(ctx, http.MethodPost, "/v2/charge", body, &tx); err != nil {
+		return nil, err
+	}
+	if err := tx.err(h.name); err != nil {
+		return nil, err
+	}
+
+	charge := h.toCharge(&tx)
+	charge.Channel = req.Channel
+	if charge.ProviderRef == "" {
+		charge.ProviderRef = req.Reference
+	}
+	if charge.Amount == 0 {
+		charge.Amount = req.Amount
+	}
+	if charge.ExpiresAt.IsZero() {
+		charge.ExpiresAt = req.ExpiresAt
+	}
+	return charge, nil
+}
+
+func (h *HTTPProvider) GetStatus(ctx context.Context, providerRef string) (*Charge, error) {
+	var tx gatewayTransaction
+	if err := h.do(ctx, http.MethodGet, "/v2/"+url.PathEscape(providerRef)+"/status", nil, &tx); err != nil {
+		return nil, err
+	}
+	if tx.StatusCode == "404" {
+		return nil, ErrChargeNotFound
+	}
+	if err := tx.err(h.name); err != nil {
+		return nil, err
+	}
+	return h.toCharge(&tx), nil
+}
+
+func (h *HTTPProvider) Cancel(ctx context.Context, providerRef string) error {
+	var tx gatewayTransaction
+	if err := h.do(ctx, http.MethodPost, "/v2/"+url.PathEscape(providerRef)+"/cancel", nil, &tx); err != nil {
+		return err
+	}
+	return tx.err(h.name)
+}
+
+func (h *HTTPProvider) Refund(ctx context.Context, providerRef string, req RefundRequest) (*Refund, error) {
+	body := map[string]interface{}{
+		"refund_key": req.Reference,
+		"amount":     int64(math.Round(req.Amount)),
+		"reason":     req.Reason,
+	}
+
+	var tx gatewayTransaction
+	if err := h.do(ctx, http.MethodPost, "/v2/"+url.PathEscape(providerRef)+"/refund", body, &tx); err != nil {
+		return nil, err
+	}
+
+	refund := &Refund{ProviderRef: tx.RefundKey, Amount: req.Amount, Status: RefundProcessing}
+	if refund.ProviderRef == "" {
+		refund.ProviderRef = req.Reference
+	}
+	switch tx.StatusCode {
+	case "200":
+		refund.Status = RefundSucceeded
+	case "201":
+		refund.Status = RefundProcessing
+	default:
+		refund.Status = RefundFailed
+	}
+	return refund, nil
+}
+
+// ParseNotification reads a Midtrans-style HTTP notification. The gateway
+// sends one notification per status change, so the transaction ID and
+// status together identify an event.
+func (h *HTTPProvider) ParseNotification(body []byte) (*Notification, error) {
+	var tx gatewayTransaction
+	if err := json.Unmarshal(body, &tx); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+	if tx.OrderID == "" || tx.TransactionStatus == "" {
+		return nil, fmt.Errorf("%w: order_id and transaction_status are required", ErrInvalidNotification)
+	}
+
+	charge := h.toCharge(&tx)
+	return &Notification{
+		ID:          tx.TransactionID + ":" + tx.TransactionStatus,
+		ProviderRef: tx.OrderID,
+		Reference:   tx.OrderID,
+		Status:      charge.Status,
+		Amount:      charge.Amount,
+	}, nil
+}
+
+// err reports a gateway-level failure. Midtrans answers most errors with
+// HTTP 200 and a non-2xx status_code in the body.
+func (tx *gatewayTransaction) err(provider string) error {
+	if tx.StatusCode == "" || strings.HasPrefix(tx.StatusCode, "2") {
+		return nil
+	}
+	return fmt.Errorf("%s: status %s: %s", provider, tx.StatusCode, tx.StatusMessage)
+}
+
+// MapGatewayStatus normalizes a Midtrans-style transaction_status.
+func MapGatewayStatus(transactionStatus, fraudStatus string) Status {
+	switch transactionStatus {
+	case "settlement", "refund", "partial_refund":
+		return StatusSuccess
+	case "capture":
+		if fraudStatus == "challenge" {
+			return StatusPending
+		}
+		return StatusSuccess
+	case "deny", "failure":
+		return StatusFailed
+	case "cancel":
+		return StatusCanceled
+	case "expire":
+		return StatusExpired
+	}
+	return StatusPending
+}
+
+func (h *HTTPProvider) toCharge(tx *gatewayTransaction) *Charge {
+	charge := &Charge{
+		ProviderRef: tx.OrderID,
+		Status:      MapGatewayStatus(tx.TransactionStatus, tx.FraudStatus),
+		PaymentCode: tx.PaymentCode,
+		QRString:    tx.QRString,
+		CheckoutURL: tx.RedirectURL,
+	}
+	fmt.Sscanf(tx.GrossAmount, "%f", &charge.Amount)
+	if len(tx.VANumbers) > 0 {
+		charge.PaymentCode = tx.VANumbers[0].VANumber
+	}
+	for _, action := range tx.Actions {
+		if action.Name == "deeplink-redirect" || action.Name == "generate-qr-code" {
+			if charge.CheckoutURL == "" {
+				charge.CheckoutURL = action.URL
+			}
+		}
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", tx.ExpiryTime, h.location); err == nil {
+		charge.ExpiresAt = t
+	}
+	return charge
+}
+
+func (h *HTTPProvider) do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, h.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(h.serverKey, "")
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", h.name, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s %s returned %d: %s", h.name, method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	return json.Unmarshal(data, out)
+}
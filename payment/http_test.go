@@ -0,0 +1,21 @@
+package payment
+
+import (
+	"testing"
+	"time"
+)
+
+func TestToChargeExpiryUsesGatewayZone(t *testing.T) {
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHTTPProvider("midtrans", "https://gateway.test", "key", jakarta)
+
+	charge := h.toCharge(&gatewayTransaction{ExpiryTime: "2025-11-24 17:00:00"})
+
+	want := time.Date(2025, 11, 24, 10, 0, 0, 0, time.UTC)
+	if !charge.ExpiresAt.Equal(want) {
+		t.Errorf("ExpiresAt = %s, want %s", charge.ExpiresAt.UTC(), want)
+	}
+}
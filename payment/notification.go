@@ -0,0 +1,42 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+var ErrInvalidNotification = errors.New("invalid payment notification")
+
+// Notification is an asynchronous status update pushed by a provider.
+type Notification struct {
+	ID          string // unique per delivery-worthy event, used for idempotency
+	ProviderRef string
+	Reference   string // our payment ID, when the provider echoes it
+	Status      Status
+	Amount      float64
+}
+
+// NotificationParser is implemented by providers that push webhooks.
+type NotificationParser interface {
+	ParseNotification(body []byte) (*Notification, error)
+}
+
+// Sign returns the hex HMAC-SHA256 of body under secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks a hex HMAC-SHA256 signature in constant time.
+func VerifySignature(secret string, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil || secret == "" {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
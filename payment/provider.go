@@ -0,0 +1,186 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/emyu/ecommer-be/config"
+)
+
+// Channel is the way a customer pays, independent of the provider that
+// processes it.
+type Channel string
+
+const (
+	ChannelQRIS         Channel = "qris"
+	ChannelBankTransfer Channel = "bank_transfer"
+	ChannelEWallet      Channel = "ewallet"
+	ChannelCreditCard   Channel = "credit_card"
+)
+
+// Status is the normalized state of a charge.
+type Status string
+
+const (
+	StatusPending  Status = "pending"
+	StatusSuccess  Status = "success"
+	StatusFailed   Status = "failed"
+	StatusExpired  Status = "expired"
+	StatusCanceled Status = "canceled"
+)
+
+// RefundStatus is the normalized state of a refund at the provider.
+type RefundStatus string
+
+const (
+	RefundProcessing RefundStatus = "processing"
+	RefundSucceeded  RefundStatus = "succeeded"
+	RefundFailed     RefundStatus = "failed"
+)
+
+var (
+	ErrUnknownChannel   = errors.New("unknown payment channel")
+	ErrUnknownProvider  = errors.New("unknown payment provider")
+	ErrChargeNotFound   = errors.New("charge not found")
+	ErrChargeNotPending = errors.New("charge is not pending")
+	ErrRefundRejected   = errors.New("refund rejected")
+)
+
+type ChargeRequest struct {
+	Reference     string // our payment ID, echoed back by the provider
+	OrderNumber   string
+	Amount        float64
+	Channel       Channel
+	Bank          string
+	VANumber      string // pre-assigned virtual account number, if any
+	CustomerName  string
+	CustomerEmail string
+	ExpiresAt     time.Time
+}
+
+type Charge struct {
+	ProviderRef string
+	Status      Status
+	Channel     Channel
+	Amount      float64
+	PaymentCode string // VA number or payment code shown to the customer
+	QRString    string
+	CheckoutURL string // redirect for e-wallet / card flows
+	ExpiresAt   time.Time
+}
+
+type RefundRequest struct {
+	Reference string // our refund ID
+	Amount    float64
+	Reason    string
+}
+
+type Refund struct {
+	ProviderRef string
+	Status      RefundStatus
+	Amount      float64
+}
+
+// PaymentProvider is implemented by every payment processor adapter.
+type PaymentProvider interface {
+	Name() string
+	CreateCharge(ctx context.Context, req ChargeRequest) (*Charge, error)
+	GetStatus(ctx context.Context, providerRef string) (*Charge, error)
+	Cancel(ctx context.Context, providerRef string) error
+	Refund(ctx context.Context, providerRef string, req RefundRequest) (*Refund, error)
+}
+
+var (
+	providers     = map[string]PaymentProvider{}
+	channelRoutes = map[Channel]string{}
+)
+
+// InitProviders registers the configured providers and routes every channel
+// to one of them. The fake provider is always available outside production.
+func InitProviders() error {
+	cfg := config.AppConfig
+
+	providers = map[string]PaymentProvider{}
+	if cfg.Env != "production" {
+		Register(NewFakeProvider())
+	}
+	if cfg.PaymentGatewayURL != "" {
+		Register(NewHTTPProvider(cfg.PaymentGatewayName, cfg.PaymentGatewayURL, cfg.PaymentGatewayKey, cfg.PaymentGatewayLocation))
+	}
+
+	channelRoutes = map[Channel]string{}
+	for _, ch := range []Channel{ChannelQRIS, ChannelBankTransfer, ChannelEWallet, ChannelCreditCard} {
+		name := cfg.PaymentProvider
+		if override, ok := cfg.PaymentChannelProviders[string(ch)]; ok {
+			name = override
+		}
+		if _, ok := providers[name]; !ok {
+			return fmt.Errorf("%w: %q for channel %s", ErrUnknownProvider, name, ch)
+		}
+		channelRoutes[ch] = name
+	}
+
+	// QRIS payments fall back to our own payload when the provider returns
+	// no QR string. Without complete merchant data the channel is turned
+	// off rather than keeping deployments that do not sell via QRIS from
+	// starting.
+	if err := ConfiguredQRISMerchant().Validate(); err != nil {
+		log.Printf("QRIS payments disabled: %v", err)
+		delete(channelRoutes, ChannelQRIS)
+	}
+	return nil
+}
+
+func Register(p PaymentProvider) {
+	providers[p.Name()] = p
+}
+
+// Get returns a registered provider by name.
+func Get(name string) (PaymentProvider, error) {
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, name)
+	}
+	return p, nil
+}
+
+// ForChannel returns the provider that processes a channel.
+func ForChannel(ch Channel) (PaymentProvider, error) {
+	name, ok := channelRoutes[ch]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownChannel, ch)
+	}
+	return Get(name)
+}
+
+// MethodAvailable returns ErrUnknownChannel when an order's payment_method
+// has no channel or its channel is disabled.
+func MethodAvailable(method string) error {
+	ch, err := ChannelForMethod(method)
+	if err != nil {
+		return err
+	}
+	if _, ok := channelRoutes[ch]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownChannel, ch)
+	}
+	return nil
+}
+
+// ChannelForMethod maps an order's payment_method to a channel.
+func ChannelForMethod(method string) (Channel, error) {
+	switch strings.ToLower(method) {
+	case "qris":
+		return ChannelQRIS, nil
+	case "bank_transfer":
+		return ChannelBankTransfer, nil
+	case "ewallet", "e_wallet":
+		return ChannelEWallet, nil
+	case "credit_card":
+		return ChannelCreditCard, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownChannel, method)
+}
@@ -0,0 +1,39 @@
+package payment
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/emyu/ecommer-be/config"
+)
+
+func TestInitProvidersDisablesIncompleteQRIS(t *testing.T) {
+	saved := config.AppConfig
+	defer func() { config.AppConfig = saved }()
+
+	config.AppConfig = config.Config{Env: "development", PaymentProvider: "fake"}
+	if err := InitProviders(); err != nil {
+		t.Fatalf("InitProviders without QRIS settings: %v", err)
+	}
+	if err := MethodAvailable("qris"); !errors.Is(err, ErrUnknownChannel) {
+		t.Errorf("MethodAvailable(qris) = %v, want ErrUnknownChannel", err)
+	}
+	if err := MethodAvailable("bank_transfer"); err != nil {
+		t.Errorf("MethodAvailable(bank_transfer) = %v, want nil", err)
+	}
+
+	config.AppConfig.QRISMerchantName = testMerchant.Name
+	config.AppConfig.QRISMerchantCity = testMerchant.City
+	config.AppConfig.QRISAcquirerDomain = testMerchant.AcquirerDomain
+	config.AppConfig.QRISMerchantPAN = testMerchant.MerchantPAN
+	config.AppConfig.QRISMerchantID = testMerchant.MerchantID
+	config.AppConfig.QRISNMID = testMerchant.NMID
+	config.AppConfig.QRISMCC = testMerchant.MCC
+	config.AppConfig.QRISCriteria = testMerchant.Criteria
+	if err := InitProviders(); err != nil {
+		t.Fatalf("InitProviders: %v", err)
+	}
+	if err := MethodAvailable("qris"); err != nil {
+		t.Errorf("MethodAvailable(qris) = %v, want nil", err)
+	}
+}
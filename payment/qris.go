@@ -0,0 +1,182 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emyu/ecommer-be/config"
+)
+
+var ErrInvalidQRISMerchant = errors.New("invalid QRIS merchant configuration")
+
+// maxTLVLength is the longest value a two-digit EMVCo length can describe.
+const maxTLVLength = 99
+
+// QRISMerchant holds the merchant data embedded in a QRIS payload.
+type QRISMerchant struct {
+	Name           string
+	City           string
+	PostalCode     string
+	AcquirerDomain string // reverse domain of the acquirer, e.g. ID.CO.BANKNAME.WWW
+	MerchantPAN    string
+	MerchantID     string
+	NMID           string // National Merchant ID issued by QRIS
+	MCC            string
+	Criteria       string // UMI, UKE, UME, UBE or URE
+}
+
+// ConfiguredQRISMerchant returns the merchant from the QRIS_* settings.
+func ConfiguredQRISMerchant() QRISMerchant {
+	cfg := config.AppConfig
+	return QRISMerchant{
+		Name:           cfg.QRISMerchantName,
+		City:           cfg.QRISMerchantCity,
+		PostalCode:     cfg.QRISPostalCode,
+		AcquirerDomain: cfg.QRISAcquirerDomain,
+		MerchantPAN:    cfg.QRISMerchantPAN,
+		MerchantID:     cfg.QRISMerchantID,
+		NMID:           cfg.QRISNMID,
+		MCC:            cfg.QRISMCC,
+		Criteria:       cfg.QRISCriteria,
+	}
+}
+
+// Validate returns ErrInvalidQRISMerchant, wrapped with the offending
+// setting, when a required field is empty or a field or merchant account
+// template does not fit in a QRIS payload.
+func (m QRISMerchant) Validate() error {
+	required := []struct{ name, value string }{
+		{"QRIS_MERCHANT_NAME", m.Name},
+		{"QRIS_MERCHANT_CITY", m.City},
+		{"QRIS_ACQUIRER_DOMAIN", m.AcquirerDomain},
+		{"QRIS_MERCHANT_PAN", m.MerchantPAN},
+		{"QRIS_MERCHANT_ID", m.MerchantID},
+		{"QRIS_NMID", m.NMID},
+		{"QRIS_MCC", m.MCC},
+		{"QRIS_MERCHANT_CRITERIA", m.Criteria},
+	}
+	for _, f := range required {
+		if strings.TrimSpace(f.value) == "" {
+			return fmt.Errorf("%w: %s is required", ErrInvalidQRISMerchant, f.name)
+		}
+	}
+	if len(m.MCC) != 4 || strings.Trim(m.MCC, "0123456789") != "" {
+		return fmt.Errorf("%w: QRIS_MCC must be 4 digits", ErrInvalidQRISMerchant)
+	}
+	switch m.Criteria {
+	case "UMI", "UKE", "UME", "UBE", "URE":
+	default:
+		return fmt.Errorf("%w: QRIS_MERCHANT_CRITERIA must be UMI, UKE, UME, UBE or URE", ErrInvalidQRISMerchant)
+	}
+
+	var enc tlvEncoder
+	m.accountTemplates(&enc)
+	enc.tlv("61", m.PostalCode)
+	if enc.err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidQRISMerchant, enc.err)
+	}
+	return nil
+}
+
+// accountTemplates returns the merchant account information of tags 26
+// and 51.
+func (m QRISMerchant) accountTemplates(enc *tlvEncoder) string {
+	return enc.tlv("26",
+		enc.tlv("00", m.AcquirerDomain)+
+			enc.tlv("01", m.MerchantPAN)+
+			enc.tlv("02", m.MerchantID)+
+			enc.tlv("03", m.Criteria)) +
+		enc.tlv("51",
+			enc.tlv("00", "ID.CO.QRIS.WWW")+
+				enc.tlv("02", m.NMID)+
+				enc.tlv("03", m.Criteria))
+}
+
+// BuildQRISPayload returns a dynamic EMVCo merchant-presented QR payload
+// for a single payment, terminated by its CRC16 checksum (tag 63).
+func BuildQRISPayload(m QRISMerchant, amount float64, billNumber, reference string) (string, error) {
+	if err := m.Validate(); err != nil {
+		return "", err
+	}
+
+	var enc tlvEncoder
+	var b strings.Builder
+
+	b.WriteString(enc.tlv("00", "01")) // payload format indicator
+	b.WriteString(enc.tlv("01", "12")) // point of initiation: dynamic
+	b.WriteString(m.accountTemplates(&enc))
+
+	b.WriteString(enc.tlv("52", m.MCC))
+	b.WriteString(enc.tlv("53", "360")) // IDR
+	b.WriteString(enc.tlv("54", formatQRISAmount(amount)))
+	b.WriteString(enc.tlv("58", "ID"))
+	b.WriteString(enc.tlv("59", truncate(m.Name, 25)))
+	b.WriteString(enc.tlv("60", truncate(m.City, 15)))
+	b.WriteString(enc.tlv("61", m.PostalCode))
+	b.WriteString(enc.tlv("62",
+		enc.tlv("01", truncate(billNumber, 25))+
+			enc.tlv("05", truncate(reference, 25))))
+	if enc.err != nil {
+		return "", enc.err
+	}
+
+	payload := b.String() + "6304"
+	return payload + fmt.Sprintf("%04X", CRC16CCITT([]byte(payload))), nil
+}
+
+// CRC16CCITT computes CRC-16/CCITT-FALSE (poly 0x1021, init 0xFFFF) as
+// required by EMVCo for tag 63.
+func CRC16CCITT(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, c := range data {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// tlvEncoder writes EMVCo tag-length-value fields. Empty values are left
+// out, as optional fields are; a value too long for its two-digit length
+// is left out too and recorded in err, so the caller never emits a
+// corrupt payload.
+type tlvEncoder struct {
+	err error
+}
+
+func (e *tlvEncoder) tlv(id, value string) string {
+	n := utf8.RuneCountInString(value)
+	if n > maxTLVLength {
+		if e.err == nil {
+			e.err = fmt.Errorf("QRIS tag %s is %d characters long, at most %d fit", id, n, maxTLVLength)
+		}
+		return ""
+	}
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s%02d%s", id, n, value)
+}
+
+func formatQRISAmount(amount float64) string {
+	if amount == math.Trunc(amount) {
+		return fmt.Sprintf("%.0f", amount)
+	}
+	return fmt.Sprintf("%.2f", amount)
+}
+
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) > max {
+		return string(r[:max])
+	}
+	return s
+}
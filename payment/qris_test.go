@@ -0,0 +1,171 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var testMerchant = QRISMerchant{
+	Name:           "EMYU",
+	City:           "JAKARTA",
+	AcquirerDomain: "ID.CO.QRIS.WWW",
+	MerchantPAN:    "9360000000000000001",
+	MerchantID:     "000000000000001",
+	NMID:           "ID0000000000001",
+	MCC:            "5691",
+	Criteria:       "UMI",
+}
+
+func TestCRC16CCITT(t *testing.T) {
+	cases := []struct {
+		data string
+		want uint16
+	}{
+		{"", 0xFFFF},
+		{"123456789", 0x29B1}, // CRC-16/CCITT-FALSE check value
+		{"A", 0xB915},
+	}
+
+	for _, tc := range cases {
+		if got := CRC16CCITT([]byte(tc.data)); got != tc.want {
+			t.Errorf("CRC16CCITT(%q) = %04X, want %04X", tc.data, got, tc.want)
+		}
+	}
+}
+
+func TestBuildQRISPayload(t *testing.T) {
+	const want = "000201010212" +
+		"26670014ID.CO.QRIS.WWW0119936000000000000000102150000000000000010303UMI" +
+		"51440014ID.CO.QRIS.WWW0215ID00000000000010303UMI" +
+		"520456915303360540515000" +
+		"5802ID5904EMYU6007JAKARTA" +
+		"62160105ORD-10503abc" +
+		"63046C42"
+
+	got, err := BuildQRISPayload(testMerchant, 15000, "ORD-1", "abc")
+	if err != nil {
+		t.Fatalf("BuildQRISPayload: %v", err)
+	}
+	if got != want {
+		t.Fatalf("BuildQRISPayload =\n%s\nwant\n%s", got, want)
+	}
+
+	// Every field must carry its own length, and tag 63 the checksum of
+	// everything before its value.
+	tags := parseTLV(t, got)
+	crcAt := len(got) - 4
+	if crc := fmt.Sprintf("%04X", CRC16CCITT([]byte(got[:crcAt]))); tags["63"] != crc {
+		t.Errorf("tag 63 = %q, want %q", tags["63"], crc)
+	}
+	if tags["54"] != "15000" {
+		t.Errorf("tag 54 = %q, want 15000", tags["54"])
+	}
+}
+
+func TestBuildQRISPayloadAmount(t *testing.T) {
+	cases := []struct {
+		amount float64
+		want   string
+	}{
+		{15000, "15000"},
+		{15000.5, "15000.50"},
+		{99.99, "99.99"},
+	}
+
+	for _, tc := range cases {
+		got, err := BuildQRISPayload(testMerchant, tc.amount, "ORD-1", "abc")
+		if err != nil {
+			t.Fatalf("BuildQRISPayload(%v): %v", tc.amount, err)
+		}
+		if tags := parseTLV(t, got); tags["54"] != tc.want {
+			t.Errorf("amount %v: tag 54 = %q, want %q", tc.amount, tags["54"], tc.want)
+		}
+	}
+}
+
+func TestTLVEncoder(t *testing.T) {
+	var enc tlvEncoder
+
+	if got := enc.tlv("59", "EMYU"); got != "5904EMYU" {
+		t.Errorf("tlv(59, EMYU) = %q, want 5904EMYU", got)
+	}
+	if got := enc.tlv("60", "Jakartå"); got != "6007Jakartå" {
+		t.Errorf("length must count characters, got %q", got)
+	}
+	if got := enc.tlv("61", ""); got != "" {
+		t.Errorf("empty value must be left out, got %q", got)
+	}
+	if got := enc.tlv("62", strings.Repeat("x", maxTLVLength)); got != "6299"+strings.Repeat("x", maxTLVLength) {
+		t.Errorf("99 characters must fit, got %q", got)
+	}
+	if enc.err != nil {
+		t.Fatalf("unexpected error: %v", enc.err)
+	}
+
+	if got := enc.tlv("26", strings.Repeat("x", maxTLVLength+1)); got != "" {
+		t.Errorf("too long value must be left out, got %q", got)
+	}
+	if enc.err == nil {
+		t.Fatal("too long value must set err")
+	}
+	first := enc.err
+	enc.tlv("51", strings.Repeat("y", maxTLVLength+1))
+	if enc.err != first {
+		t.Errorf("err = %v, want the first error %v", enc.err, first)
+	}
+}
+
+func TestQRISMerchantValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(m *QRISMerchant)
+		valid  bool
+	}{
+		{"complete", func(m *QRISMerchant) {}, true},
+		{"postal code is optional", func(m *QRISMerchant) { m.PostalCode = "" }, true},
+		{"missing PAN", func(m *QRISMerchant) { m.MerchantPAN = "" }, false},
+		{"blank NMID", func(m *QRISMerchant) { m.NMID = "  " }, false},
+		{"MCC with letters", func(m *QRISMerchant) { m.MCC = "56A1" }, false},
+		{"MCC too short", func(m *QRISMerchant) { m.MCC = "569" }, false},
+		{"unknown criteria", func(m *QRISMerchant) { m.Criteria = "XYZ" }, false},
+		// The acquirer domain alone fits, but not inside tag 26.
+		{"account template too long", func(m *QRISMerchant) { m.AcquirerDomain = strings.Repeat("A", 60) }, false},
+		{"postal code too long", func(m *QRISMerchant) { m.PostalCode = strings.Repeat("1", 100) }, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := testMerchant
+			tc.modify(&m)
+			err := m.Validate()
+			if tc.valid && err != nil {
+				t.Errorf("Validate() = %v, want nil", err)
+			}
+			if !tc.valid && !errors.Is(err, ErrInvalidQRISMerchant) {
+				t.Errorf("Validate() = %v, want ErrInvalidQRISMerchant", err)
+			}
+		})
+	}
+}
+
+// parseTLV splits a payload into its top-level tags and fails the test when
+// a length does not match the data.
+func parseTLV(t *testing.T, payload string) map[string]string {
+	t.Helper()
+	tags := map[string]string{}
+	for rest := payload; rest != ""; {
+		if len(rest) < 4 {
+			t.Fatalf("truncated field %q", rest)
+		}
+		n, err := strconv.Atoi(rest[2:4])
+		if err != nil || len(rest) < 4+n {
+			t.Fatalf("bad length in %q", rest)
+		}
+		tags[rest[:2]] = rest[4 : 4+n]
+		rest = rest[4+n:]
+	}
+	return tags
+}
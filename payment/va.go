@@ -0,0 +1,36 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Banks that issue virtual accounts for bank_transfer payments.
+var Banks = []string{"bca", "bni", "mandiri", "bri"}
+
+var ErrVANumberSpace = errors.New("virtual account number space exhausted")
+
+// FormatVANumber builds a virtual account number from a bank prefix and a
+// sequence value, zero-padding the suffix to the requested total length.
+func FormatVANumber(prefix string, seq int64, length int) (string, error) {
+	width := length - len(prefix)
+	if width <= 0 {
+		return "", fmt.Errorf("prefix %q is longer than VA length %d", prefix, length)
+	}
+	suffix := fmt.Sprintf("%0*d", width, seq)
+	if len(suffix) > width {
+		return "", fmt.Errorf("%w for prefix %s", ErrVANumberSpace, prefix)
+	}
+	return prefix + suffix, nil
+}
+
+// IsBank reports whether bank is a supported VA bank.
+func IsBank(bank string) bool {
+	for _, b := range Banks {
+		if b == strings.ToLower(bank) {
+			return true
+		}
+	}
+	return false
+}
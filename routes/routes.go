@@ -1,8 +1,14 @@
 package routes
 
 import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/emyu/ecommer-be/config"
 	"github.com/emyu/ecommer-be/handlers"
 	"github.com/emyu/ecommer-be/middleware"
+	"github.com/emyu/ecommer-be/utils"
 	"github.com/gin-gonic/gin"
 )
 
@@ -15,6 +21,11 @@ func SetupRoutes(router *gin.Engine) {
 	{
 		auth.POST("/register", handlers.Register)
 		auth.POST("/login", handlers.Login)
+		auth.POST("/login/2fa", handlers.LoginTwoFactor)
+		auth.POST("/refresh", handlers.RefreshToken)
+		auth.POST("/password/forgot", handlers.ForgotPassword)
+		auth.POST("/password/reset", handlers.ResetPassword)
+		auth.POST("/email/verify", handlers.VerifyEmail)
 	}
 
 	// Public routes - Products & Categories
@@ -26,12 +37,36 @@ func SetupRoutes(router *gin.Engine) {
 		public.GET("/categories/:id", handlers.GetCategoryByID)
 	}
 
+	// Payment provider webhooks (authenticated by signature)
+	webhooks := router.Group("/api/webhooks")
+	{
+		webhooks.POST("/payments/:provider", handlers.PaymentWebhook)
+	}
+
 	// Protected routes - Auth only
 	protected := router.Group("/api")
 	protected.Use(middleware.AuthMiddleware())
 	{
 		protected.GET("/me", handlers.GetMyProfile)
+		protected.PUT("/me", handlers.UpdateMyProfile)
+		protected.POST("/me/email", handlers.ChangeMyEmail)
+		protected.DELETE("/me/email", handlers.CancelMyEmailChange)
+		protected.PUT("/me/password", handlers.ChangeMyPassword)
+		protected.GET("/me/login-history", handlers.GetMyLoginHistory)
+		protected.GET("/me/export", handlers.ExportMyData)
+		protected.GET("/me/erasure-request", handlers.GetMyErasureRequests)
+		protected.POST("/me/erasure-request", handlers.RequestMyErasure)
+		protected.DELETE("/me/erasure-request", handlers.CancelMyErasure)
 		protected.POST("/logout", handlers.Logout)
+		protected.POST("/email/verify/resend", handlers.ResendVerificationEmail)
+
+		// Two-factor authentication
+		protected.GET("/me/2fa", handlers.GetTwoFactorStatus)
+		protected.POST("/me/2fa/setup", handlers.SetupTwoFactor)
+		protected.GET("/me/2fa/qr", handlers.GetTwoFactorQRImage)
+		protected.POST("/me/2fa/enable", handlers.EnableTwoFactor)
+		protected.POST("/me/2fa/disable", handlers.DisableTwoFactor)
+		protected.POST("/me/2fa/recovery-codes", handlers.RegenerateRecoveryCodes)
 
 		// Cart
 		protected.GET("/carts", handlers.GetUserCart)
@@ -39,17 +74,21 @@ func SetupRoutes(router *gin.Engine) {
 		protected.POST("/cart-items", handlers.AddToCart)
 		protected.PUT("/cart-items/:itemId", handlers.UpdateCartItem)
 		protected.DELETE("/cart-items/:itemId", handlers.RemoveFromCart)
+		protected.POST("/carts/coupon", handlers.ApplyCartCoupon)
+		protected.DELETE("/carts/coupon", handlers.RemoveCartCoupon)
 
 		// Orders
 		protected.GET("/orders", handlers.GetUserOrders)
 		protected.GET("/orders/:id", handlers.GetOrderByID)
 		protected.POST("/orders", handlers.CreateOrder)
+		protected.POST("/orders/:id/cancel", handlers.CancelOrder)
+		protected.POST("/checkout", handlers.Checkout)
 
 		// Payments
 		protected.GET("/payments", handlers.GetPayments)
 		protected.POST("/payments", handlers.CreatePayment)
 		protected.GET("/payments/:id", handlers.GetPaymentByID)
-		protected.PUT("/payments/:id", handlers.UpdatePaymentStatus)
+		protected.GET("/payments/:id/qris", handlers.GetPaymentQRImage)
 
 		// Reviews
 		protected.GET("/reviews/products/:productId", handlers.GetReviewsByProduct)
@@ -66,30 +105,137 @@ func SetupRoutes(router *gin.Engine) {
 		protected.DELETE("/shipping-addresses/:id", handlers.DeleteShippingAddress)
 	}
 
-	// Admin routes
-	admin := router.Group("/api/admin")
-	admin.Use(middleware.AuthMiddleware(), middleware.AdminMiddleware())
-	{
+	// Admin and seller routes - each one guarded by the permission it needs
+	for _, group := range PermissionRoutes() {
+		g := router.Group(group.Prefix)
+		g.Use(middleware.AuthMiddleware(), middleware.TwoFactorPolicyMiddleware())
+		for _, r := range group.Routes {
+			g.Handle(r.Method, r.Path, middleware.PermissionMiddleware(r.Permission), r.Handler)
+		}
+	}
+
+	// Development helpers
+	if config.AppConfig.Env != "production" {
+		dev := router.Group("/api/dev")
+		dev.Use(middleware.AuthMiddleware())
+		{
+			dev.POST("/payments/:id/:action", handlers.FakePaymentAction)
+		}
+	}
+}
+
+// PermissionRoute is an endpoint and the permission it requires.
+type PermissionRoute struct {
+	Method     string
+	Path       string
+	Permission string
+	Handler    gin.HandlerFunc
+}
+
+// RouteGroup is a set of permission-guarded routes under a common prefix.
+type RouteGroup struct {
+	Prefix string
+	Routes []PermissionRoute
+}
+
+// PermissionRoutes is the route -> permission table. Print it with
+// `go run cmd/api/main.go -routes`.
+func PermissionRoutes() []RouteGroup {
+	return []RouteGroup{
+		{"/api/admin", adminRoutes()},
+		{"/api/seller", sellerRoutes()},
+	}
+}
+
+func adminRoutes() []PermissionRoute {
+	return []PermissionRoute{
 		// Products
-		admin.POST("/products", handlers.CreateProduct)
-		admin.PUT("/products/:id", handlers.UpdateProduct)
-		admin.DELETE("/products/:id", handlers.DeleteProduct)
+		{"POST", "/products", utils.PermManageProducts, handlers.CreateProduct},
+		{"PUT", "/products/:id", utils.PermManageProducts, handlers.UpdateProduct},
+		{"DELETE", "/products/:id", utils.PermManageProducts, handlers.DeleteProduct},
+
+		// Inventory
+		{"GET", "/product-variants/:id/stock", utils.PermManageProducts, handlers.GetVariantStock},
+		{"POST", "/product-variants/:id/stock", utils.PermManageProducts, handlers.AdjustVariantStock},
+		{"GET", "/product-variants/:id/stock-movements", utils.PermManageProducts, handlers.GetStockMovements},
 
 		// Categories
-		admin.POST("/categories", handlers.CreateCategory)
-		admin.PUT("/categories/:id", handlers.UpdateCategory)
-		admin.DELETE("/categories/:id", handlers.DeleteCategory)
+		{"POST", "/categories", utils.PermManageCategories, handlers.CreateCategory},
+		{"PUT", "/categories/:id", utils.PermManageCategories, handlers.UpdateCategory},
+		{"DELETE", "/categories/:id", utils.PermManageCategories, handlers.DeleteCategory},
+
+		// Coupons
+		{"GET", "/coupons", utils.PermManageCoupons, handlers.GetCoupons},
+		{"GET", "/coupons/:id", utils.PermManageCoupons, handlers.GetCouponByID},
+		{"POST", "/coupons", utils.PermManageCoupons, handlers.CreateCoupon},
+		{"PUT", "/coupons/:id", utils.PermManageCoupons, handlers.UpdateCoupon},
+		{"DELETE", "/coupons/:id", utils.PermManageCoupons, handlers.DeleteCoupon},
 
 		// Order management
-		admin.GET("/orders", handlers.GetAllOrders)
-		admin.PUT("/orders/:id", handlers.UpdateOrderStatus)
-		admin.DELETE("/orders/:id", handlers.DeleteOrder)
+		{"GET", "/orders", utils.PermManageOrders, handlers.GetAllOrders},
+		{"PUT", "/orders/:id", utils.PermManageOrders, handlers.UpdateOrderStatus},
+
+		// Payments
+		{"POST", "/payments/bank-mutations", utils.PermManagePayments, handlers.ImportBankMutations},
+		{"POST", "/payments/:id/refunds", utils.PermManagePayments, handlers.CreateRefund},
+		{"PUT", "/refunds/:id", utils.PermManagePayments, handlers.UpdateRefundStatus},
+
+		// Seller payouts
+		{"GET", "/payouts/balances", utils.PermManagePayouts, handlers.GetSellerBalances},
+		{"GET", "/payouts/ledger", utils.PermManagePayouts, handlers.GetLedger},
+		{"GET", "/payouts/batches", utils.PermManagePayouts, handlers.GetPayoutBatches},
+		{"POST", "/payouts/batches", utils.PermManagePayouts, handlers.CreatePayoutBatch},
+		{"GET", "/payouts/batches/:id", utils.PermManagePayouts, handlers.GetPayoutBatchByID},
+		{"GET", "/payouts/batches/:id/export", utils.PermManagePayouts, handlers.ExportPayoutBatch},
+		{"POST", "/payouts/batches/:id/paid", utils.PermManagePayouts, handlers.MarkPayoutBatchPaid},
+		{"POST", "/payouts/batches/:id/cancel", utils.PermManagePayouts, handlers.CancelPayoutBatch},
 
 		// User management
-		admin.GET("/users", handlers.GetAllUsers)
-		admin.GET("/users/:id", handlers.GetUserByID)
-		admin.PUT("/users/:id", handlers.UpdateUser)
-		admin.DELETE("/users/:id", handlers.DeleteUser)
-		admin.GET("/users/:id/stats", handlers.GetUserStats)
+		{"GET", "/users", utils.PermManageUsers, handlers.GetAllUsers},
+		{"GET", "/users/:id", utils.PermManageUsers, handlers.GetUserByID},
+		{"PUT", "/users/:id", utils.PermManageUsers, handlers.UpdateUser},
+		{"POST", "/users/:id/unlock", utils.PermManageUsers, handlers.UnlockUser},
+		{"POST", "/users/:id/deactivate", utils.PermManageUsers, handlers.DeactivateUser},
+		{"POST", "/users/:id/activate", utils.PermManageUsers, handlers.ActivateUser},
+		{"DELETE", "/users/:id", utils.PermManageUsers, handlers.DeleteUser},
+		{"GET", "/users/:id/export", utils.PermManageUsers, handlers.ExportUserData},
+		{"GET", "/erasure-requests", utils.PermManageUsers, handlers.GetErasureRequests},
+		{"POST", "/erasure-requests/:id/approve", utils.PermManageUsers, handlers.ApproveErasureRequest},
+		{"POST", "/erasure-requests/:id/reject", utils.PermManageUsers, handlers.RejectErasureRequest},
+		{"GET", "/users/:id/stats", utils.PermViewReports, handlers.GetUserStats},
+
+		// Roles & permissions
+		{"GET", "/permissions", utils.PermManageRoles, handlers.GetPermissions},
+		{"GET", "/roles", utils.PermManageRoles, handlers.GetRoles},
+		{"GET", "/roles/:id", utils.PermManageRoles, handlers.GetRoleByID},
+		{"POST", "/roles", utils.PermManageRoles, handlers.CreateRole},
+		{"PUT", "/roles/:id", utils.PermManageRoles, handlers.UpdateRole},
+		{"DELETE", "/roles/:id", utils.PermManageRoles, handlers.DeleteRole},
+		{"POST", "/roles/:id/activate", utils.PermManageRoles, handlers.ActivateRole},
+		{"POST", "/roles/:id/deactivate", utils.PermManageRoles, handlers.DeactivateRole},
+		{"POST", "/roles/:id/permissions", utils.PermManageRoles, handlers.AddRolePermission},
+		{"DELETE", "/roles/:id/permissions/:permission", utils.PermManageRoles, handlers.RemoveRolePermission},
+	}
+}
+
+func sellerRoutes() []PermissionRoute {
+	return []PermissionRoute{
+		{"GET", "/orders", utils.PermViewOrders, handlers.GetSellerOrders},
+		{"GET", "/sales-report", utils.PermViewSalesReports, handlers.GetSellerSalesReport},
+		{"GET", "/balance", utils.PermViewSalesReports, handlers.GetMySellerBalance},
+		{"GET", "/payout-account", utils.PermManagePayoutAccount, handlers.GetMyPayoutAccount},
+		{"PUT", "/payout-account", utils.PermManagePayoutAccount, handlers.UpdateMyPayoutAccount},
+	}
+}
+
+// PrintPermissionRoutes writes the route -> permission table to w.
+func PrintPermissionRoutes(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tPATH\tPERMISSION")
+	for _, group := range PermissionRoutes() {
+		for _, r := range group.Routes {
+			fmt.Fprintf(tw, "%s\t%s%s\t%s\n", r.Method, group.Prefix, r.Path, r.Permission)
+		}
 	}
+	tw.Flush()
 }
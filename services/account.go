@@ -0,0 +1,139 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+var (
+	ErrWrongPassword = errors.New("current password is incorrect")
+	ErrSameEmail     = errors.New("new email is the same as the current one")
+)
+
+// RequestEmailChange starts a change of the login email. The new address
+// is kept as pending_email and only replaces the current one once the link
+// sent to it is opened (see VerifyEmail), so a typo cannot lock the user
+// out. The password check is throttled like a login.
+func RequestEmailChange(userID, password, newEmail, ipAddress, userAgent string) error {
+	newEmail = strings.TrimSpace(newEmail)
+
+	verified, err := verifyCurrentPassword(userID, password, ipAddress, userAgent)
+	if err != nil {
+		return err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var name, email, hashed string
+	err = tx.QueryRow(
+		"SELECT name, email, password FROM users WHERE id = ? FOR UPDATE", userID,
+	).Scan(&name, &email, &hashed)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if hashed != verified {
+		return ErrWrongPassword
+	}
+	if strings.EqualFold(newEmail, email) {
+		return ErrSameEmail
+	}
+
+	var taken bool
+	if err := tx.QueryRow("SELECT COUNT(*) > 0 FROM users WHERE email = ?", newEmail).Scan(&taken); err != nil {
+		return err
+	}
+	if taken {
+		return ErrEmailTaken
+	}
+
+	if _, err := tx.Exec("UPDATE users SET pending_email = ? WHERE id = ?", newEmail, userID); err != nil {
+		return err
+	}
+	if err := SendVerificationEmail(tx, userID, name, newEmail); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CancelEmailChange drops a pending email change; links already sent stop
+// working.
+func CancelEmailChange(userID string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET pending_email = NULL WHERE id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		UPDATE email_verification_tokens t JOIN users u ON u.id = t.user_id
+		SET t.used_at = NOW()
+		WHERE t.user_id = ? AND t.used_at IS NULL AND t.email <> u.email
+	`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ChangePassword sets a new password after checking the current one. Every
+// other session of the user is revoked and outstanding reset links stop
+// working; the session that made the change stays logged in. The check of
+// the current password is throttled like a login.
+func ChangePassword(userID, currentPassword, newPassword, currentSessionID, ipAddress, userAgent string) error {
+	verified, err := verifyCurrentPassword(userID, currentPassword, ipAddress, userAgent)
+	if err != nil {
+		return err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var email, hashed string
+	err = tx.QueryRow("SELECT email, password FROM users WHERE id = ? FOR UPDATE", userID).Scan(&email, &hashed)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if hashed != verified {
+		return ErrWrongPassword
+	}
+	if err := CheckPasswordPolicy(newPassword, email); err != nil {
+		return err
+	}
+
+	newHash, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE users SET password = ? WHERE id = ?", newHash, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		"UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = ? AND used_at IS NULL", userID,
+	); err != nil {
+		return err
+	}
+	if err := revokeOtherSessions(tx, userID, currentSessionID, "password changed"); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,302 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/payment"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+var ErrInvalidMutationFile = errors.New("invalid bank mutation file")
+
+var vaCandidatePattern = regexp.MustCompile(`\d{10,20}`)
+
+// Amounts in bank statements use either "," thousands separators with a
+// "." decimal point (613,000.00) or the Indonesian "." thousands separators
+// with a "," decimal comma (613.000,00). With at most two decimals and
+// groups of three digits the two never overlap, so each amount is read in
+// the one format it matches; anything else is rejected.
+var (
+	amountPatternDot   = regexp.MustCompile(`^-?(\d{1,3}(,\d{3})+|\d+)(\.\d{1,2})?$`)
+	amountPatternComma = regexp.MustCompile(`^-?(\d{1,3}(\.\d{3})+|\d+)(,\d{1,2})?$`)
+)
+
+// Limits of the bank_mutations columns. Longer descriptions are cut;
+// other values that do not fit reject the file before anything is
+// imported.
+const (
+	maxMutationDescription = 255
+	maxMutationDate        = 30
+	maxMutationAmount      = 9999999999.99
+)
+
+// MutationImportResult summarizes a bank mutation import.
+type MutationImportResult struct {
+	TotalRows  int              `json:"total_rows"`
+	Imported   int              `json:"imported"`
+	Duplicates int              `json:"duplicates"`
+	Skipped    int              `json:"skipped"`
+	Matched    []MutationMatch  `json:"matched"`
+	Unmatched  []MutationReject `json:"unmatched"`
+}
+
+type MutationMatch struct {
+	Row       int     `json:"row"`
+	PaymentID string  `json:"payment_id"`
+	VANumber  string  `json:"va_number"`
+	Amount    float64 `json:"amount"`
+	PaidLate  bool    `json:"paid_late,omitempty"`
+}
+
+type MutationReject struct {
+	Row    int     `json:"row"`
+	Amount float64 `json:"amount"`
+	Reason string  `json:"reason"`
+}
+
+type mutationRow struct {
+	line        int
+	date        string
+	description string
+	reference   string
+	amount      float64
+}
+
+// ImportBankMutations reads a bank statement CSV and settles pending
+// bank_transfer payments whose VA number appears in a credit line with the
+// exact payment amount. The CSV needs a header row with date, description
+// and amount columns; optional type (CR/DB) and reference columns are used
+// when present. Re-importing the same statement is safe: lines that were
+// already imported are counted as duplicates.
+func ImportBankMutations(bank string, r io.Reader, actorID string) (*MutationImportResult, error) {
+	bank = strings.ToLower(bank)
+	if !payment.IsBank(bank) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedBank, bank)
+	}
+
+	rows, err := parseMutationCSV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MutationImportResult{TotalRows: len(rows), Matched: []MutationMatch{}, Unmatched: []MutationReject{}}
+	seen := map[string]int{}
+
+	for _, row := range rows {
+		if row.amount <= 0 {
+			result.Skipped++
+			continue
+		}
+
+		// Identical lines in one statement are legitimate (two customers can
+		// pay the same amount on the same day), so the occurrence count is
+		// part of the fingerprint.
+		key := fmt.Sprintf("%s|%s|%s|%s|%.2f", bank, row.date, row.description, row.reference, row.amount)
+		seen[key]++
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d", key, seen[key])))
+		fingerprint := hex.EncodeToString(sum[:])
+
+		match, reason, duplicate, err := importMutation(bank, row, fingerprint, actorID)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case duplicate:
+			result.Duplicates++
+		case match != nil:
+			result.Imported++
+			result.Matched = append(result.Matched, *match)
+		default:
+			result.Imported++
+			result.Unmatched = append(result.Unmatched, MutationReject{Row: row.line, Amount: row.amount, Reason: reason})
+		}
+	}
+
+	return result, nil
+}
+
+func importMutation(bank string, row mutationRow, fingerprint, actorID string) (*MutationMatch, string, bool, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, "", false, err
+	}
+	defer tx.Rollback()
+
+	mutationID := utils.GenerateID()
+	_, err = tx.Exec(`
+		INSERT INTO bank_mutations (id, bank, transaction_date, description, amount, fingerprint, imported_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, mutationID, bank, row.date, row.description, row.amount, fingerprint, nullString(actorID))
+	if isDuplicateKey(err) {
+		return nil, "", true, nil
+	}
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	reason := "no virtual account number found"
+	var match *MutationMatch
+	for _, candidate := range vaCandidatePattern.FindAllString(row.description+" "+row.reference, -1) {
+		var paymentID, status string
+		var amount float64
+		err := tx.QueryRow(`
+			SELECT id, payment_status, amount FROM payments
+			WHERE va_number = ? AND bank = ? FOR UPDATE
+		`, candidate, bank).Scan(&paymentID, &status, &amount)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, "", false, err
+		}
+
+		// Money for an expired or failed payment is still matched, so it is
+		// recorded as paid late instead of being lost.
+		late := status == PaymentStatusExpired || status == PaymentStatusFailed
+		if status != PaymentStatusPending && !late {
+			reason = fmt.Sprintf("payment for VA %s is already %s", candidate, status)
+			continue
+		}
+		if math.Abs(amount-row.amount) >= 0.005 {
+			reason = fmt.Sprintf("amount %.2f does not match %.2f due on VA %s", row.amount, amount, candidate)
+			continue
+		}
+
+		note := fmt.Sprintf("Matched %s bank mutation (row %d)", strings.ToUpper(bank), row.line)
+		if err := applyPaymentStatus(tx, paymentID, payment.StatusSuccess, ActorPayment, note); err != nil {
+			return nil, "", false, err
+		}
+		if late {
+			note += fmt.Sprintf(", paid late: payment was %s", status)
+		}
+		match = &MutationMatch{Row: row.line, PaymentID: paymentID, VANumber: candidate, Amount: row.amount, PaidLate: late}
+		reason = note
+		break
+	}
+
+	var matchedPaymentID string
+	if match != nil {
+		matchedPaymentID = match.PaymentID
+	}
+	_, err = tx.Exec(
+		"UPDATE bank_mutations SET matched_payment_id = ?, match_note = ? WHERE id = ?",
+		nullString(matchedPaymentID), reason, mutationID,
+	)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	return match, reason, false, tx.Commit()
+}
+
+func parseMutationCSV(r io.Reader) ([]mutationRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMutationFile, err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"date", "description", "amount"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("%w: missing %q column", ErrInvalidMutationFile, required)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var rows []mutationRow
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidMutationFile, line, err)
+		}
+
+		amount, err := parseMutationAmount(field(record, "amount"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidMutationFile, line, err)
+		}
+		date := field(record, "date")
+		if utf8.RuneCountInString(date) > maxMutationDate {
+			return nil, fmt.Errorf("%w: line %d: date is longer than %d characters", ErrInvalidMutationFile, line, maxMutationDate)
+		}
+
+		// Debit lines are outgoing money and never settle a payment.
+		switch strings.ToUpper(field(record, "type")) {
+		case "DB", "D", "DEBIT":
+			amount = -math.Abs(amount)
+		}
+
+		rows = append(rows, mutationRow{
+			line:        line,
+			date:        date,
+			description: truncateRunes(field(record, "description"), maxMutationDescription),
+			reference:   field(record, "reference"),
+			amount:      amount,
+		})
+	}
+
+	return rows, nil
+}
+
+// parseMutationAmount reads amounts such as "613000", "613,000.00",
+// "613.000,00" or "-25,000"; see amountPatternDot and amountPatternComma.
+func parseMutationAmount(s string) (float64, error) {
+	s = strings.ReplaceAll(s, " ", "")
+	switch {
+	case s == "":
+		return 0, errors.New("empty amount")
+	case amountPatternDot.MatchString(s):
+		s = strings.ReplaceAll(s, ",", "")
+	case amountPatternComma.MatchString(s):
+		s = strings.ReplaceAll(strings.ReplaceAll(s, ".", ""), ",", ".")
+	default:
+		return 0, fmt.Errorf("unrecognized amount %q", s)
+	}
+
+	amount, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.Abs(amount) > maxMutationAmount {
+		return 0, fmt.Errorf("amount %s is too large", s)
+	}
+	return amount, nil
+}
+
+func truncateRunes(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max])
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseMutationAmount(t *testing.T) {
+	valid := []struct {
+		in   string
+		want float64
+	}{
+		{"613000", 613000},
+		{"613000.5", 613000.5},
+		{"613,000.00", 613000},
+		{"1,234,567.89", 1234567.89},
+		{"-25,000", -25000},
+		{"613.000,00", 613000},
+		{"1.234.567,89", 1234567.89},
+		{"613000,50", 613000.5},
+		{"613.000", 613000},
+		{"613,000", 613000},
+		{" 613 000 ", 613000},
+	}
+	for _, tc := range valid {
+		got, err := parseMutationAmount(tc.in)
+		if err != nil {
+			t.Errorf("parseMutationAmount(%q): %v", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseMutationAmount(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"abc",
+		"Rp613.000",
+		"613.000.00",
+		"613,000,00",
+		"61,30,00",
+		"1,234.567,89",
+		"613000.123",
+		"1e6",
+		"99999999999",
+	}
+	for _, in := range invalid {
+		if got, err := parseMutationAmount(in); err == nil {
+			t.Errorf("parseMutationAmount(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestParseMutationCSV(t *testing.T) {
+	longDescription := strings.Repeat("é", 300)
+	csv := "Date,Description,Amount,Type,Reference\n" +
+		"2025-11-23,TRF VA 3935800000000001,\"613.000,00\",CR,REF1\n" +
+		"2025-11-23,ADMIN FEE,\"2,500.00\",DB,\n" +
+		"2025-11-24," + longDescription + ",100,,\n"
+
+	rows, err := parseMutationCSV(strings.NewReader(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want 3", len(rows))
+	}
+
+	if rows[0].line != 2 || rows[0].amount != 613000 || rows[0].reference != "REF1" {
+		t.Errorf("row 1 = %+v", rows[0])
+	}
+	if rows[1].amount != -2500 {
+		t.Errorf("debit amount = %v, want -2500", rows[1].amount)
+	}
+	if n := utf8.RuneCountInString(rows[2].description); n != maxMutationDescription {
+		t.Errorf("description has %d characters, want it cut to %d", n, maxMutationDescription)
+	}
+	if !utf8.ValidString(rows[2].description) {
+		t.Error("cut description is not valid UTF-8")
+	}
+}
+
+func TestParseMutationCSVRejects(t *testing.T) {
+	cases := []struct {
+		name string
+		csv  string
+		want string
+	}{
+		{"missing column", "date,amount\n2025-11-23,100\n", `missing "description" column`},
+		{"empty file", "", "EOF"},
+		{"ambiguous amount", "date,description,amount\n2025-11-23,ok,100\n2025-11-23,bad,613.000.00\n", "line 3"},
+		{"long date", "date,description,amount\n" + strings.Repeat("9", 31) + ",x,100\n", "line 2: date"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseMutationCSV(strings.NewReader(tc.csv))
+			if !errors.Is(err, ErrInvalidMutationFile) {
+				t.Fatalf("err = %v, want ErrInvalidMutationFile", err)
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("err = %v, want it to mention %q", err, tc.want)
+			}
+		})
+	}
+}
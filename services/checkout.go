@@ -0,0 +1,247 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/emyu/ecommer-be/config"
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/payment"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+var (
+	ErrCartEmpty              = errors.New("cart is empty")
+	ErrVariantNotFound        = errors.New("product variant not found")
+	ErrInvalidQuantity        = errors.New("quantity must be at least 1")
+	ErrShippingAddressInvalid = errors.New("shipping address not found")
+	ErrPaymentMethodDisabled  = errors.New("payment method is not available")
+)
+
+// OrderLine is a requested order line before pricing. Prices are never
+// taken from the client; they are looked up when the order is placed.
+type OrderLine struct {
+	ProductVariantID string
+	Quantity         int
+	CustomName       string
+	CustomNumber     string
+}
+
+type pricedLine struct {
+	OrderLine
+	ProductID  string
+	CategoryID string
+	UnitPrice  float64
+}
+
+// CheckoutCart turns the user's cart into an order and empties the cart in
+// the same transaction.
+func CheckoutCart(userID, paymentMethod, shippingAddressID string) (string, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var cartID string
+	var couponID sql.NullString
+	err = tx.QueryRow("SELECT id, coupon_id FROM carts WHERE user_id = ? FOR UPDATE", userID).Scan(&cartID, &couponID)
+	if err == sql.ErrNoRows {
+		return "", ErrCartEmpty
+	}
+	if err != nil {
+		return "", err
+	}
+
+	lines, err := cartLines(tx, cartID)
+	if err != nil {
+		return "", err
+	}
+
+	orderID, err := placeOrder(tx, userID, paymentMethod, shippingAddressID, lines, couponID.String)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec("DELETE FROM cart_items WHERE cart_id = ?", cartID); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("UPDATE carts SET coupon_id = NULL WHERE id = ?", cartID); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return orderID, nil
+}
+
+// CreateOrder places an order for an explicit list of lines, optionally
+// discounted by a coupon code.
+func CreateOrder(userID, paymentMethod, shippingAddressID string, lines []OrderLine, couponCode string) (string, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var couponID string
+	if couponCode != "" {
+		if couponID, err = couponIDByCode(tx, couponCode); err != nil {
+			return "", err
+		}
+	}
+
+	orderID, err := placeOrder(tx, userID, paymentMethod, shippingAddressID, lines, couponID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return orderID, nil
+}
+
+// ShippingCost returns the shipping cost for an order with the given subtotal.
+func ShippingCost(subtotal float64) float64 {
+	if config.AppConfig.FreeShippingMinimum > 0 && subtotal >= config.AppConfig.FreeShippingMinimum {
+		return 0
+	}
+	return config.AppConfig.ShippingFlatRate
+}
+
+func cartLines(tx *sql.Tx, cartID string) ([]OrderLine, error) {
+	rows, err := tx.Query(`
+		SELECT product_variant_id, quantity, custom_name, custom_number
+		FROM cart_items WHERE cart_id = ? ORDER BY created_at
+	`, cartID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []OrderLine
+	for rows.Next() {
+		var line OrderLine
+		var customName, customNumber sql.NullString
+		if err := rows.Scan(&line.ProductVariantID, &line.Quantity, &customName, &customNumber); err != nil {
+			return nil, err
+		}
+		line.CustomName = customName.String
+		line.CustomNumber = customNumber.String
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+func priceLines(tx *sql.Tx, lines []OrderLine) ([]pricedLine, float64, error) {
+	if len(lines) == 0 {
+		return nil, 0, ErrCartEmpty
+	}
+
+	priced := make([]pricedLine, 0, len(lines))
+	var subtotal float64
+	for _, line := range lines {
+		if line.Quantity < 1 {
+			return nil, 0, ErrInvalidQuantity
+		}
+
+		var productID string
+		var categoryID sql.NullString
+		var unitPrice float64
+		err := tx.QueryRow(`
+			SELECT p.id, p.category_id, p.price + COALESCE(pv.price_adjustment, 0)
+			FROM product_variants pv
+			JOIN products p ON pv.product_id = p.id
+			WHERE pv.id = ?
+		`, line.ProductVariantID).Scan(&productID, &categoryID, &unitPrice)
+		if err == sql.ErrNoRows {
+			return nil, 0, fmt.Errorf("%w: %s", ErrVariantNotFound, line.ProductVariantID)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		priced = append(priced, pricedLine{OrderLine: line, ProductID: productID, CategoryID: categoryID.String, UnitPrice: unitPrice})
+		subtotal += unitPrice * float64(line.Quantity)
+	}
+
+	return priced, subtotal, nil
+}
+
+func placeOrder(tx *sql.Tx, userID, paymentMethod, shippingAddressID string, lines []OrderLine, couponID string) (string, error) {
+	if err := requireVerifiedEmail(tx, userID); err != nil {
+		return "", err
+	}
+	if err := payment.MethodAvailable(paymentMethod); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrPaymentMethodDisabled, err)
+	}
+
+	var addressOwner string
+	err := tx.QueryRow("SELECT user_id FROM shipping_addresses WHERE id = ?", shippingAddressID).Scan(&addressOwner)
+	if err == sql.ErrNoRows || (err == nil && addressOwner != userID) {
+		return "", ErrShippingAddressInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+
+	priced, subtotal, err := priceLines(tx, lines)
+	if err != nil {
+		return "", err
+	}
+
+	var discount *couponDiscount
+	if couponID != "" {
+		if discount, err = evaluateCoupon(tx, couponID, userID, priced, true); err != nil {
+			return "", err
+		}
+	}
+
+	var discountAmount float64
+	if discount != nil {
+		discountAmount = discount.Amount
+	}
+
+	shippingCost := ShippingCost(subtotal - discountAmount)
+	totalAmount := subtotal - discountAmount + shippingCost
+
+	orderID := utils.GenerateID()
+	orderNumber := utils.GenerateOrderNumber()
+
+	_, err = tx.Exec(`
+		INSERT INTO orders (id, user_id, order_number, subtotal, discount_amount, total_amount, shipping_cost, status, payment_method, shipping_address_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, orderID, userID, orderNumber, subtotal, discountAmount, totalAmount, shippingCost, OrderStatusPending, paymentMethod, shippingAddressID)
+	if err != nil {
+		return "", err
+	}
+
+	if discount != nil {
+		if err := redeemCoupon(tx, discount, userID, orderID); err != nil {
+			return "", err
+		}
+	}
+
+	if err := recordStatusChange(tx, orderID, "", OrderStatusPending, ActorCustomer, userID, "Order placed"); err != nil {
+		return "", err
+	}
+
+	for _, line := range priced {
+		_, err := tx.Exec(`
+			INSERT INTO order_items (id, order_id, product_variant_id, quantity, price, custom_name, custom_number)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, utils.GenerateID(), orderID, line.ProductVariantID, line.Quantity, line.UnitPrice, line.CustomName, line.CustomNumber)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err := reserveStock(tx, orderID, priced); err != nil {
+		return "", err
+	}
+
+	return orderID, nil
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+// Coupon discount types
+const (
+	CouponPercentage = "percentage"
+	CouponFixed      = "fixed"
+)
+
+// Coupon redemption statuses
+const (
+	RedemptionApplied  = "applied"
+	RedemptionReleased = "released"
+)
+
+var (
+	ErrCouponNotFound      = errors.New("coupon not found")
+	ErrCouponInactive      = errors.New("coupon is not active")
+	ErrCouponNotValid      = errors.New("coupon is not valid at this time")
+	ErrCouponUsageLimit    = errors.New("coupon usage limit reached")
+	ErrCouponMinSpend      = errors.New("minimum spend for coupon not reached")
+	ErrCouponNotApplicable = errors.New("coupon does not apply to any item")
+)
+
+// CouponPreview shows what a coupon would take off the current cart.
+type CouponPreview struct {
+	Code        string  `json:"code"`
+	Description string  `json:"description"`
+	Subtotal    float64 `json:"subtotal"`
+	Eligible    float64 `json:"eligible_subtotal"`
+	Discount    float64 `json:"discount"`
+}
+
+type couponDiscount struct {
+	CouponID    string
+	Code        string
+	Description string
+	Eligible    float64
+	Amount      float64
+}
+
+// NormalizeCouponCode returns the form coupon codes are stored and looked up in.
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// ApplyCouponToCart validates a coupon against the user's cart and attaches
+// it. The coupon is checked again when the cart is checked out, since the
+// cart or the coupon may change in between.
+func ApplyCouponToCart(userID, code string) (*CouponPreview, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var cartID string
+	err = tx.QueryRow("SELECT id FROM carts WHERE user_id = ? FOR UPDATE", userID).Scan(&cartID)
+	if err == sql.ErrNoRows {
+		return nil, ErrCartEmpty
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	couponID, err := couponIDByCode(tx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := cartLines(tx, cartID)
+	if err != nil {
+		return nil, err
+	}
+	priced, subtotal, err := priceLines(tx, lines)
+	if err != nil {
+		return nil, err
+	}
+
+	discount, err := evaluateCoupon(tx, couponID, userID, priced, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("UPDATE carts SET coupon_id = ? WHERE id = ?", couponID, cartID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &CouponPreview{
+		Code:        discount.Code,
+		Description: discount.Description,
+		Subtotal:    subtotal,
+		Eligible:    discount.Eligible,
+		Discount:    discount.Amount,
+	}, nil
+}
+
+// RemoveCouponFromCart detaches any coupon from the user's cart.
+func RemoveCouponFromCart(userID string) error {
+	_, err := database.DB.Exec("UPDATE carts SET coupon_id = NULL WHERE user_id = ?", userID)
+	return err
+}
+
+func couponIDByCode(tx *sql.Tx, code string) (string, error) {
+	var couponID string
+	err := tx.QueryRow("SELECT id FROM coupons WHERE code = ?", NormalizeCouponCode(code)).Scan(&couponID)
+	if err == sql.ErrNoRows {
+		return "", ErrCouponNotFound
+	}
+	return couponID, err
+}
+
+// evaluateCoupon checks every rule of a coupon against priced order lines
+// and works out the discount. With lock set the coupon row is locked, which
+// serializes redemptions so the usage caps hold under concurrent checkouts.
+func evaluateCoupon(tx *sql.Tx, couponID, userID string, lines []pricedLine, lock bool) (*couponDiscount, error) {
+	query := `
+		SELECT code, description, discount_type, discount_value, max_discount, min_spend,
+		       usage_limit, per_user_limit, used_count, is_active,
+		       (starts_at IS NULL OR starts_at <= NOW()) AND (ends_at IS NULL OR ends_at > NOW())
+		FROM coupons WHERE id = ?`
+	if lock {
+		query += " FOR UPDATE"
+	}
+
+	d := &couponDiscount{CouponID: couponID}
+	var description sql.NullString
+	var discountType string
+	var value, minSpend float64
+	var maxDiscount sql.NullFloat64
+	var usageLimit, perUserLimit sql.NullInt64
+	var usedCount int64
+	var active, inWindow bool
+	err := tx.QueryRow(query, couponID).Scan(&d.Code, &description, &discountType, &value, &maxDiscount, &minSpend,
+		&usageLimit, &perUserLimit, &usedCount, &active, &inWindow)
+	if err == sql.ErrNoRows {
+		return nil, ErrCouponNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.Description = description.String
+
+	if !active {
+		return nil, ErrCouponInactive
+	}
+	if !inWindow {
+		return nil, ErrCouponNotValid
+	}
+	if usageLimit.Valid && usedCount >= usageLimit.Int64 {
+		return nil, ErrCouponUsageLimit
+	}
+	if perUserLimit.Valid {
+		var used int64
+		err := tx.QueryRow(
+			"SELECT COUNT(*) FROM coupon_redemptions WHERE coupon_id = ? AND user_id = ? AND status = ?",
+			couponID, userID, RedemptionApplied,
+		).Scan(&used)
+		if err != nil {
+			return nil, err
+		}
+		if used >= perUserLimit.Int64 {
+			return nil, ErrCouponUsageLimit
+		}
+	}
+
+	products, err := couponTargets(tx, "SELECT product_id FROM coupon_products WHERE coupon_id = ?", couponID)
+	if err != nil {
+		return nil, err
+	}
+	categories, err := couponTargets(tx, "SELECT category_id FROM coupon_categories WHERE coupon_id = ?", couponID)
+	if err != nil {
+		return nil, err
+	}
+	restricted := len(products) > 0 || len(categories) > 0
+
+	for _, line := range lines {
+		if !restricted || products[line.ProductID] || categories[line.CategoryID] {
+			d.Eligible += line.UnitPrice * float64(line.Quantity)
+		}
+	}
+	if d.Eligible == 0 {
+		return nil, ErrCouponNotApplicable
+	}
+	if d.Eligible < minSpend {
+		return nil, fmt.Errorf("%w: spend %.2f more on eligible items", ErrCouponMinSpend, minSpend-d.Eligible)
+	}
+
+	switch discountType {
+	case CouponPercentage:
+		d.Amount = d.Eligible * value / 100
+		if maxDiscount.Valid && d.Amount > maxDiscount.Float64 {
+			d.Amount = maxDiscount.Float64
+		}
+	case CouponFixed:
+		d.Amount = value
+	}
+	d.Amount = math.Round(math.Min(d.Amount, d.Eligible)*100) / 100
+
+	return d, nil
+}
+
+func couponTargets(tx *sql.Tx, query, couponID string) (map[string]bool, error) {
+	rows, err := tx.Query(query, couponID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		targets[id] = true
+	}
+	return targets, rows.Err()
+}
+
+// redeemCoupon stores the discount as its own order line and counts the
+// redemption against the coupon's caps.
+func redeemCoupon(tx *sql.Tx, d *couponDiscount, userID, orderID string) error {
+	_, err := tx.Exec(`
+		INSERT INTO order_discounts (id, order_id, coupon_id, code, description, amount)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, utils.GenerateID(), orderID, d.CouponID, d.Code, d.Description, d.Amount)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO coupon_redemptions (id, coupon_id, user_id, order_id, amount, status)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, utils.GenerateID(), d.CouponID, userID, orderID, d.Amount, RedemptionApplied)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE coupons SET used_count = used_count + 1 WHERE id = ?", d.CouponID)
+	return err
+}
+
+// releaseCouponRedemptions gives the uses of a canceled order back to its
+// coupons. The order_discounts line is kept so the order still shows what
+// it was charged.
+func releaseCouponRedemptions(tx *sql.Tx, orderID string) error {
+	rows, err := tx.Query(`
+		SELECT id, coupon_id FROM coupon_redemptions WHERE order_id = ? AND status = ? FOR UPDATE
+	`, orderID, RedemptionApplied)
+	if err != nil {
+		return err
+	}
+
+	type redemption struct{ id, couponID string }
+	var redemptions []redemption
+	for rows.Next() {
+		var r redemption
+		if err := rows.Scan(&r.id, &r.couponID); err != nil {
+			rows.Close()
+			return err
+		}
+		redemptions = append(redemptions, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range redemptions {
+		if _, err := tx.Exec("UPDATE coupon_redemptions SET status = ? WHERE id = ?", RedemptionReleased, r.id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE coupons SET used_count = GREATEST(used_count - 1, 0) WHERE id = ?", r.couponID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
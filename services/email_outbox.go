@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/emyu/ecommer-be/config"
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/mailer"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+// Outbox statuses stored in email_outbox.status
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSent    = "sent"
+	OutboxStatusFailed  = "failed"
+)
+
+const outboxBatchSize = 50
+
+// enqueueEmail queues a message in the caller's transaction, so it is only
+// sent if the change that triggered it is committed.
+func enqueueEmail(tx *sql.Tx, msg mailer.Message) error {
+	_, err := tx.Exec(`
+		INSERT INTO email_outbox (id, to_email, subject, body, status)
+		VALUES (?, ?, ?, ?, ?)
+	`, utils.GenerateID(), msg.To, msg.Subject, msg.Body, OutboxStatusPending)
+	return err
+}
+
+// StartOutboxWorker runs DeliverOutbox on every tick until ctx is canceled.
+// Replicas share a lease so only one of them sends at a time.
+func StartOutboxWorker(ctx context.Context) {
+	interval := config.AppConfig.OutboxInterval
+	if interval <= 0 {
+		log.Println("Email outbox worker disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ok, err := acquireLease("email_outbox", 2*interval)
+			if err != nil {
+				log.Printf("email outbox: lease: %v", err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			if err := DeliverOutbox(ctx); err != nil {
+				log.Printf("email outbox: %v", err)
+			}
+		}
+	}
+}
+
+// DeliverOutbox sends queued mail that is due. A failed message is retried
+// with a growing delay until OUTBOX_MAX_ATTEMPTS, then marked failed.
+func DeliverOutbox(ctx context.Context) error {
+	ids, err := queryIDs(`
+		SELECT id FROM email_outbox
+		WHERE status = ? AND next_attempt_at <= NOW()
+		ORDER BY created_at LIMIT ?
+	`, OutboxStatusPending, outboxBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := deliverEmail(id); err != nil {
+			log.Printf("email outbox: message %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+func deliverEmail(id string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var msg mailer.Message
+	var attempts int
+	err = tx.QueryRow(`
+		SELECT to_email, subject, body, attempts FROM email_outbox WHERE id = ? AND status = ? FOR UPDATE
+	`, id, OutboxStatusPending).Scan(&msg.To, &msg.Subject, &msg.Body, &attempts)
+	if err == sql.ErrNoRows {
+		return nil // sent by an overlapping sweep
+	}
+	if err != nil {
+		return err
+	}
+	attempts++
+
+	if sendErr := mailer.Send(msg); sendErr != nil {
+		status := OutboxStatusPending
+		if attempts >= config.AppConfig.OutboxMaxAttempts {
+			status = OutboxStatusFailed
+		}
+		// Back off 1, 4, 9, ... minutes between tries.
+		_, err = tx.Exec(`
+			UPDATE email_outbox
+			SET status = ?, attempts = ?, last_error = ?, next_attempt_at = NOW() + INTERVAL ? MINUTE
+			WHERE id = ?
+		`, status, attempts, sendErr.Error(), attempts*attempts, id)
+		if err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return sendErr
+	}
+
+	_, err = tx.Exec(`
+		UPDATE email_outbox SET status = ?, attempts = ?, last_error = NULL, sent_at = NOW() WHERE id = ?
+	`, OutboxStatusSent, attempts, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
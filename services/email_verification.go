@@ -0,0 +1,219 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/emyu/ecommer-be/config"
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/mailer"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired email verification token")
+	ErrEmailAlreadyVerified     = errors.New("email is already verified")
+	ErrVerificationCooldown     = errors.New("a verification email was sent recently, please wait before requesting another")
+	ErrEmailNotVerified         = errors.New("email address must be verified first")
+	ErrEmailTaken               = errors.New("email already registered")
+)
+
+// SendVerificationEmail issues a new token confirming that the user owns
+// email and queues the email in tx. Earlier tokens stop working.
+func SendVerificationEmail(tx *sql.Tx, userID, name, email string) error {
+	if _, err := tx.Exec(
+		"UPDATE email_verification_tokens SET used_at = NOW() WHERE user_id = ? AND used_at IS NULL", userID,
+	); err != nil {
+		return err
+	}
+
+	token := utils.GenerateSecureToken(32)
+	ttl := config.AppConfig.EmailVerificationTTL
+	_, err := tx.Exec(`
+		INSERT INTO email_verification_tokens (id, user_id, email, token_hash, expires_at)
+		VALUES (?, ?, ?, ?, NOW() + INTERVAL ? SECOND)
+	`, utils.GenerateID(), userID, email, utils.HashToken(token), int(ttl.Seconds()))
+	if err != nil {
+		return err
+	}
+
+	return enqueueEmail(tx, verificationEmail(email, name, token))
+}
+
+// ResendVerificationEmail sends a fresh verification link, at most once per
+// VERIFICATION_RESEND_COOLDOWN. During an email change the link goes to the
+// new address.
+func ResendVerificationEmail(userID string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var name, email string
+	var pendingEmail sql.NullString
+	var verifiedAt sql.NullTime
+	err = tx.QueryRow(
+		"SELECT name, email, pending_email, email_verified_at FROM users WHERE id = ? FOR UPDATE", userID,
+	).Scan(&name, &email, &pendingEmail, &verifiedAt)
+	if err != nil {
+		return err
+	}
+	if pendingEmail.Valid {
+		email = pendingEmail.String
+	} else if verifiedAt.Valid {
+		return ErrEmailAlreadyVerified
+	}
+
+	var recent bool
+	err = tx.QueryRow(`
+		SELECT COUNT(*) > 0 FROM email_verification_tokens
+		WHERE user_id = ? AND created_at > NOW() - INTERVAL ? SECOND
+	`, userID, int(config.AppConfig.VerificationResendCooldown.Seconds())).Scan(&recent)
+	if err != nil {
+		return err
+	}
+	if recent {
+		return ErrVerificationCooldown
+	}
+
+	if err := SendVerificationEmail(tx, userID, name, email); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// VerifyEmail confirms the address a verification token was sent to. The
+// token works once. If that address is the user's pending email, it
+// replaces the login email, every session is revoked and the old address
+// is told about the change.
+func VerifyEmail(token string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID, email string
+	var expired bool
+	var usedAt sql.NullTime
+	err = tx.QueryRow(`
+		SELECT user_id, email, expires_at < NOW(), used_at FROM email_verification_tokens WHERE token_hash = ? FOR UPDATE
+	`, utils.HashToken(token)).Scan(&userID, &email, &expired, &usedAt)
+	if err == sql.ErrNoRows {
+		return ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return err
+	}
+	if expired || usedAt.Valid {
+		return ErrInvalidVerificationToken
+	}
+
+	var name, currentEmail string
+	if err := tx.QueryRow(
+		"SELECT name, email FROM users WHERE id = ? FOR UPDATE", userID,
+	).Scan(&name, &currentEmail); err != nil {
+		return err
+	}
+
+	changed := email != currentEmail
+	if changed {
+		// The address may have been registered since the change was requested.
+		var taken bool
+		if err := tx.QueryRow(
+			"SELECT COUNT(*) > 0 FROM users WHERE email = ? AND id <> ?", email, userID,
+		).Scan(&taken); err != nil {
+			return err
+		}
+		if taken {
+			return ErrEmailTaken
+		}
+
+		if _, err := tx.Exec(`
+			UPDATE users SET email = ?, pending_email = NULL, email_verified_at = NOW() WHERE id = ?
+		`, email, userID); err != nil {
+			if isDuplicateKey(err) {
+				return ErrEmailTaken
+			}
+			return err
+		}
+		if err := revokeUserSessions(tx, userID, "email changed"); err != nil {
+			return err
+		}
+		if err := enqueueEmail(tx, emailChangedNotice(currentEmail, name, email)); err != nil {
+			return err
+		}
+	} else if _, err := tx.Exec(
+		"UPDATE users SET email_verified_at = NOW() WHERE id = ? AND email_verified_at IS NULL", userID,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE email_verification_tokens SET used_at = NOW() WHERE user_id = ? AND used_at IS NULL", userID,
+	); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if changed {
+		InvalidateUser(userID)
+	}
+	return nil
+}
+
+// requireVerifiedEmail returns ErrEmailNotVerified when REQUIRE_VERIFIED_EMAIL
+// is on and the user has not verified their address.
+func requireVerifiedEmail(tx *sql.Tx, userID string) error {
+	if !config.AppConfig.RequireVerifiedEmail {
+		return nil
+	}
+
+	var verified bool
+	err := tx.QueryRow("SELECT email_verified_at IS NOT NULL FROM users WHERE id = ?", userID).Scan(&verified)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+func verificationEmail(to, name, token string) mailer.Message {
+	cfg := config.AppConfig
+	link := cfg.EmailVerificationURL + "?token=" + url.QueryEscape(token)
+	return mailer.Message{
+		To:      to,
+		Subject: "Verify your " + cfg.AppName + " email address",
+		Body: fmt.Sprintf(`Hi %s,
+
+Please confirm your email address by opening the link below:
+
+%s
+
+The link expires in %s. If you did not create an account, you can ignore
+this email.
+`, name, link, cfg.EmailVerificationTTL),
+	}
+}
+
+func emailChangedNotice(to, name, newEmail string) mailer.Message {
+	cfg := config.AppConfig
+	return mailer.Message{
+		To:      to,
+		Subject: "Your " + cfg.AppName + " email address was changed",
+		Body: fmt.Sprintf(`Hi %s,
+
+The email address of your account was changed to %s. You have been logged
+out everywhere and must log in with the new address.
+
+If you did not make this change, reset your password and contact support.
+`, name, newEmail),
+	}
+}
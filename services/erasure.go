@@ -0,0 +1,319 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+// Erasure request statuses stored in erasure_requests.status
+const (
+	ErasureStatusPending   = "pending"
+	ErasureStatusCompleted = "completed"
+	ErasureStatusRejected  = "rejected"
+	ErasureStatusCanceled  = "canceled"
+)
+
+var (
+	ErrErasureRequestNotFound = errors.New("erasure request not found")
+	ErrErasureRequestPending  = errors.New("an erasure request is already pending")
+	ErrErasureRequestFinished = errors.New("erasure request is no longer pending")
+	ErrErasureBlocked         = errors.New("account cannot be erased yet")
+	ErrUserErased             = errors.New("account has already been erased")
+	ErrCannotEraseSelf        = errors.New("you cannot erase your own account here, request erasure instead")
+)
+
+// openOrderStatuses are orders that are still being paid for or delivered.
+var openOrderStatuses = []interface{}{OrderStatusPending, OrderStatusPaid, OrderStatusPacked, OrderStatusShipped}
+
+// RequestErasure records a user's request to erase their account. An admin
+// carries it out with ProcessErasureRequest. The password check is
+// throttled like Login.
+func RequestErasure(userID, password, reason, ipAddress, userAgent string) (string, error) {
+	verified, err := verifyCurrentPassword(userID, password, ipAddress, userAgent)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var hashed string
+	err = tx.QueryRow("SELECT password FROM users WHERE id = ? FOR UPDATE", userID).Scan(&hashed)
+	if err == sql.ErrNoRows {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if hashed != verified {
+		return "", ErrWrongPassword
+	}
+
+	var pending bool
+	if err := tx.QueryRow(
+		"SELECT COUNT(*) > 0 FROM erasure_requests WHERE user_id = ? AND status = ?", userID, ErasureStatusPending,
+	).Scan(&pending); err != nil {
+		return "", err
+	}
+	if pending {
+		return "", ErrErasureRequestPending
+	}
+
+	id := utils.GenerateID()
+	_, err = tx.Exec(`
+		INSERT INTO erasure_requests (id, user_id, status, reason, requested_by) VALUES (?, ?, ?, ?, ?)
+	`, id, userID, ErasureStatusPending, reason, userID)
+	if err != nil {
+		return "", err
+	}
+	return id, tx.Commit()
+}
+
+// CancelErasureRequest withdraws the user's pending request.
+func CancelErasureRequest(userID string) error {
+	res, err := database.DB.Exec(`
+		UPDATE erasure_requests SET status = ?, processed_at = NOW(), processed_by = ?
+		WHERE user_id = ? AND status = ?
+	`, ErasureStatusCanceled, userID, userID, ErasureStatusPending)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrErasureRequestNotFound
+	}
+	return nil
+}
+
+// ProcessErasureRequest erases the account of a pending request.
+func ProcessErasureRequest(requestID, actorID string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	userID, err := lockPendingErasure(tx, requestID)
+	if err != nil {
+		return err
+	}
+	if err := eraseUser(tx, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		UPDATE erasure_requests SET status = ?, processed_at = NOW(), processed_by = ? WHERE id = ?
+	`, ErasureStatusCompleted, actorID, requestID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	InvalidateUser(userID)
+	return nil
+}
+
+// RejectErasureRequest declines a pending request, e.g. while a legal hold
+// applies. The reason is shown to the user.
+func RejectErasureRequest(requestID, actorID, reason string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockPendingErasure(tx, requestID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		UPDATE erasure_requests SET status = ?, processed_at = NOW(), processed_by = ?, rejection_reason = ? WHERE id = ?
+	`, ErasureStatusRejected, actorID, reason, requestID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// EraseUser erases an account at once, on an admin's initiative, and
+// records it as a completed erasure request.
+func EraseUser(userID, actorID, reason string) error {
+	if userID == actorID {
+		return ErrCannotEraseSelf
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := eraseUser(tx, userID); err != nil {
+		return err
+	}
+	// Any request of the user is settled by this erasure.
+	if _, err := tx.Exec(`
+		UPDATE erasure_requests SET status = ?, processed_at = NOW(), processed_by = ?
+		WHERE user_id = ? AND status = ?
+	`, ErasureStatusCompleted, actorID, userID, ErasureStatusPending); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO erasure_requests (id, user_id, status, reason, requested_by, processed_by, processed_at)
+		VALUES (?, ?, ?, ?, ?, ?, NOW())
+	`, utils.GenerateID(), userID, ErasureStatusCompleted, reason, actorID, actorID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	InvalidateUser(userID)
+	return nil
+}
+
+// checkErasable returns ErrErasureBlocked, wrapped with the cause, while
+// the user has open orders, as buyer or seller, or the platform still owes
+// them money as a seller.
+func checkErasable(tx *sql.Tx, userID string) error {
+	var openOrders int
+	args := append([]interface{}{userID}, openOrderStatuses...)
+	if err := tx.QueryRow(
+		"SELECT COUNT(*) FROM orders WHERE user_id = ? AND status IN (?, ?, ?, ?)", args...,
+	).Scan(&openOrders); err != nil {
+		return err
+	}
+	if openOrders > 0 {
+		return fmt.Errorf("%w: %d open orders must be delivered or canceled first", ErrErasureBlocked, openOrders)
+	}
+
+	var openSales int
+	if err := tx.QueryRow(`
+		SELECT COUNT(DISTINCT o.id) FROM orders o
+		JOIN order_items oi ON oi.order_id = o.id
+		JOIN product_variants pv ON pv.id = oi.product_variant_id
+		JOIN products p ON p.id = pv.product_id
+		WHERE p.seller_id = ? AND o.status IN (?, ?, ?, ?)
+	`, args...).Scan(&openSales); err != nil {
+		return err
+	}
+	if openSales > 0 {
+		return fmt.Errorf("%w: %d open orders for the seller's products must be delivered or canceled first", ErrErasureBlocked, openSales)
+	}
+
+	var balance float64
+	err := tx.QueryRow("SELECT balance FROM seller_balances WHERE seller_id = ?", userID).Scan(&balance)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if balance != 0 {
+		return fmt.Errorf("%w: seller balance of %.2f must be paid out first", ErrErasureBlocked, balance)
+	}
+	return nil
+}
+
+func lockPendingErasure(tx *sql.Tx, requestID string) (string, error) {
+	var userID, status string
+	err := tx.QueryRow(
+		"SELECT user_id, status FROM erasure_requests WHERE id = ? FOR UPDATE", requestID,
+	).Scan(&userID, &status)
+	if err == sql.ErrNoRows {
+		return "", ErrErasureRequestNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if status != ErasureStatusPending {
+		return "", ErrErasureRequestFinished
+	}
+	return userID, nil
+}
+
+// eraseUser removes or anonymises the personal data of a user. Orders,
+// payments, refunds, coupon redemptions and seller ledgers are kept for
+// accounting, but no longer point to an identifiable person: the user row
+// stays as an anonymous placeholder and the shipping addresses lose the
+// street, postal code and phone number.
+func eraseUser(tx *sql.Tx, userID string) error {
+	var email string
+	var pendingEmail sql.NullString
+	var erasedAt sql.NullTime
+	err := tx.QueryRow(
+		"SELECT email, pending_email, erased_at FROM users WHERE id = ? FOR UPDATE", userID,
+	).Scan(&email, &pendingEmail, &erasedAt)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if erasedAt.Valid {
+		return ErrUserErased
+	}
+
+	if err := checkErasable(tx, userID); err != nil {
+		return err
+	}
+	// An erased account loses its role for good, so it must not be the
+	// last one able to manage roles.
+	if err := ensureUserRoleManagerRemains(tx, userID, 0); err != nil {
+		return err
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		// Login data and credentials
+		{"DELETE FROM sessions WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM login_history WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM password_reset_tokens WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM email_verification_tokens WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM two_factor_recovery_codes WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM email_outbox WHERE to_email IN (?, ?)", []interface{}{email, pendingEmail.String}},
+		// Content and shopping state
+		{"DELETE FROM reviews WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM carts WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM seller_payout_accounts WHERE seller_id = ?", []interface{}{userID}},
+		// Products stay listed as the platform's own, so later sales are not
+		// credited to a seller who can no longer be paid.
+		{"UPDATE products SET seller_id = NULL WHERE seller_id = ?", []interface{}{userID}},
+		// Addresses still referenced by orders are kept, minus what
+		// identifies the person; the rest are removed.
+		{`DELETE FROM shipping_addresses WHERE user_id = ?
+			AND id NOT IN (SELECT shipping_address_id FROM orders WHERE user_id = ? AND shipping_address_id IS NOT NULL)`,
+			[]interface{}{userID, userID}},
+		{"UPDATE shipping_addresses SET address = '[erased]', postal_code = '', phone = '' WHERE user_id = ?", []interface{}{userID}},
+		// Names printed on ordered items and gateway payloads may identify the buyer.
+		{`UPDATE order_items SET custom_name = NULL
+			WHERE custom_name IS NOT NULL AND order_id IN (SELECT id FROM orders WHERE user_id = ?)`,
+			[]interface{}{userID}},
+		{`UPDATE payment_notifications SET payload = NULL
+			WHERE payment_id IN (SELECT p.id FROM payments p JOIN orders o ON o.id = p.order_id WHERE o.user_id = ?)`,
+			[]interface{}{userID}},
+		// The account itself becomes an unusable placeholder.
+		{`UPDATE users SET
+			name = 'Deleted User', email = ?, phone = NULL, password = '', pending_email = NULL,
+			email_verified_at = NULL, totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL,
+			failed_login_count = 0, locked_until = NULL,
+			is_active = FALSE, deactivated_at = NOW(), deactivation_reason = 'erased', erased_at = NOW()
+			WHERE id = ?`,
+			[]interface{}{erasedEmail(userID), userID}},
+	}
+
+	for _, s := range statements {
+		if _, err := tx.Exec(s.query, s.args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// erasedEmail keeps users.email unique without pointing to a real mailbox.
+func erasedEmail(userID string) string {
+	return "erased-" + userID + "@erased.invalid"
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+// Stock reservation statuses
+const (
+	ReservationReserved  = "reserved"
+	ReservationCommitted = "committed"
+	ReservationReleased  = "released"
+	ReservationReturned  = "returned"
+)
+
+// Stock movement types
+const (
+	MovementAdjustment = "adjustment"
+	MovementReserve    = "reserve"
+	MovementCommit     = "commit"
+	MovementRelease    = "release"
+	MovementReturn     = "return"
+)
+
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+type stockChange struct {
+	variantID     string
+	stockDelta    int
+	reservedDelta int
+	movementType  string
+	orderID       string
+	actorID       string
+	reason        string
+}
+
+// reserveStock holds stock for every line of an order. The conditional
+// UPDATE makes concurrent reservations for the same variant safe: only one
+// of them can take the last available units. Variants are locked in ID
+// order so that two orders never wait on each other.
+func reserveStock(tx *sql.Tx, orderID string, lines []pricedLine) error {
+	quantities := make(map[string]int)
+	for _, line := range lines {
+		quantities[line.ProductVariantID] += line.Quantity
+	}
+
+	variantIDs := make([]string, 0, len(quantities))
+	for id := range quantities {
+		variantIDs = append(variantIDs, id)
+	}
+	sort.Strings(variantIDs)
+
+	for _, variantID := range variantIDs {
+		qty := quantities[variantID]
+		res, err := tx.Exec(`
+			UPDATE product_variants SET reserved = reserved + ?
+			WHERE id = ? AND stock - reserved >= ?
+		`, qty, variantID, qty)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("%w: %s", ErrInsufficientStock, variantID)
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO stock_reservations (id, order_id, product_variant_id, quantity, status)
+			VALUES (?, ?, ?, ?, ?)
+		`, utils.GenerateID(), orderID, variantID, qty, ReservationReserved)
+		if err != nil {
+			return err
+		}
+
+		err = recordStockMovement(tx, stockChange{
+			variantID:     variantID,
+			reservedDelta: qty,
+			movementType:  MovementReserve,
+			orderID:       orderID,
+			reason:        "Reserved for order",
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commitReservations turns an order's reserved stock into sold stock.
+func commitReservations(tx *sql.Tx, orderID string) error {
+	return settleReservations(tx, orderID, ReservationReserved, ReservationCommitted, func(qty int) stockChange {
+		return stockChange{stockDelta: -qty, reservedDelta: -qty, movementType: MovementCommit, reason: "Payment received"}
+	})
+}
+
+// releaseReservations gives an unpaid order's reserved stock back.
+func releaseReservations(tx *sql.Tx, orderID, reason string) error {
+	return settleReservations(tx, orderID, ReservationReserved, ReservationReleased, func(qty int) stockChange {
+		return stockChange{reservedDelta: -qty, movementType: MovementRelease, reason: reason}
+	})
+}
+
+// returnCommittedStock puts sold stock back on hand when a paid order is
+// canceled before it ships.
+func returnCommittedStock(tx *sql.Tx, orderID, reason string) error {
+	return settleReservations(tx, orderID, ReservationCommitted, ReservationReturned, func(qty int) stockChange {
+		return stockChange{stockDelta: qty, movementType: MovementReturn, reason: reason}
+	})
+}
+
+func settleReservations(tx *sql.Tx, orderID, fromStatus, toStatus string, change func(qty int) stockChange) error {
+	rows, err := tx.Query(`
+		SELECT id, product_variant_id, quantity FROM stock_reservations
+		WHERE order_id = ? AND status = ?
+		ORDER BY product_variant_id
+		FOR UPDATE
+	`, orderID, fromStatus)
+	if err != nil {
+		return err
+	}
+
+	type reservation struct {
+		id        string
+		variantID string
+		quantity  int
+	}
+	var reservations []reservation
+	for rows.Next() {
+		var r reservation
+		if err := rows.Scan(&r.id, &r.variantID, &r.quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		reservations = append(reservations, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range reservations {
+		sc := change(r.quantity)
+		sc.variantID = r.variantID
+		sc.orderID = orderID
+
+		_, err := tx.Exec(`
+			UPDATE product_variants SET stock = stock + ?, reserved = reserved + ? WHERE id = ?
+		`, sc.stockDelta, sc.reservedDelta, r.variantID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("UPDATE stock_reservations SET status = ? WHERE id = ?", toStatus, r.id); err != nil {
+			return err
+		}
+
+		if err := recordStockMovement(tx, sc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AdjustStock changes the on-hand quantity of a variant by delta. Stock can
+// never drop below what is currently reserved for open orders.
+func AdjustStock(variantID string, delta int, actorID, reason string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var stock, reserved int
+	err = tx.QueryRow("SELECT stock, reserved FROM product_variants WHERE id = ? FOR UPDATE", variantID).Scan(&stock, &reserved)
+	if err == sql.ErrNoRows {
+		return ErrVariantNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if stock+delta < reserved {
+		return fmt.Errorf("%w: %d on hand, %d reserved", ErrInsufficientStock, stock, reserved)
+	}
+
+	if _, err := tx.Exec("UPDATE product_variants SET stock = stock + ? WHERE id = ?", delta, variantID); err != nil {
+		return err
+	}
+
+	err = recordStockMovement(tx, stockChange{
+		variantID:    variantID,
+		stockDelta:   delta,
+		movementType: MovementAdjustment,
+		actorID:      actorID,
+		reason:       reason,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func recordStockMovement(tx *sql.Tx, sc stockChange) error {
+	var stockAfter, reservedAfter int
+	err := tx.QueryRow("SELECT stock, reserved FROM product_variants WHERE id = ?", sc.variantID).Scan(&stockAfter, &reservedAfter)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO stock_movements (id, product_variant_id, movement_type, stock_delta, reserved_delta, stock_after, reserved_after, order_id, actor_id, reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, utils.GenerateID(), sc.variantID, sc.movementType, sc.stockDelta, sc.reservedDelta, stockAfter, reservedAfter,
+		nullString(sc.orderID), nullString(sc.actorID), sc.reason)
+	return err
+}
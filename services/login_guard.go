@@ -0,0 +1,251 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/emyu/ecommer-be/config"
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+// Reasons recorded in login_history.failure_reason
+const (
+	LoginFailureBadPassword  = "invalid_credentials"
+	LoginFailureUnknownEmail = "unknown_email"
+	LoginFailureBad2FACode   = "invalid_2fa_code"
+	LoginFailureThrottled    = "throttled"
+	LoginFailureInactive     = "account_inactive"
+	// A wrong current password given to confirm a change while logged in
+	LoginFailureBadReauth = "invalid_current_password"
+)
+
+// maxDelayDoublings caps the progressive delay at LOGIN_DELAY_BASE * 1024.
+const maxDelayDoublings = 10
+
+// LoginThrottledError means the attempt was refused without checking the
+// password. Locked is true for a lockout after LOGIN_MAX_FAILURES, false
+// for the short delays before it or for the per-IP limit.
+type LoginThrottledError struct {
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("account is temporarily locked, try again in %s", e.RetryAfter)
+	}
+	return fmt.Sprintf("too many login attempts, try again in %s", e.RetryAfter)
+}
+
+// CheckLoginAllowed refuses a login attempt when the IP address has too many
+// recent failures or the account is in a delay or lockout window. userID
+// may be empty for unknown addresses.
+func CheckLoginAllowed(userID, ipAddress string) error {
+	cfg := config.AppConfig
+
+	if cfg.LoginIPMaxFailures > 0 {
+		var failures int
+		var oldest int
+		err := database.DB.QueryRow(`
+			SELECT COUNT(*), COALESCE(MIN(TIMESTAMPDIFF(SECOND, NOW(), created_at + INTERVAL ? SECOND)), 0)
+			FROM login_history
+			WHERE ip_address = ? AND success = FALSE AND failure_reason <> ?
+			  AND created_at > NOW() - INTERVAL ? SECOND
+		`, int(cfg.LoginIPWindow.Seconds()), ipAddress, LoginFailureThrottled, int(cfg.LoginIPWindow.Seconds())).Scan(&failures, &oldest)
+		if err != nil {
+			return err
+		}
+		if failures >= cfg.LoginIPMaxFailures {
+			return &LoginThrottledError{RetryAfter: retryAfter(oldest)}
+		}
+	}
+
+	if userID == "" {
+		return nil
+	}
+
+	var remaining, failures int
+	err := database.DB.QueryRow(`
+		SELECT COALESCE(TIMESTAMPDIFF(SECOND, NOW(), locked_until), 0), failed_login_count
+		FROM users WHERE id = ?
+	`, userID).Scan(&remaining, &failures)
+	if err != nil {
+		return err
+	}
+	if remaining > 0 {
+		return &LoginThrottledError{
+			Locked:     cfg.LoginMaxFailures > 0 && failures >= cfg.LoginMaxFailures,
+			RetryAfter: retryAfter(remaining),
+		}
+	}
+	return nil
+}
+
+// RecordLoginFailure stores a failed attempt and, for a known user, counts
+// it: each failure blocks the account for LOGIN_DELAY_BASE doubled per
+// earlier failure, and LOGIN_MAX_FAILURES in a row lock it for
+// LOGIN_LOCKOUT_DURATION.
+func RecordLoginFailure(userID, email, ipAddress, userAgent, reason string) error {
+	cfg := config.AppConfig
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertLoginHistory(tx, userID, email, ipAddress, userAgent, false, reason); err != nil {
+		return err
+	}
+
+	// Refused attempts and correct passwords of deactivated accounts are
+	// not guesses, so they do not count towards a lockout.
+	if userID != "" && reason != LoginFailureThrottled && reason != LoginFailureInactive {
+		var failures int
+		err := tx.QueryRow("SELECT failed_login_count FROM users WHERE id = ? FOR UPDATE", userID).Scan(&failures)
+		if err != nil {
+			return err
+		}
+		failures++
+
+		var block time.Duration
+		if cfg.LoginMaxFailures > 0 && failures >= cfg.LoginMaxFailures {
+			block = cfg.LoginLockoutDuration
+		} else if cfg.LoginDelayBase > 0 && failures > 1 {
+			block = cfg.LoginDelayBase << min(failures-2, maxDelayDoublings)
+		}
+
+		if block > 0 {
+			_, err = tx.Exec(`
+				UPDATE users SET failed_login_count = ?, locked_until = NOW() + INTERVAL ? SECOND WHERE id = ?
+			`, failures, int(block.Seconds()), userID)
+		} else {
+			_, err = tx.Exec("UPDATE users SET failed_login_count = ? WHERE id = ?", failures, userID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RecordLoginSuccess stores a successful login and clears the failure count.
+func RecordLoginSuccess(userID, email, ipAddress, userAgent string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertLoginHistory(tx, userID, email, ipAddress, userAgent, true, ""); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		"UPDATE users SET failed_login_count = 0, locked_until = NULL WHERE id = ?", userID,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// verifyCurrentPassword checks the password a logged-in user enters to
+// confirm a sensitive change. It is throttled like Login, so a stolen
+// access token cannot be used to guess the password: the attempt is
+// refused with *LoginThrottledError while the account or IP address is
+// blocked, and a wrong password counts towards the lockout. It returns the
+// verified hash; callers compare it with the row they lock afterwards so a
+// concurrent password change is not overwritten.
+func verifyCurrentPassword(userID, password, ipAddress, userAgent string) (string, error) {
+	if err := checkReauthAllowed(userID, ipAddress, userAgent); err != nil {
+		return "", err
+	}
+
+	var hashed string
+	err := database.DB.QueryRow("SELECT password FROM users WHERE id = ?", userID).Scan(&hashed)
+	if err == sql.ErrNoRows {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if !utils.ComparePassword(hashed, password) {
+		if err := RecordLoginFailure(userID, "", ipAddress, userAgent, LoginFailureBadReauth); err != nil {
+			return "", err
+		}
+		return "", ErrWrongPassword
+	}
+	return hashed, nil
+}
+
+// verifySecondFactorThrottled runs verify, which checks a 2FA code in a
+// transaction of its own, with the throttling of the 2FA login step: it
+// is refused while the account or IP address is blocked, and an invalid
+// code counts towards the lockout. This keeps a hijacked session from
+// guessing codes to turn 2FA off or mint recovery codes.
+func verifySecondFactorThrottled(userID, ipAddress, userAgent string, verify func() error) error {
+	if err := checkReauthAllowed(userID, ipAddress, userAgent); err != nil {
+		return err
+	}
+	err := verify()
+	if errors.Is(err, ErrInvalidTwoFactorCode) {
+		if rerr := RecordLoginFailure(userID, "", ipAddress, userAgent, LoginFailureBad2FACode); rerr != nil {
+			return rerr
+		}
+	}
+	return err
+}
+
+// checkReauthAllowed is CheckLoginAllowed for a logged-in user confirming
+// a change; refused attempts are recorded like refused logins.
+func checkReauthAllowed(userID, ipAddress, userAgent string) error {
+	err := CheckLoginAllowed(userID, ipAddress)
+	var throttled *LoginThrottledError
+	if errors.As(err, &throttled) {
+		if rerr := RecordLoginFailure(userID, "", ipAddress, userAgent, LoginFailureThrottled); rerr != nil {
+			return rerr
+		}
+	}
+	return err
+}
+
+// UnlockAccount clears a lockout and the failure count of a user.
+func UnlockAccount(userID string) error {
+	res, err := database.DB.Exec(
+		"UPDATE users SET failed_login_count = 0, locked_until = NULL WHERE id = ?", userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists bool
+		if err := database.DB.QueryRow("SELECT COUNT(*) > 0 FROM users WHERE id = ?", userID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrUserNotFound
+		}
+	}
+	return nil
+}
+
+// insertLoginHistory falls back to the user's current email when the
+// attempt did not carry one, as in the second step of a 2FA login.
+func insertLoginHistory(tx execer, userID, email, ipAddress, userAgent string, success bool, reason string) error {
+	_, err := tx.Exec(`
+		INSERT INTO login_history (id, user_id, email, ip_address, user_agent, success, failure_reason)
+		VALUES (?, ?, COALESCE(?, (SELECT email FROM users WHERE id = ?)), ?, ?, ?, ?)
+	`, utils.GenerateID(), nullString(userID), nullString(truncate(email, 100)), userID, ipAddress,
+		truncate(userAgent, 255), success, nullString(reason))
+	return err
+}
+
+func retryAfter(seconds int) time.Duration {
+	if seconds < 1 {
+		seconds = 1
+	}
+	return time.Duration(seconds) * time.Second
+}
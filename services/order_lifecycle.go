@@ -0,0 +1,189 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+// Order statuses
+const (
+	OrderStatusPending   = "pending"
+	OrderStatusPaid      = "paid"
+	OrderStatusPacked    = "packed"
+	OrderStatusShipped   = "shipped"
+	OrderStatusDelivered = "delivered"
+	OrderStatusCanceled  = "canceled"
+	OrderStatusRefunded  = "refunded"
+)
+
+// Actor identifies who triggers an order status change.
+type Actor string
+
+const (
+	ActorAdmin    Actor = "admin"
+	ActorPayment  Actor = "payment"
+	ActorCustomer Actor = "customer"
+	ActorSystem   Actor = "system"
+)
+
+var (
+	ErrOrderNotFound        = errors.New("order not found")
+	ErrInvalidTransition    = errors.New("invalid order status transition")
+	ErrTransitionNotAllowed = errors.New("actor may not perform this order status transition")
+)
+
+// orderTransitions lists, for each status, the statuses it may move to and
+// the actors allowed to trigger that move.
+var orderTransitions = map[string]map[string][]Actor{
+	OrderStatusPending: {
+		OrderStatusPaid:     {ActorPayment, ActorAdmin},
+		OrderStatusCanceled: {ActorCustomer, ActorAdmin, ActorPayment, ActorSystem},
+	},
+	OrderStatusPaid: {
+		OrderStatusPacked:   {ActorAdmin},
+		OrderStatusCanceled: {ActorAdmin},
+		OrderStatusRefunded: {ActorAdmin, ActorPayment},
+	},
+	OrderStatusPacked: {
+		OrderStatusShipped:  {ActorAdmin},
+		OrderStatusCanceled: {ActorAdmin},
+		OrderStatusRefunded: {ActorAdmin, ActorPayment},
+	},
+	OrderStatusShipped: {
+		OrderStatusDelivered: {ActorAdmin},
+		OrderStatusRefunded:  {ActorAdmin, ActorPayment},
+	},
+	OrderStatusDelivered: {
+		OrderStatusRefunded: {ActorAdmin, ActorPayment},
+	},
+	OrderStatusCanceled: {
+		OrderStatusRefunded: {ActorAdmin, ActorPayment},
+	},
+}
+
+// CanTransition reports whether actor may move an order from one status to another.
+func CanTransition(from, to string, actor Actor) error {
+	actors, ok := orderTransitions[from][to]
+	if !ok {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+	}
+	for _, a := range actors {
+		if a == actor {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s cannot move %s -> %s", ErrTransitionNotAllowed, actor, from, to)
+}
+
+// ChangeOrderStatus moves an order to a new status in its own transaction.
+func ChangeOrderStatus(orderID, to string, actor Actor, actorID, note string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := TransitionOrder(tx, orderID, to, actor, actorID, note); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CancelOrderByCustomer cancels an order on behalf of the customer who owns it.
+// Orders owned by someone else are reported as not found.
+func CancelOrderByCustomer(orderID, userID, note string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var ownerID string
+	err = tx.QueryRow("SELECT user_id FROM orders WHERE id = ? FOR UPDATE", orderID).Scan(&ownerID)
+	if err == sql.ErrNoRows || (err == nil && ownerID != userID) {
+		return ErrOrderNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := TransitionOrder(tx, orderID, OrderStatusCanceled, ActorCustomer, userID, note); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// TransitionOrder locks the order row, validates the transition and records
+// it in order_status_history. Canceling an order also gives its coupon
+// uses back; delivering or refunding it updates the sellers' balances.
+func TransitionOrder(tx *sql.Tx, orderID, to string, actor Actor, actorID, note string) error {
+	var from string
+	err := tx.QueryRow("SELECT status FROM orders WHERE id = ? FOR UPDATE", orderID).Scan(&from)
+	if err == sql.ErrNoRows {
+		return ErrOrderNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := CanTransition(from, to, actor); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE orders SET status = ? WHERE id = ?", to, orderID); err != nil {
+		return err
+	}
+
+	if err := applyStockEffects(tx, orderID, from, to, note); err != nil {
+		return err
+	}
+
+	if to == OrderStatusCanceled {
+		if err := releaseCouponRedemptions(tx, orderID); err != nil {
+			return err
+		}
+	}
+
+	if err := applyLedgerEffects(tx, orderID, to); err != nil {
+		return err
+	}
+
+	return recordStatusChange(tx, orderID, from, to, actor, actorID, note)
+}
+
+// applyStockEffects keeps stock reservations in step with the order status.
+func applyStockEffects(tx *sql.Tx, orderID, from, to, note string) error {
+	reason := note
+	if reason == "" {
+		reason = "Order " + to
+	}
+
+	switch {
+	case to == OrderStatusPaid:
+		return commitReservations(tx, orderID)
+	case to == OrderStatusCanceled && from == OrderStatusPending:
+		return releaseReservations(tx, orderID, reason)
+	case to == OrderStatusCanceled:
+		return returnCommittedStock(tx, orderID, reason)
+	case to == OrderStatusRefunded && (from == OrderStatusPaid || from == OrderStatusPacked):
+		// Refunded before shipping: the goods never left the warehouse.
+		return returnCommittedStock(tx, orderID, reason)
+	}
+	return nil
+}
+
+func recordStatusChange(tx *sql.Tx, orderID, from, to string, actor Actor, actorID, note string) error {
+	_, err := tx.Exec(`
+		INSERT INTO order_status_history (id, order_id, from_status, to_status, actor_type, actor_id, note)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, utils.GenerateID(), orderID, nullString(from), to, string(actor), nullString(actorID), note)
+	return err
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+// Resource is a kind of record that belongs to a single user.
+type Resource string
+
+const (
+	ResourceCart            Resource = "cart"
+	ResourceCartItem        Resource = "cart item"
+	ResourceOrder           Resource = "order"
+	ResourcePayment         Resource = "payment"
+	ResourceReview          Resource = "review"
+	ResourceShippingAddress Resource = "shipping address"
+)
+
+var (
+	ErrResourceNotFound = errors.New("not found")
+	ErrNotOwner         = errors.New("resource belongs to another user")
+)
+
+// ownershipRule says how to find the owner of a resource and which
+// permission, if any, lets staff act on resources they do not own.
+type ownershipRule struct {
+	ownerQuery string
+	override   string
+}
+
+var ownershipRules = map[Resource]ownershipRule{
+	ResourceCart: {
+		ownerQuery: "SELECT user_id FROM carts WHERE id = ?",
+		override:   utils.PermManageOrders,
+	},
+	ResourceCartItem: {
+		ownerQuery: "SELECT c.user_id FROM cart_items ci JOIN carts c ON c.id = ci.cart_id WHERE ci.id = ?",
+		override:   utils.PermManageOrders,
+	},
+	ResourceOrder: {
+		ownerQuery: "SELECT user_id FROM orders WHERE id = ?",
+		override:   utils.PermManageOrders,
+	},
+	ResourcePayment: {
+		ownerQuery: "SELECT o.user_id FROM payments p JOIN orders o ON o.id = p.order_id WHERE p.id = ?",
+		override:   utils.PermManagePayments,
+	},
+	ResourceReview: {
+		ownerQuery: "SELECT user_id FROM reviews WHERE id = ?",
+	},
+	ResourceShippingAddress: {
+		ownerQuery: "SELECT user_id FROM shipping_addresses WHERE id = ?",
+	},
+}
+
+// CheckOwnership decides whether a user may act on a resource: owners
+// always may, others only with the resource's override permission.
+func CheckOwnership(res Resource, id, userID string, permissions []string) error {
+	rule, ok := ownershipRules[res]
+	if !ok {
+		return fmt.Errorf("no ownership rule for %s", res)
+	}
+
+	var ownerID string
+	err := database.DB.QueryRow(rule.ownerQuery, id).Scan(&ownerID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%s %w", res, ErrResourceNotFound)
+	}
+	if err != nil {
+		return err
+	}
+
+	if ownerID == userID || CanAccessAll(res, permissions) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s %s", ErrNotOwner, res, id)
+}
+
+// CanAccessAll reports whether permissions allow acting on every user's
+// resources of this kind, e.g. to list them.
+func CanAccessAll(res Resource, permissions []string) bool {
+	override := ownershipRules[res].override
+	return override != "" && utils.HasPermission(permissions, override)
+}
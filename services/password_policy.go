@@ -0,0 +1,100 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/emyu/ecommer-be/config"
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+var ErrWeakPassword = errors.New("password does not meet the password policy")
+
+var (
+	breachedOnce      sync.Once
+	breachedPasswords map[string]struct{}
+)
+
+// CheckPasswordPolicy returns ErrWeakPassword, wrapped with what to fix,
+// when password is too short or too long, contains the email address it
+// belongs to, or is on the local list of breached passwords.
+func CheckPasswordPolicy(password, email string) error {
+	cfg := config.AppConfig
+	length := utf8.RuneCountInString(password)
+	if length < cfg.PasswordMinLength {
+		return fmt.Errorf("%w: use at least %d characters", ErrWeakPassword, cfg.PasswordMinLength)
+	}
+	if length > cfg.PasswordMaxLength {
+		return fmt.Errorf("%w: use at most %d characters", ErrWeakPassword, cfg.PasswordMaxLength)
+	}
+
+	lower := strings.ToLower(password)
+	email = strings.ToLower(strings.TrimSpace(email))
+	local, _, _ := strings.Cut(email, "@")
+	if email != "" && (strings.Contains(lower, email) || (len(local) >= 3 && strings.Contains(lower, local))) {
+		return fmt.Errorf("%w: do not use your email address in your password", ErrWeakPassword)
+	}
+
+	breachedOnce.Do(loadBreachedPasswords)
+	if _, ok := breachedPasswords[lower]; ok {
+		return fmt.Errorf("%w: this password is known from data breaches, choose another", ErrWeakPassword)
+	}
+	return nil
+}
+
+// loadBreachedPasswords reads BREACHED_PASSWORDS_FILE, one password per
+// line; lines starting with # are comments. Matching ignores case. A
+// missing file only disables the check.
+func loadBreachedPasswords() {
+	breachedPasswords = map[string]struct{}{}
+	path := config.AppConfig.BreachedPasswordsFile
+	if path == "" {
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("breached password check disabled: %v", err)
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		breachedPasswords[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("reading %s: %v", path, err)
+	}
+}
+
+// RehashPasswordIfNeeded moves a hash made with bcrypt or older argon2id
+// settings to the configured hasher, right after password was checked
+// against it at login. A failure is only logged; the next login tries
+// again. Nothing changes if the password was changed in the meantime.
+func RehashPasswordIfNeeded(userID, oldHash, password string) {
+	if !utils.PasswordNeedsRehash(oldHash) {
+		return
+	}
+
+	newHash, err := utils.HashPassword(password)
+	if err == nil {
+		_, err = database.DB.Exec(
+			"UPDATE users SET password = ? WHERE id = ? AND password = ?", newHash, userID, oldHash,
+		)
+	}
+	if err != nil {
+		log.Printf("rehash password of user %s: %v", userID, err)
+	}
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/emyu/ecommer-be/config"
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/mailer"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// RequestPasswordReset emails a reset link to the user with this address.
+// Unknown addresses, and addresses that got a link within
+// PASSWORD_RESET_COOLDOWN, are ignored without an error, so the response
+// does not tell whether an account exists and the endpoint cannot be used
+// to flood a mailbox. Earlier links stop working.
+func RequestPasswordReset(email string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID, name string
+	err = tx.QueryRow("SELECT id, name FROM users WHERE email = ? FOR UPDATE", email).Scan(&userID, &name)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var recent bool
+	if err := tx.QueryRow(`
+		SELECT COUNT(*) > 0 FROM password_reset_tokens
+		WHERE user_id = ? AND created_at > NOW() - INTERVAL ? SECOND
+	`, userID, int(config.AppConfig.PasswordResetCooldown.Seconds())).Scan(&recent); err != nil {
+		return err
+	}
+	if recent {
+		return nil
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = ? AND used_at IS NULL", userID,
+	); err != nil {
+		return err
+	}
+
+	token := utils.GenerateSecureToken(32)
+	ttl := config.AppConfig.PasswordResetTTL
+	_, err = tx.Exec(`
+		INSERT INTO password_reset_tokens (id, user_id, token_hash, expires_at)
+		VALUES (?, ?, ?, NOW() + INTERVAL ? SECOND)
+	`, utils.GenerateID(), userID, utils.HashToken(token), int(ttl.Seconds()))
+	if err != nil {
+		return err
+	}
+
+	if err := enqueueEmail(tx, passwordResetEmail(email, name, token)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ResetPassword sets a new password with a reset token. The token works
+// once; afterwards every session of the user is revoked, so all refresh
+// and access tokens stop working.
+func ResetPassword(token, newPassword string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id, userID string
+	var expired bool
+	var usedAt sql.NullTime
+	err = tx.QueryRow(`
+		SELECT id, user_id, expires_at < NOW(), used_at FROM password_reset_tokens WHERE token_hash = ? FOR UPDATE
+	`, utils.HashToken(token)).Scan(&id, &userID, &expired, &usedAt)
+	if err == sql.ErrNoRows {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	if expired || usedAt.Valid {
+		return ErrInvalidResetToken
+	}
+
+	var email string
+	if err := tx.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&email); err != nil {
+		return err
+	}
+	if err := CheckPasswordPolicy(newPassword, email); err != nil {
+		return err
+	}
+
+	hashed, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE users SET password = ? WHERE id = ?", hashed, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		"UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = ? AND used_at IS NULL", userID,
+	); err != nil {
+		return err
+	}
+	if err := revokeUserSessions(tx, userID, "password reset"); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func passwordResetEmail(to, name, token string) mailer.Message {
+	cfg := config.AppConfig
+	link := cfg.PasswordResetURL + "?token=" + url.QueryEscape(token)
+	return mailer.Message{
+		To:      to,
+		Subject: "Reset your " + cfg.AppName + " password",
+		Body: fmt.Sprintf(`Hi %s,
+
+We received a request to reset your password. Open the link below to choose
+a new one:
+
+%s
+
+The link expires in %s and can only be used once. If you did not ask for a
+reset, you can ignore this email; your password has not been changed.
+`, name, link, cfg.PasswordResetTTL),
+	}
+}
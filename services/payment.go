@@ -0,0 +1,286 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/emyu/ecommer-be/config"
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/payment"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+// Payment statuses stored in payments.payment_status
+const (
+	PaymentStatusPending = "pending"
+	PaymentStatusSuccess = "success"
+	PaymentStatusFailed  = "failed"
+	PaymentStatusExpired = "expired"
+)
+
+var (
+	ErrPaymentNotFound = errors.New("payment not found")
+	ErrOrderNotPayable = errors.New("order is not awaiting payment")
+	ErrPaymentProvider = errors.New("payment provider error")
+	ErrUnsupportedBank = errors.New("unsupported bank")
+)
+
+// CreatePayment opens a charge for a pending order at the provider that
+// handles the order's payment method. If the order already has an open
+// payment, that payment is returned instead.
+func CreatePayment(ctx context.Context, orderID, userID, bank string) (string, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var ownerID, status, method, orderNumber, customerName, customerEmail string
+	var amount float64
+	err = tx.QueryRow(`
+		SELECT o.user_id, o.status, o.payment_method, o.order_number, o.total_amount, u.name, u.email
+		FROM orders o JOIN users u ON o.user_id = u.id
+		WHERE o.id = ? FOR UPDATE
+	`, orderID).Scan(&ownerID, &status, &method, &orderNumber, &amount, &customerName, &customerEmail)
+	if err == sql.ErrNoRows || (err == nil && ownerID != userID) {
+		return "", ErrOrderNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if status != OrderStatusPending {
+		return "", ErrOrderNotPayable
+	}
+
+	var existingID string
+	err = tx.QueryRow(
+		"SELECT id FROM payments WHERE order_id = ? AND payment_status = ? LIMIT 1",
+		orderID, PaymentStatusPending,
+	).Scan(&existingID)
+	if err == nil {
+		return existingID, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	channel, err := payment.ChannelForMethod(method)
+	if err != nil {
+		return "", err
+	}
+	provider, err := payment.ForChannel(channel)
+	if errors.Is(err, payment.ErrUnknownChannel) {
+		// e.g. QRIS was disabled after the order was placed
+		return "", fmt.Errorf("%w: %v", ErrPaymentMethodDisabled, err)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	paymentID := utils.GenerateID()
+	expiresAt := time.Now().Add(config.AppConfig.PaymentTTL)
+
+	var vaNumber string
+	if channel == payment.ChannelBankTransfer {
+		if bank == "" {
+			bank = "bca"
+		}
+		if !payment.IsBank(bank) {
+			return "", fmt.Errorf("%w: %s", ErrUnsupportedBank, bank)
+		}
+		if vaNumber, err = nextVANumber(tx, bank); err != nil {
+			return "", err
+		}
+	} else {
+		bank = ""
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO payments (id, order_id, payment_status, amount, channel, bank, va_number, provider, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, paymentID, orderID, PaymentStatusPending, amount, string(channel), nullString(bank), nullString(vaNumber), provider.Name(), expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	// The provider is called outside the transaction so that a slow gateway
+	// never holds the order lock.
+	charge, err := provider.CreateCharge(ctx, payment.ChargeRequest{
+		Reference:     paymentID,
+		OrderNumber:   orderNumber,
+		Amount:        amount,
+		Channel:       channel,
+		Bank:          bank,
+		VANumber:      vaNumber,
+		CustomerName:  customerName,
+		CustomerEmail: customerEmail,
+		ExpiresAt:     expiresAt,
+	})
+	if err != nil {
+		database.DB.Exec("UPDATE payments SET payment_status = ? WHERE id = ?", PaymentStatusFailed, paymentID)
+		return "", fmt.Errorf("%w: %v", ErrPaymentProvider, err)
+	}
+
+	if charge.ExpiresAt.IsZero() {
+		charge.ExpiresAt = expiresAt
+	}
+
+	// Prefer the acquirer's QR string; otherwise build our own payload.
+	qrPayload := charge.QRString
+	if channel == payment.ChannelQRIS && qrPayload == "" {
+		if qrPayload, err = payment.BuildQRISPayload(payment.ConfiguredQRISMerchant(), amount, orderNumber, paymentID); err != nil {
+			return "", err
+		}
+	}
+
+	_, err = database.DB.Exec(`
+		UPDATE payments SET provider_ref = ?, payment_code = ?, checkout_url = ?, qr_payload = ?, expires_at = ? WHERE id = ?
+	`, charge.ProviderRef, nullString(charge.PaymentCode), nullString(charge.CheckoutURL), nullString(qrPayload), charge.ExpiresAt, paymentID)
+	if err != nil {
+		return "", err
+	}
+
+	return paymentID, nil
+}
+
+// SyncPaymentStatus asks the payment's provider for the current charge
+// status and applies it locally.
+func SyncPaymentStatus(ctx context.Context, paymentID string) error {
+	var providerName string
+	var providerRef sql.NullString
+	err := database.DB.QueryRow("SELECT provider, provider_ref FROM payments WHERE id = ?", paymentID).Scan(&providerName, &providerRef)
+	if err == sql.ErrNoRows {
+		return ErrPaymentNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	provider, err := payment.Get(providerName)
+	if err != nil {
+		return err
+	}
+
+	charge, err := provider.GetStatus(ctx, providerRef.String)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPaymentProvider, err)
+	}
+
+	return ApplyPaymentStatus(paymentID, charge.Status, "Status reported by "+providerName)
+}
+
+// ApplyPaymentStatus records a new charge status on a payment and moves the
+// linked order to paid or canceled. Applying the same status twice is a no-op.
+func ApplyPaymentStatus(paymentID string, status payment.Status, note string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := applyPaymentStatus(tx, paymentID, status, ActorPayment, note); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func applyPaymentStatus(tx *sql.Tx, paymentID string, status payment.Status, actor Actor, note string) error {
+	var orderID, current string
+	var amount float64
+	err := tx.QueryRow("SELECT order_id, payment_status, amount FROM payments WHERE id = ? FOR UPDATE", paymentID).Scan(&orderID, &current, &amount)
+	if err == sql.ErrNoRows {
+		return ErrPaymentNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	change := nextPaymentStatus(current, status)
+	if !change.apply {
+		if change.next != "" && change.next != current {
+			log.Printf("payment %s: ignoring %s, payment is already %s", paymentID, status, current)
+		}
+		return nil
+	}
+	next, orderStatus := change.next, change.orderStatus
+	if change.late {
+		// The customer paid at the deadline. Keep the money on record so an
+		// admin can refund it.
+		log.Printf("payment %s: %s arrived after the payment was %s, flagged as paid late", paymentID, status, current)
+		note = strings.TrimSpace(note + " (paid after the payment was " + current + ")")
+	}
+
+	var paidAt *time.Time
+	if next == PaymentStatusSuccess {
+		now := time.Now()
+		paidAt = &now
+	}
+
+	_, err = tx.Exec(
+		"UPDATE payments SET payment_status = ?, paid_at = ?, paid_late = paid_late OR ? WHERE id = ?",
+		next, paidAt, change.late, paymentID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if next == PaymentStatusSuccess {
+		if _, err := tx.Exec("UPDATE orders SET paid_amount = paid_amount + ? WHERE id = ?", amount, orderID); err != nil {
+			return err
+		}
+	}
+
+	err = TransitionOrder(tx, orderID, orderStatus, actor, "", note)
+	if errors.Is(err, ErrInvalidTransition) {
+		// e.g. money arrived for an order the customer already canceled;
+		// the payment is still recorded so it can be refunded.
+		log.Printf("payment %s: order %s not moved to %s: %v", paymentID, orderID, orderStatus, err)
+		return nil
+	}
+	return err
+}
+
+// paymentChange is what a charge status reported by a provider does to a
+// payment.
+type paymentChange struct {
+	next        string // payment status to store
+	orderStatus string // order status to move to
+	late        bool   // money arrived after the payment expired or failed
+	apply       bool   // false when the report changes nothing
+}
+
+// nextPaymentStatus decides how a payment in status current reacts to a
+// reported charge status. Only pending payments move, with one exception:
+// a success for an expired or failed payment is still recorded, flagged as
+// late, because the customer's money has arrived and must not be lost.
+func nextPaymentStatus(current string, status payment.Status) paymentChange {
+	var c paymentChange
+	switch status {
+	case payment.StatusSuccess:
+		c.next, c.orderStatus = PaymentStatusSuccess, OrderStatusPaid
+	case payment.StatusFailed, payment.StatusCanceled:
+		c.next, c.orderStatus = PaymentStatusFailed, OrderStatusCanceled
+	case payment.StatusExpired:
+		c.next, c.orderStatus = PaymentStatusExpired, OrderStatusCanceled
+	default:
+		return c
+	}
+
+	switch {
+	case current == c.next:
+	case current == PaymentStatusPending:
+		c.apply = true
+	case c.next == PaymentStatusSuccess && (current == PaymentStatusExpired || current == PaymentStatusFailed):
+		c.apply, c.late = true, true
+	}
+	return c
+}
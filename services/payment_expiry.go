@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/emyu/ecommer-be/config"
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/payment"
+)
+
+const expiryBatchSize = 100
+
+// StartPaymentExpiryWorker runs ExpireOverduePayments on every tick until
+// ctx is canceled. Replicas share a lease so only one sweeps at a time; each
+// payment and order is also re-checked under a row lock, so an overlapping
+// sweep can never expire the same payment twice.
+func StartPaymentExpiryWorker(ctx context.Context) {
+	interval := config.AppConfig.PaymentExpiryInterval
+	if interval <= 0 {
+		log.Println("Payment expiry worker disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ok, err := acquireLease("payment_expiry", 2*interval)
+			if err != nil {
+				log.Printf("payment expiry: lease: %v", err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			if err := ExpireOverduePayments(ctx); err != nil {
+				log.Printf("payment expiry: %v", err)
+			}
+		}
+	}
+}
+
+// ExpireOverduePayments expires pending payments past their deadline and
+// cancels pending orders that never received a payment in time. Canceling
+// an order releases its reserved stock.
+func ExpireOverduePayments(ctx context.Context) error {
+	paymentIDs, err := queryIDs(`
+		SELECT id FROM payments
+		WHERE payment_status = ? AND expires_at < NOW()
+		ORDER BY expires_at LIMIT ?
+	`, PaymentStatusPending, expiryBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range paymentIDs {
+		if err := expirePayment(ctx, id); err != nil {
+			log.Printf("payment expiry: payment %s: %v", id, err)
+		}
+	}
+
+	orderIDs, err := queryIDs(`
+		SELECT o.id FROM orders o
+		WHERE o.status = ? AND o.created_at < NOW() - INTERVAL ? SECOND
+		  AND NOT EXISTS (SELECT 1 FROM payments p WHERE p.order_id = o.id AND p.payment_status = ?)
+		ORDER BY o.created_at LIMIT ?
+	`, OrderStatusPending, int(config.AppConfig.UnpaidOrderTTL.Seconds()), PaymentStatusPending, expiryBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range orderIDs {
+		if err := cancelUnpaidOrder(id); err != nil {
+			log.Printf("payment expiry: order %s: %v", id, err)
+		}
+	}
+
+	return nil
+}
+
+func expirePayment(ctx context.Context, paymentID string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status, providerName string
+	var providerRef sql.NullString
+	var expiresAt time.Time
+	err = tx.QueryRow(`
+		SELECT payment_status, provider, provider_ref, expires_at FROM payments WHERE id = ? FOR UPDATE
+	`, paymentID).Scan(&status, &providerName, &providerRef, &expiresAt)
+	if err != nil {
+		return err
+	}
+	if status != PaymentStatusPending || time.Now().Before(expiresAt) {
+		return nil
+	}
+
+	if err := applyPaymentStatus(tx, paymentID, payment.StatusExpired, ActorSystem, "Payment expired before it was completed"); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Close the charge at the provider so it cannot be paid any more.
+	if provider, err := payment.Get(providerName); err == nil && providerRef.Valid {
+		if err := provider.Cancel(ctx, providerRef.String); err != nil {
+			log.Printf("payment expiry: cancel %s at %s: %v", paymentID, providerName, err)
+		}
+	}
+	return nil
+}
+
+func cancelUnpaidOrder(orderID string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	if err := tx.QueryRow("SELECT status FROM orders WHERE id = ? FOR UPDATE", orderID).Scan(&status); err != nil {
+		return err
+	}
+
+	var openPayments int
+	err = tx.QueryRow(
+		"SELECT COUNT(*) FROM payments WHERE order_id = ? AND payment_status = ?",
+		orderID, PaymentStatusPending,
+	).Scan(&openPayments)
+	if err != nil {
+		return err
+	}
+	if status != OrderStatusPending || openPayments > 0 {
+		return nil
+	}
+
+	if err := TransitionOrder(tx, orderID, OrderStatusCanceled, ActorSystem, "", "No payment received before the deadline"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func queryIDs(query string, args ...interface{}) ([]string, error) {
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,43 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/emyu/ecommer-be/payment"
+)
+
+func TestNextPaymentStatus(t *testing.T) {
+	cases := []struct {
+		name    string
+		current string
+		status  payment.Status
+		want    paymentChange
+	}{
+		{"pending payment succeeds", PaymentStatusPending, payment.StatusSuccess,
+			paymentChange{next: PaymentStatusSuccess, orderStatus: OrderStatusPaid, apply: true}},
+		{"pending payment expires", PaymentStatusPending, payment.StatusExpired,
+			paymentChange{next: PaymentStatusExpired, orderStatus: OrderStatusCanceled, apply: true}},
+		{"pending payment is canceled", PaymentStatusPending, payment.StatusCanceled,
+			paymentChange{next: PaymentStatusFailed, orderStatus: OrderStatusCanceled, apply: true}},
+		{"success for expired payment is kept as late", PaymentStatusExpired, payment.StatusSuccess,
+			paymentChange{next: PaymentStatusSuccess, orderStatus: OrderStatusPaid, late: true, apply: true}},
+		{"success for failed payment is kept as late", PaymentStatusFailed, payment.StatusSuccess,
+			paymentChange{next: PaymentStatusSuccess, orderStatus: OrderStatusPaid, late: true, apply: true}},
+		{"repeated success is a no-op", PaymentStatusSuccess, payment.StatusSuccess,
+			paymentChange{next: PaymentStatusSuccess, orderStatus: OrderStatusPaid}},
+		{"paid payment does not expire", PaymentStatusSuccess, payment.StatusExpired,
+			paymentChange{next: PaymentStatusExpired, orderStatus: OrderStatusCanceled}},
+		{"expired payment does not fail", PaymentStatusExpired, payment.StatusFailed,
+			paymentChange{next: PaymentStatusFailed, orderStatus: OrderStatusCanceled}},
+		{"pending status changes nothing", PaymentStatusPending, payment.StatusPending,
+			paymentChange{}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := nextPaymentStatus(tc.current, tc.status); got != tc.want {
+				t.Errorf("nextPaymentStatus(%q, %q) = %+v, want %+v", tc.current, tc.status, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/emyu/ecommer-be/config"
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/payment"
+	"github.com/emyu/ecommer-be/utils"
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	ErrInvalidSignature     = errors.New("invalid webhook signature")
+	ErrWebhookNotSupported  = errors.New("provider does not send webhooks")
+	ErrNotificationMismatch = errors.New("notification does not match payment")
+)
+
+// HandlePaymentNotification verifies and applies a provider webhook. It
+// reports duplicate=true when the same notification was already processed.
+func HandlePaymentNotification(providerName string, body []byte, signature string) (duplicate bool, err error) {
+	secret := config.AppConfig.PaymentWebhookSecrets[providerName]
+	if !payment.VerifySignature(secret, body, signature) {
+		return false, ErrInvalidSignature
+	}
+
+	provider, err := payment.Get(providerName)
+	if err != nil {
+		return false, err
+	}
+	parser, ok := provider.(payment.NotificationParser)
+	if !ok {
+		return false, ErrWebhookNotSupported
+	}
+
+	n, err := parser.ParseNotification(body)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var paymentID string
+	var amount float64
+	err = tx.QueryRow(`
+		SELECT id, amount FROM payments
+		WHERE provider = ? AND (provider_ref = ? OR id = ?)
+		FOR UPDATE
+	`, providerName, n.ProviderRef, n.Reference).Scan(&paymentID, &amount)
+	if err == sql.ErrNoRows {
+		return false, ErrPaymentNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO payment_notifications (id, provider, notification_id, payment_id, status, payload)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, utils.GenerateID(), providerName, n.ID, paymentID, string(n.Status), string(body))
+	if isDuplicateKey(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if n.Status == payment.StatusSuccess && n.Amount > 0 && math.Abs(n.Amount-amount) >= 0.01 {
+		return false, fmt.Errorf("%w: paid %.2f, expected %.2f", ErrNotificationMismatch, n.Amount, amount)
+	}
+
+	if err := applyPaymentStatus(tx, paymentID, n.Status, ActorPayment, "Notification from "+providerName); err != nil {
+		return false, err
+	}
+
+	return false, tx.Commit()
+}
+
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
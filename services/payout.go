@@ -0,0 +1,449 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/emyu/ecommer-be/config"
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+// Seller ledger entry types stored in seller_ledger_entries.entry_type
+const (
+	LedgerEarning        = "earning"
+	LedgerRefund         = "refund"
+	LedgerPayout         = "payout"
+	LedgerPayoutReversal = "payout_reversal"
+)
+
+// Payout batch statuses stored in payout_batches.status
+const (
+	PayoutStatusPending  = "pending"
+	PayoutStatusPaid     = "paid"
+	PayoutStatusCanceled = "canceled"
+)
+
+var (
+	ErrPayoutBatchNotFound = errors.New("payout batch not found")
+	ErrPayoutBatchFinished = errors.New("payout batch is already paid or canceled")
+	ErrNothingToPay        = errors.New("no seller balance is due for payout")
+)
+
+// ledgerEntry is one change to a seller's balance. Amount is signed: what
+// the seller earns is positive, what is paid out or taken back is negative.
+type ledgerEntry struct {
+	SellerID         string
+	EntryType        string
+	GrossAmount      sql.NullFloat64
+	CommissionAmount sql.NullFloat64
+	Amount           float64
+	OrderID          string
+	OrderItemID      string
+	RefundID         string
+	PayoutBatchID    string
+	Description      string
+}
+
+// appendLedger records an entry and moves the seller's balance with it.
+// Entries tied to an order line are unique per type and refund, so
+// crediting the same line twice, or reversing it twice for one refund, is a
+// no-op.
+func appendLedger(tx *sql.Tx, e ledgerEntry) error {
+	if _, err := tx.Exec(
+		"INSERT INTO seller_balances (seller_id, balance) VALUES (?, 0) ON DUPLICATE KEY UPDATE seller_id = seller_id",
+		e.SellerID,
+	); err != nil {
+		return err
+	}
+
+	var balance float64
+	if err := tx.QueryRow("SELECT balance FROM seller_balances WHERE seller_id = ? FOR UPDATE", e.SellerID).Scan(&balance); err != nil {
+		return err
+	}
+	balance = math.Round((balance+e.Amount)*100) / 100
+
+	_, err := tx.Exec(`
+		INSERT INTO seller_ledger_entries
+			(id, seller_id, entry_type, gross_amount, commission_amount, amount, balance_after, order_id, order_item_id, refund_id, payout_batch_id, description)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, utils.GenerateID(), e.SellerID, e.EntryType, e.GrossAmount, e.CommissionAmount, e.Amount, balance,
+		nullString(e.OrderID), nullString(e.OrderItemID), e.RefundID, nullString(e.PayoutBatchID), e.Description)
+	if isDuplicateKey(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE seller_balances SET balance = ? WHERE seller_id = ?", balance, e.SellerID)
+	return err
+}
+
+// applyLedgerEffects keeps seller balances in step with the order status:
+// sellers earn their lines once the order is delivered, minus their share
+// of refunds already made, and lose what is left if the order is refunded
+// in full afterwards.
+func applyLedgerEffects(tx *sql.Tx, orderID, to string) error {
+	switch to {
+	case OrderStatusDelivered:
+		if err := creditSellerEarnings(tx, orderID); err != nil {
+			return err
+		}
+		return reverseSettledRefunds(tx, orderID)
+	case OrderStatusRefunded:
+		return reverseSellerEarnings(tx, orderID)
+	}
+	return nil
+}
+
+// creditSellerEarnings credits each seller with their order lines minus the
+// platform commission. Store products without a seller are skipped.
+func creditSellerEarnings(tx *sql.Tx, orderID string) error {
+	rows, err := tx.Query(`
+		SELECT oi.id, p.seller_id, oi.price * oi.quantity
+		FROM order_items oi
+		JOIN product_variants pv ON pv.id = oi.product_variant_id
+		JOIN products p ON p.id = pv.product_id
+		WHERE oi.order_id = ? AND p.seller_id IS NOT NULL
+	`, orderID)
+	if err != nil {
+		return err
+	}
+
+	var entries []ledgerEntry
+	for rows.Next() {
+		var itemID, sellerID string
+		var gross float64
+		if err := rows.Scan(&itemID, &sellerID, &gross); err != nil {
+			rows.Close()
+			return err
+		}
+		commission := math.Round(gross*config.AppConfig.CommissionPercent) / 100
+		entries = append(entries, ledgerEntry{
+			SellerID:         sellerID,
+			EntryType:        LedgerEarning,
+			GrossAmount:      sql.NullFloat64{Float64: gross, Valid: true},
+			CommissionAmount: sql.NullFloat64{Float64: commission, Valid: true},
+			Amount:           math.Round((gross-commission)*100) / 100,
+			OrderID:          orderID,
+			OrderItemID:      itemID,
+			Description:      "Order delivered",
+		})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := appendLedger(tx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// earnedLine is what a seller earned on one order line and how much of it
+// has not been reversed by refunds yet.
+type earnedLine struct {
+	sellerID, orderItemID                          string
+	gross, commission, amount                      float64
+	remainingGross, remainingCommission, remaining float64
+}
+
+func earnedLines(tx *sql.Tx, orderID string) ([]earnedLine, error) {
+	rows, err := tx.Query(`
+		SELECT e.seller_id, e.order_item_id, e.gross_amount, e.commission_amount, e.amount,
+		       e.gross_amount + COALESCE(SUM(r.gross_amount), 0),
+		       e.commission_amount + COALESCE(SUM(r.commission_amount), 0),
+		       e.amount + COALESCE(SUM(r.amount), 0)
+		FROM seller_ledger_entries e
+		LEFT JOIN seller_ledger_entries r ON r.order_item_id = e.order_item_id AND r.entry_type = ?
+		WHERE e.order_id = ? AND e.entry_type = ?
+		GROUP BY e.id, e.seller_id, e.order_item_id, e.gross_amount, e.commission_amount, e.amount
+	`, LedgerRefund, orderID, LedgerEarning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []earnedLine
+	for rows.Next() {
+		var l earnedLine
+		if err := rows.Scan(&l.sellerID, &l.orderItemID, &l.gross, &l.commission, &l.amount,
+			&l.remainingGross, &l.remainingCommission, &l.remaining); err != nil {
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+	return lines, rows.Err()
+}
+
+// reverseRefundShare takes back the sellers' share of one refund: each
+// earned line loses the fraction of the amount paid for the order that was
+// refunded, never more than is left of it.
+func reverseRefundShare(tx *sql.Tx, orderID, refundID string, refunded, paid float64) error {
+	if paid <= 0 {
+		return nil
+	}
+	fraction := math.Min(refunded/paid, 1)
+
+	lines, err := earnedLines(tx, orderID)
+	if err != nil {
+		return err
+	}
+	for _, l := range lines {
+		share := func(earned, remaining float64) float64 {
+			return math.Min(math.Round(earned*fraction*100)/100, remaining)
+		}
+		amount := share(l.amount, l.remaining)
+		if amount <= 0 {
+			continue
+		}
+		gross, commission := share(l.gross, l.remainingGross), share(l.commission, l.remainingCommission)
+		err := appendLedger(tx, ledgerEntry{
+			SellerID:         l.sellerID,
+			EntryType:        LedgerRefund,
+			GrossAmount:      sql.NullFloat64{Float64: -gross, Valid: true},
+			CommissionAmount: sql.NullFloat64{Float64: -commission, Valid: true},
+			Amount:           -amount,
+			OrderID:          orderID,
+			OrderItemID:      l.orderItemID,
+			RefundID:         refundID,
+			Description:      "Order partially refunded",
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reverseSettledRefunds applies the refunds that succeeded before the order
+// was delivered, once there are earnings to take them from.
+func reverseSettledRefunds(tx *sql.Tx, orderID string) error {
+	var paid float64
+	if err := tx.QueryRow("SELECT paid_amount FROM orders WHERE id = ?", orderID).Scan(&paid); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(
+		"SELECT id, amount FROM refunds WHERE order_id = ? AND status = ? ORDER BY created_at, id",
+		orderID, RefundStatusSucceeded,
+	)
+	if err != nil {
+		return err
+	}
+	type settled struct {
+		id     string
+		amount float64
+	}
+	var refunds []settled
+	for rows.Next() {
+		var r settled
+		if err := rows.Scan(&r.id, &r.amount); err != nil {
+			rows.Close()
+			return err
+		}
+		refunds = append(refunds, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range refunds {
+		if err := reverseRefundShare(tx, orderID, r.id, r.amount, paid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reverseSellerEarnings takes back whatever sellers still hold of their
+// earnings on an order once it is refunded in full.
+func reverseSellerEarnings(tx *sql.Tx, orderID string) error {
+	lines, err := earnedLines(tx, orderID)
+	if err != nil {
+		return err
+	}
+	for _, l := range lines {
+		if l.remaining <= 0 {
+			continue
+		}
+		err := appendLedger(tx, ledgerEntry{
+			SellerID:         l.sellerID,
+			EntryType:        LedgerRefund,
+			GrossAmount:      sql.NullFloat64{Float64: -l.remainingGross, Valid: true},
+			CommissionAmount: sql.NullFloat64{Float64: -l.remainingCommission, Valid: true},
+			Amount:           -l.remaining,
+			OrderID:          orderID,
+			OrderItemID:      l.orderItemID,
+			Description:      "Order refunded",
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreatePayoutBatch moves the balance of every seller owed at least
+// minAmount into a new pending batch. Sellers without a payout account are
+// left out; their number is returned as skipped.
+func CreatePayoutBatch(minAmount float64, actorID string) (batchID string, skipped int, err error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return "", 0, err
+	}
+	defer tx.Rollback()
+
+	type due struct {
+		sellerID                             string
+		amount                               float64
+		bankName, accountNumber, accountName sql.NullString
+	}
+
+	rows, err := tx.Query(`
+		SELECT sb.seller_id, sb.balance, a.bank_name, a.account_number, a.account_name
+		FROM seller_balances sb
+		LEFT JOIN seller_payout_accounts a ON a.seller_id = sb.seller_id
+		WHERE sb.balance > 0 AND sb.balance >= ?
+		ORDER BY sb.seller_id
+		FOR UPDATE
+	`, minAmount)
+	if err != nil {
+		return "", 0, err
+	}
+	var payable []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.sellerID, &d.amount, &d.bankName, &d.accountNumber, &d.accountName); err != nil {
+			rows.Close()
+			return "", 0, err
+		}
+		if !d.accountNumber.Valid {
+			skipped++
+			continue
+		}
+		payable = append(payable, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", 0, err
+	}
+	if len(payable) == 0 {
+		return "", skipped, ErrNothingToPay
+	}
+
+	batchID = utils.GenerateID()
+	if _, err := tx.Exec(
+		"INSERT INTO payout_batches (id, status, created_by) VALUES (?, ?, ?)",
+		batchID, PayoutStatusPending, actorID,
+	); err != nil {
+		return "", 0, err
+	}
+
+	var total float64
+	for _, d := range payable {
+		_, err := tx.Exec(`
+			INSERT INTO payout_items (id, batch_id, seller_id, amount, bank_name, account_number, account_name)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, utils.GenerateID(), batchID, d.sellerID, d.amount, d.bankName.String, d.accountNumber.String, d.accountName.String)
+		if err != nil {
+			return "", 0, err
+		}
+		err = appendLedger(tx, ledgerEntry{
+			SellerID:      d.sellerID,
+			EntryType:     LedgerPayout,
+			Amount:        -d.amount,
+			PayoutBatchID: batchID,
+			Description:   "Payout batch created",
+		})
+		if err != nil {
+			return "", 0, err
+		}
+		total += d.amount
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE payout_batches SET total_amount = ?, item_count = ? WHERE id = ?",
+		math.Round(total*100)/100, len(payable), batchID,
+	); err != nil {
+		return "", 0, err
+	}
+
+	return batchID, skipped, tx.Commit()
+}
+
+// MarkPayoutBatchPaid records that the transfers of a pending batch went out.
+func MarkPayoutBatchPaid(batchID, reference, actorID string) error {
+	return finishPayoutBatch(batchID, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE payout_batches SET status = ?, paid_reference = ?, paid_by = ?, paid_at = NOW() WHERE id = ?
+		`, PayoutStatusPaid, reference, actorID, batchID)
+		return err
+	})
+}
+
+// CancelPayoutBatch drops a pending batch and credits its amounts back to
+// the sellers.
+func CancelPayoutBatch(batchID string) error {
+	return finishPayoutBatch(batchID, func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT seller_id, amount FROM payout_items WHERE batch_id = ?", batchID)
+		if err != nil {
+			return err
+		}
+		var entries []ledgerEntry
+		for rows.Next() {
+			e := ledgerEntry{EntryType: LedgerPayoutReversal, PayoutBatchID: batchID, Description: "Payout batch canceled"}
+			if err := rows.Scan(&e.SellerID, &e.Amount); err != nil {
+				rows.Close()
+				return err
+			}
+			entries = append(entries, e)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			if err := appendLedger(tx, e); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec("UPDATE payout_batches SET status = ?, canceled_at = NOW() WHERE id = ?", PayoutStatusCanceled, batchID)
+		return err
+	})
+}
+
+// finishPayoutBatch runs finish on a locked batch that is still pending.
+func finishPayoutBatch(batchID string, finish func(tx *sql.Tx) error) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow("SELECT status FROM payout_batches WHERE id = ? FOR UPDATE", batchID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return ErrPayoutBatchNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if status != PayoutStatusPending {
+		return fmt.Errorf("%w: batch is %s", ErrPayoutBatchFinished, status)
+	}
+
+	if err := finish(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
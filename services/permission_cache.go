@@ -0,0 +1,168 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/emyu/ecommer-be/config"
+	"github.com/emyu/ecommer-be/database"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+// Principal is the caller of a request, with its role and permissions as
+// they are right now rather than when the token was issued.
+type Principal struct {
+	UserID      string
+	Email       string
+	RoleID      int
+	RoleName    string
+	Permissions []string
+	// TwoFactorEnabled is true once the user has confirmed a TOTP app.
+	TwoFactorEnabled bool
+}
+
+type cachedUser struct {
+	email            string
+	roleID           int
+	isActive         bool
+	twoFactorEnabled bool
+	loadedAt         time.Time
+}
+
+type cachedRole struct {
+	name        string
+	permissions []string
+	loadedAt    time.Time
+}
+
+// The cache is per process. Invalidate* clears it locally at once; other
+// replicas pick the change up when PERMISSION_CACHE_TTL runs out.
+var permissionCache = struct {
+	sync.RWMutex
+	users map[string]cachedUser
+	roles map[int]cachedRole
+}{
+	users: map[string]cachedUser{},
+	roles: map[int]cachedRole{},
+}
+
+// ResolvePrincipal looks up the current role and permissions of a user.
+// Deactivated users get ErrUserInactive; an inactive role grants nothing.
+func ResolvePrincipal(userID string) (*Principal, error) {
+	user, err := lookupUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.isActive {
+		return nil, ErrUserInactive
+	}
+
+	role, err := lookupRole(user.roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Principal{
+		UserID:      userID,
+		Email:       user.email,
+		RoleID:      user.roleID,
+		RoleName:    role.name,
+		Permissions: role.permissions,
+
+		TwoFactorEnabled: user.twoFactorEnabled,
+	}, nil
+}
+
+// InvalidateUser drops the cached role assignment of a user.
+func InvalidateUser(userID string) {
+	permissionCache.Lock()
+	delete(permissionCache.users, userID)
+	permissionCache.Unlock()
+}
+
+// InvalidateRole drops the cached name and permissions of a role.
+func InvalidateRole(roleID int) {
+	permissionCache.Lock()
+	delete(permissionCache.roles, roleID)
+	permissionCache.Unlock()
+}
+
+func lookupUser(userID string) (cachedUser, error) {
+	ttl := config.AppConfig.PermissionCacheTTL
+
+	permissionCache.RLock()
+	user, ok := permissionCache.users[userID]
+	permissionCache.RUnlock()
+	if ok && time.Since(user.loadedAt) < ttl {
+		return user, nil
+	}
+
+	user = cachedUser{loadedAt: time.Now()}
+	err := database.DB.QueryRow(
+		"SELECT email, role_id, is_active, totp_enabled_at IS NOT NULL FROM users WHERE id = ?", userID,
+	).Scan(&user.email, &user.roleID, &user.isActive, &user.twoFactorEnabled)
+	if err == sql.ErrNoRows {
+		return user, ErrUserNotFound
+	}
+	if err != nil {
+		return user, err
+	}
+
+	permissionCache.Lock()
+	permissionCache.users[userID] = user
+	permissionCache.Unlock()
+	return user, nil
+}
+
+func lookupRole(roleID int) (cachedRole, error) {
+	ttl := config.AppConfig.PermissionCacheTTL
+
+	permissionCache.RLock()
+	role, ok := permissionCache.roles[roleID]
+	permissionCache.RUnlock()
+	if ok && time.Since(role.loadedAt) < ttl {
+		return role, nil
+	}
+
+	role = cachedRole{permissions: []string{}, loadedAt: time.Now()}
+	var active bool
+	if err := database.DB.QueryRow("SELECT name, is_active FROM roles WHERE id = ?", roleID).Scan(&role.name, &active); err != nil {
+		return role, err
+	}
+
+	// A deactivated role keeps its grants for when it is reactivated, but
+	// its holders get none of them meanwhile.
+	if active {
+		perms, err := rolePermissions(roleID)
+		if err != nil {
+			return role, err
+		}
+		role.permissions = perms
+	}
+
+	permissionCache.Lock()
+	permissionCache.roles[roleID] = role
+	permissionCache.Unlock()
+	return role, nil
+}
+
+func rolePermissions(roleID int) ([]string, error) {
+	rows, err := database.DB.Query("SELECT permission FROM role_permissions WHERE role_id = ?", roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	perms := []string{}
+	for rows.Next() {
+		var perm string
+		if err := rows.Scan(&perm); err != nil {
+			return nil, err
+		}
+		perms = append(perms, perm)
+	}
+	return perms, rows.Err()
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/payment"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+// Refund statuses stored in refunds.status
+const (
+	RefundStatusRequested  = "requested"
+	RefundStatusProcessing = "processing"
+	RefundStatusSucceeded  = "succeeded"
+	RefundStatusFailed     = "failed"
+)
+
+var (
+	ErrRefundNotFound       = errors.New("refund not found")
+	ErrPaymentNotRefundable = errors.New("payment is not refundable")
+	ErrInvalidRefundAmount  = errors.New("invalid refund amount")
+	ErrRefundFinished       = errors.New("refund is already finished")
+)
+
+// RequestRefund gives back amount of a successful payment, or everything
+// that has not been refunded yet when amount is 0. Refunds that are still
+// in flight count against the refundable balance, so two concurrent
+// requests can never refund more than was paid.
+func RequestRefund(ctx context.Context, paymentID string, amount float64, reason, actorID string) (string, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var orderID, status, providerName string
+	var providerRef sql.NullString
+	var paid float64
+	err = tx.QueryRow(`
+		SELECT order_id, payment_status, amount, provider, provider_ref FROM payments WHERE id = ? FOR UPDATE
+	`, paymentID).Scan(&orderID, &status, &paid, &providerName, &providerRef)
+	if err == sql.ErrNoRows {
+		return "", ErrPaymentNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if status != PaymentStatusSuccess {
+		return "", fmt.Errorf("%w: payment is %s", ErrPaymentNotRefundable, status)
+	}
+
+	var committed float64
+	err = tx.QueryRow(`
+		SELECT COALESCE(SUM(amount), 0) FROM refunds WHERE payment_id = ? AND status <> ?
+	`, paymentID, RefundStatusFailed).Scan(&committed)
+	if err != nil {
+		return "", err
+	}
+
+	refundable := math.Round((paid-committed)*100) / 100
+	if amount == 0 {
+		amount = refundable
+	}
+	amount = math.Round(amount*100) / 100
+	if amount <= 0 || amount > refundable {
+		return "", fmt.Errorf("%w: %.2f requested, %.2f refundable", ErrInvalidRefundAmount, amount, refundable)
+	}
+
+	refundID := utils.GenerateID()
+	_, err = tx.Exec(`
+		INSERT INTO refunds (id, payment_id, order_id, amount, reason, status, requested_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, refundID, paymentID, orderID, amount, reason, RefundStatusRequested, actorID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	// As with charges, the provider is called outside the transaction.
+	var result *payment.Refund
+	provider, err := payment.Get(providerName)
+	if err == nil {
+		result, err = provider.Refund(ctx, providerRef.String, payment.RefundRequest{
+			Reference: refundID,
+			Amount:    amount,
+			Reason:    reason,
+		})
+	}
+	if err != nil {
+		if ferr := ApplyRefundStatus(refundID, RefundStatusFailed, "", err.Error()); ferr != nil {
+			return "", ferr
+		}
+		return refundID, fmt.Errorf("%w: %v", ErrPaymentProvider, err)
+	}
+
+	return refundID, ApplyRefundStatus(refundID, string(result.Status), result.ProviderRef, "")
+}
+
+// ApplyRefundStatus records the provider's answer for a refund. When a
+// refund succeeds the order's refunded amount grows, and once everything
+// paid has been returned the order moves to refunded.
+func ApplyRefundStatus(refundID, status, providerRef, failureReason string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var orderID, current, requestedBy string
+	var amount float64
+	err = tx.QueryRow(`
+		SELECT order_id, status, amount, requested_by FROM refunds WHERE id = ? FOR UPDATE
+	`, refundID).Scan(&orderID, &current, &amount, &requestedBy)
+	if err == sql.ErrNoRows {
+		return ErrRefundNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if current == status {
+		return nil
+	}
+	if current == RefundStatusSucceeded || current == RefundStatusFailed {
+		return fmt.Errorf("%w: refund is %s", ErrRefundFinished, current)
+	}
+
+	switch status {
+	case RefundStatusProcessing, RefundStatusSucceeded, RefundStatusFailed:
+	default:
+		return fmt.Errorf("unknown refund status %q", status)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE refunds SET status = ?, provider_ref = COALESCE(?, provider_ref), failure_reason = ? WHERE id = ?
+	`, status, nullString(providerRef), nullString(failureReason), refundID)
+	if err != nil {
+		return err
+	}
+
+	if status == RefundStatusSucceeded {
+		if err := settleRefund(tx, orderID, refundID, amount, requestedBy); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// settleRefund adds a succeeded refund to the order, takes the sellers'
+// share of it back and moves the order to refunded once everything paid
+// has been returned.
+func settleRefund(tx *sql.Tx, orderID, refundID string, amount float64, actorID string) error {
+	var paid, refunded float64
+	err := tx.QueryRow("SELECT paid_amount, refunded_amount FROM orders WHERE id = ? FOR UPDATE", orderID).Scan(&paid, &refunded)
+	if err != nil {
+		return err
+	}
+
+	refunded = math.Round((refunded+amount)*100) / 100
+	if _, err := tx.Exec("UPDATE orders SET refunded_amount = ? WHERE id = ?", refunded, orderID); err != nil {
+		return err
+	}
+	if err := reverseRefundShare(tx, orderID, refundID, amount, paid); err != nil {
+		return err
+	}
+
+	if refunded < paid {
+		return nil
+	}
+
+	err = TransitionOrder(tx, orderID, OrderStatusRefunded, ActorAdmin, actorID, "Payment fully refunded")
+	if errors.Is(err, ErrInvalidTransition) {
+		// e.g. a pending order that received a stray payment; the amounts
+		// are still recorded.
+		log.Printf("refund: order %s not moved to %s: %v", orderID, OrderStatusRefunded, err)
+		return nil
+	}
+	return err
+}
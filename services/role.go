@@ -0,0 +1,253 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+var (
+	ErrRoleNotFound      = errors.New("role not found")
+	ErrRoleNameTaken     = errors.New("role name already exists")
+	ErrRoleInUse         = errors.New("role is still assigned to users")
+	ErrUnknownPermission = errors.New("unknown permission")
+	ErrLastRoleManager   = errors.New("at least one active role must keep the manage_roles permission")
+	ErrLastUserManager   = errors.New("at least one active user must keep the manage_roles permission")
+)
+
+// CreateRole adds an active role with the given permissions.
+func CreateRole(name, description string, permissions []string) (int, error) {
+	if err := checkPermissions(permissions); err != nil {
+		return 0, err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO roles (name, description, is_active) VALUES (?, ?, TRUE)", name, description)
+	if isDuplicateKey(err) {
+		return 0, ErrRoleNameTaken
+	}
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, perm := range permissions {
+		if _, err := tx.Exec("INSERT IGNORE INTO role_permissions (role_id, permission) VALUES (?, ?)", id, perm); err != nil {
+			return 0, err
+		}
+	}
+
+	return int(id), tx.Commit()
+}
+
+// UpdateRole renames a role or changes its description.
+func UpdateRole(roleID int, name, description string) error {
+	return changeRole(roleID, func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE roles SET name = ?, description = ? WHERE id = ?", name, description, roleID)
+		if isDuplicateKey(err) {
+			return ErrRoleNameTaken
+		}
+		return err
+	})
+}
+
+// SetRoleActive activates or deactivates a role. Deactivating the last
+// active role that can manage roles is refused.
+func SetRoleActive(roleID int, active bool) error {
+	return changeRole(roleID, func(tx *sql.Tx) error {
+		if !active {
+			if err := ensureRoleManagerRemains(tx, roleID, ""); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec("UPDATE roles SET is_active = ? WHERE id = ?", active, roleID)
+		return err
+	})
+}
+
+// DeleteRole removes a role that no user holds any more.
+func DeleteRole(roleID int) error {
+	return changeRole(roleID, func(tx *sql.Tx) error {
+		var users int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE role_id = ?", roleID).Scan(&users); err != nil {
+			return err
+		}
+		if users > 0 {
+			return fmt.Errorf("%w: %d users", ErrRoleInUse, users)
+		}
+		if err := ensureRoleManagerRemains(tx, roleID, ""); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM roles WHERE id = ?", roleID)
+		return err
+	})
+}
+
+// GrantPermission attaches a registered permission to a role.
+func GrantPermission(roleID int, permission string) error {
+	if err := checkPermissions([]string{permission}); err != nil {
+		return err
+	}
+	return changeRole(roleID, func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT IGNORE INTO role_permissions (role_id, permission) VALUES (?, ?)", roleID, permission)
+		return err
+	})
+}
+
+// RevokePermission detaches a permission from a role.
+func RevokePermission(roleID int, permission string) error {
+	return changeRole(roleID, func(tx *sql.Tx) error {
+		if err := ensureRoleManagerRemains(tx, roleID, permission); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM role_permissions WHERE role_id = ? AND permission = ?", roleID, permission)
+		return err
+	})
+}
+
+// changeRole runs change in a transaction with the role row locked and
+// clears the cached permissions of the role afterwards.
+func changeRole(roleID int, change func(tx *sql.Tx) error) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := roleExists(tx, roleID); err != nil {
+		return err
+	}
+	if err := change(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	InvalidateRole(roleID)
+	return nil
+}
+
+func roleExists(tx *sql.Tx, roleID int) error {
+	var id int
+	err := tx.QueryRow("SELECT id FROM roles WHERE id = ? FOR UPDATE", roleID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrRoleNotFound
+	}
+	return err
+}
+
+// ensureRoleManagerRemains refuses a change to roleID that would leave no
+// active role holding manage_roles. An empty permission means the whole
+// role goes away (deactivated or deleted); otherwise only that permission
+// is removed. The manage_roles holders are locked so that two concurrent
+// changes cannot each remove a different last holder.
+func ensureRoleManagerRemains(tx *sql.Tx, roleID int, permission string) error {
+	if permission != "" && permission != utils.PermManageRoles {
+		return nil
+	}
+
+	rows, err := tx.Query(`
+		SELECT r.id FROM roles r
+		JOIN role_permissions rp ON rp.role_id = r.id
+		WHERE rp.permission = ? AND r.is_active = TRUE
+		FOR UPDATE
+	`, utils.PermManageRoles)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	holders := 0
+	affected := false
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		holders++
+		if id == roleID {
+			affected = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if affected && holders <= 1 {
+		return ErrLastRoleManager
+	}
+	return nil
+}
+
+// ensureUserRoleManagerRemains refuses to move userID to newRoleID when
+// that would leave no active user holding manage_roles through an active
+// role. A newRoleID of 0 means the user loses every permission, e.g. when
+// the account is erased. Like ensureRoleManagerRemains, it locks the
+// holders so that concurrent changes cannot each remove a different last
+// one.
+func ensureUserRoleManagerRemains(tx *sql.Tx, userID string, newRoleID int) error {
+	rows, err := tx.Query(`
+		SELECT u.id FROM users u
+		JOIN roles r ON r.id = u.role_id
+		JOIN role_permissions rp ON rp.role_id = r.id
+		WHERE rp.permission = ? AND r.is_active = TRUE AND u.is_active = TRUE AND u.erased_at IS NULL
+		FOR UPDATE
+	`, utils.PermManageRoles)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	holders := 0
+	affected := false
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		holders++
+		if id == userID {
+			affected = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if !affected || holders > 1 {
+		return nil
+	}
+
+	var keeps bool
+	if err := tx.QueryRow(`
+		SELECT COUNT(*) > 0 FROM roles r
+		JOIN role_permissions rp ON rp.role_id = r.id
+		WHERE r.id = ? AND r.is_active = TRUE AND rp.permission = ?
+	`, newRoleID, utils.PermManageRoles).Scan(&keeps); err != nil {
+		return err
+	}
+	if !keeps {
+		return ErrLastUserManager
+	}
+	return nil
+}
+
+func checkPermissions(permissions []string) error {
+	for _, perm := range permissions {
+		if !utils.IsKnownPermission(perm) {
+			return fmt.Errorf("%w: %s", ErrUnknownPermission, perm)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/emyu/ecommer-be/config"
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// TokenPair is handed to the client on login and on every refresh.
+type TokenPair struct {
+	AccessToken      string    `json:"token"`
+	AccessExpiresAt  time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// StartSession opens a new session for a user who just proved who they are
+// and returns its first token pair.
+func StartSession(userID, userAgent, ipAddress string) (*TokenPair, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	pair, err := issueTokens(tx, userID, utils.GenerateID(), userAgent, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+	return pair, tx.Commit()
+}
+
+// RefreshSession swaps a refresh token for a new token pair. Each refresh
+// token works once: presenting one that was already rotated means it has
+// been copied, so the whole session is revoked.
+func RefreshSession(refreshToken, userAgent, ipAddress string) (*TokenPair, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id, familyID, userID string
+	var expired, userActive bool
+	var rotatedAt, revokedAt sql.NullTime
+	err = tx.QueryRow(`
+		SELECT s.id, s.family_id, s.user_id, s.expires_at < NOW(), s.rotated_at, s.revoked_at, u.is_active
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.refresh_token_hash = ? FOR UPDATE
+	`, utils.HashToken(refreshToken)).Scan(&id, &familyID, &userID, &expired, &rotatedAt, &revokedAt, &userActive)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if revokedAt.Valid || expired || !userActive {
+		return nil, ErrInvalidRefreshToken
+	}
+	if rotatedAt.Valid {
+		if err := revokeFamily(tx, familyID, "refresh token reused"); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		log.Printf("session %s: refresh token reused, session revoked", familyID)
+		return nil, ErrRefreshTokenReused
+	}
+
+	if _, err := tx.Exec("UPDATE sessions SET rotated_at = NOW() WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+
+	pair, err := issueTokens(tx, userID, familyID, userAgent, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+	return pair, tx.Commit()
+}
+
+// RevokeSession ends a session; its access tokens stop working at once.
+func RevokeSession(sessionID, reason string) error {
+	_, err := database.DB.Exec(`
+		UPDATE sessions SET revoked_at = NOW(), revoked_reason = ? WHERE family_id = ? AND revoked_at IS NULL
+	`, reason, sessionID)
+	return err
+}
+
+// RevokeUserSessions ends every session of a user.
+func RevokeUserSessions(userID, reason string) error {
+	return revokeUserSessions(database.DB, userID, reason)
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func revokeUserSessions(db execer, userID, reason string) error {
+	_, err := db.Exec(`
+		UPDATE sessions SET revoked_at = NOW(), revoked_reason = ? WHERE user_id = ? AND revoked_at IS NULL
+	`, reason, userID)
+	return err
+}
+
+// revokeOtherSessions ends every session of a user except keepSessionID,
+// the one making the request.
+func revokeOtherSessions(db execer, userID, keepSessionID, reason string) error {
+	_, err := db.Exec(`
+		UPDATE sessions SET revoked_at = NOW(), revoked_reason = ?
+		WHERE user_id = ? AND family_id <> ? AND revoked_at IS NULL
+	`, reason, userID, keepSessionID)
+	return err
+}
+
+// RevokeAccessToken puts a single access token on the denylist until it
+// would have expired anyway.
+func RevokeAccessToken(jti string, expiresAt time.Time) error {
+	_, err := database.DB.Exec(
+		"INSERT IGNORE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)",
+		jti, expiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Entries are useless once the token has expired; keep the table small.
+	_, err = database.DB.Exec("DELETE FROM revoked_tokens WHERE expires_at < NOW()")
+	return err
+}
+
+// IsAccessRevoked reports whether an access token was revoked, either on
+// its own or together with its session.
+func IsAccessRevoked(jti, sessionID string) (bool, error) {
+	var denied, active int
+	err := database.DB.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?),
+			(SELECT COUNT(*) FROM sessions WHERE family_id = ? AND revoked_at IS NULL)
+	`, jti, sessionID).Scan(&denied, &active)
+	if err != nil {
+		return false, err
+	}
+	return denied > 0 || active == 0, nil
+}
+
+func revokeFamily(tx *sql.Tx, familyID, reason string) error {
+	_, err := tx.Exec(`
+		UPDATE sessions SET revoked_at = NOW(), revoked_reason = ? WHERE family_id = ? AND revoked_at IS NULL
+	`, reason, familyID)
+	return err
+}
+
+func issueTokens(tx *sql.Tx, userID, familyID, userAgent, ipAddress string) (*TokenPair, error) {
+	refreshToken := utils.GenerateSecureToken(32)
+	refreshTTL := config.AppConfig.RefreshTokenTTL
+	_, err := tx.Exec(`
+		INSERT INTO sessions (id, family_id, user_id, refresh_token_hash, user_agent, ip_address, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, NOW() + INTERVAL ? SECOND)
+	`, utils.GenerateID(), familyID, userID, utils.HashToken(refreshToken), truncate(userAgent, 255), ipAddress, int(refreshTTL.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, accessExpiresAt, err := utils.GenerateToken(userID, familyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  accessExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: time.Now().Add(refreshTTL),
+	}, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,358 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/emyu/ecommer-be/config"
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotSetUp       = errors.New("start two-factor setup first")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+	ErrTwoFactorRequired       = errors.New("two-factor authentication is required for this account")
+)
+
+// twoFactorPolicyPermissions are the permissions whose holders must use
+// two-factor authentication when TWO_FACTOR_ENFORCED is on.
+var twoFactorPolicyPermissions = []string{utils.PermManageUsers, utils.PermManageRoles}
+
+// TwoFactorStatus describes a user's two-factor enrolment.
+type TwoFactorStatus struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabled_at"`
+	Required               bool       `json:"required"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+}
+
+// TwoFactorRequired reports whether the policy makes 2FA mandatory for a
+// holder of these permissions.
+func TwoFactorRequired(perms []string) bool {
+	if !config.AppConfig.TwoFactorEnforced {
+		return false
+	}
+	for _, p := range twoFactorPolicyPermissions {
+		if utils.HasPermission(perms, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTwoFactorStatus returns the enrolment state of a user.
+func GetTwoFactorStatus(userID string, perms []string) (*TwoFactorStatus, error) {
+	status := &TwoFactorStatus{Required: TwoFactorRequired(perms)}
+	err := database.DB.QueryRow(`
+		SELECT totp_enabled_at,
+		       (SELECT COUNT(*) FROM two_factor_recovery_codes WHERE user_id = users.id AND used_at IS NULL)
+		FROM users WHERE id = ?
+	`, userID).Scan(&status.EnabledAt, &status.RecoveryCodesRemaining)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	status.Enabled = status.EnabledAt != nil
+	return status, nil
+}
+
+// BeginTwoFactorSetup stores a new pending secret and returns it with its
+// provisioning URI. 2FA is not active until EnableTwoFactor confirms a code.
+func BeginTwoFactorSetup(userID string) (secret, uri string, err error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	var email string
+	var enabledAt sql.NullTime
+	err = tx.QueryRow("SELECT email, totp_enabled_at FROM users WHERE id = ? FOR UPDATE", userID).Scan(&email, &enabledAt)
+	if err == sql.ErrNoRows {
+		return "", "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if enabledAt.Valid {
+		return "", "", ErrTwoFactorAlreadyEnabled
+	}
+
+	secret = utils.GenerateTOTPSecret()
+	if _, err := tx.Exec("UPDATE users SET totp_secret = ?, totp_last_step = NULL WHERE id = ?", secret, userID); err != nil {
+		return "", "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", "", err
+	}
+	return secret, utils.TOTPProvisioningURI(twoFactorIssuer(), email, secret), nil
+}
+
+// PendingTwoFactorURI returns the provisioning URI of a setup that has not
+// been confirmed yet. Once 2FA is enabled the secret is never shown again.
+func PendingTwoFactorURI(userID string) (string, error) {
+	var email string
+	var secret sql.NullString
+	var enabledAt sql.NullTime
+	err := database.DB.QueryRow(
+		"SELECT email, totp_secret, totp_enabled_at FROM users WHERE id = ?", userID,
+	).Scan(&email, &secret, &enabledAt)
+	if err == sql.ErrNoRows {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if enabledAt.Valid {
+		return "", ErrTwoFactorAlreadyEnabled
+	}
+	if !secret.Valid {
+		return "", ErrTwoFactorNotSetUp
+	}
+	return utils.TOTPProvisioningURI(twoFactorIssuer(), email, secret.String), nil
+}
+
+// EnableTwoFactor turns 2FA on once the user proves their app produces
+// valid codes, and returns a fresh set of recovery codes. Every other
+// session of the user is revoked, since those were opened with a password
+// alone.
+func EnableTwoFactor(userID, code, currentSessionID string) ([]string, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var secret sql.NullString
+	var enabledAt sql.NullTime
+	err = tx.QueryRow(
+		"SELECT totp_secret, totp_enabled_at FROM users WHERE id = ? FOR UPDATE", userID,
+	).Scan(&secret, &enabledAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enabledAt.Valid {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if !secret.Valid {
+		return nil, ErrTwoFactorNotSetUp
+	}
+
+	step, ok := utils.MatchTOTP(secret.String, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE users SET totp_enabled_at = NOW(), totp_last_step = ? WHERE id = ?", step, userID,
+	); err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := revokeOtherSessions(tx, userID, currentSessionID, "two-factor enabled"); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	InvalidateUser(userID)
+	return codes, nil
+}
+
+// DisableTwoFactor turns 2FA off after checking a current code or a
+// recovery code. Users the policy applies to cannot turn it off.
+func DisableTwoFactor(userID, code string, perms []string, ipAddress, userAgent string) error {
+	if TwoFactorRequired(perms) {
+		return ErrTwoFactorRequired
+	}
+	return verifySecondFactorThrottled(userID, ipAddress, userAgent, func() error {
+		return disableTwoFactor(userID, code)
+	})
+}
+
+func disableTwoFactor(userID, code string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := verifySecondFactor(tx, userID, code); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL WHERE id = ?", userID,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM two_factor_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	InvalidateUser(userID)
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of a user after
+// checking a current code. Old codes stop working.
+func RegenerateRecoveryCodes(userID, code, ipAddress, userAgent string) (codes []string, err error) {
+	err = verifySecondFactorThrottled(userID, ipAddress, userAgent, func() error {
+		codes, err = regenerateRecoveryCodes(userID, code)
+		return err
+	})
+	return codes, err
+}
+
+func regenerateRecoveryCodes(userID, code string) ([]string, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := verifySecondFactor(tx, userID, code); err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// CompleteTwoFactorLogin checks the second factor of a login and opens the
+// session. code may be a TOTP code or an unused recovery code.
+func CompleteTwoFactorLogin(userID, code, userAgent, ipAddress string) (*TokenPair, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var active bool
+	err = tx.QueryRow("SELECT is_active FROM users WHERE id = ?", userID).Scan(&active)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, ErrUserInactive
+	}
+
+	if err := verifySecondFactor(tx, userID, code); err != nil {
+		return nil, err
+	}
+
+	pair, err := issueTokens(tx, userID, utils.GenerateID(), userAgent, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+	return pair, tx.Commit()
+}
+
+// verifySecondFactor accepts a TOTP code newer than the last one used, or
+// consumes a recovery code.
+func verifySecondFactor(tx *sql.Tx, userID, code string) error {
+	var secret sql.NullString
+	var enabledAt sql.NullTime
+	var lastStep sql.NullInt64
+	err := tx.QueryRow(
+		"SELECT totp_secret, totp_enabled_at, totp_last_step FROM users WHERE id = ? FOR UPDATE", userID,
+	).Scan(&secret, &enabledAt, &lastStep)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !enabledAt.Valid || !secret.Valid {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if step, ok := utils.MatchTOTP(secret.String, code, time.Now()); ok {
+		if !totpStepUnused(step, lastStep) {
+			return ErrInvalidTwoFactorCode
+		}
+		_, err := tx.Exec("UPDATE users SET totp_last_step = ? WHERE id = ?", step, userID)
+		return err
+	}
+
+	res, err := tx.Exec(`
+		UPDATE two_factor_recovery_codes SET used_at = NOW()
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`, userID, utils.HashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// totpStepUnused reports whether a matched step may still be accepted. A
+// code may only be used once, even within its window, and once a step has
+// been used the codes of earlier steps stop working too.
+func totpStepUnused(step int64, lastStep sql.NullInt64) bool {
+	return !lastStep.Valid || step > lastStep.Int64
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID string) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM two_factor_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := utils.GenerateSecureToken(5)
+		code := raw[:5] + "-" + raw[5:]
+		_, err := tx.Exec(
+			"INSERT INTO two_factor_recovery_codes (id, user_id, code_hash) VALUES (?, ?, ?)",
+			utils.GenerateID(), userID, utils.HashToken(normalizeRecoveryCode(code)),
+		)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode lets users type recovery codes with or without the
+// dash and in any case.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func twoFactorIssuer() string {
+	if config.AppConfig.TwoFactorIssuer != "" {
+		return config.AppConfig.TwoFactorIssuer
+	}
+	return config.AppConfig.AppName
+}
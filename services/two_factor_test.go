@@ -0,0 +1,31 @@
+package services
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestTOTPStepUnused(t *testing.T) {
+	none := sql.NullInt64{}
+	used := func(step int64) sql.NullInt64 { return sql.NullInt64{Int64: step, Valid: true} }
+
+	cases := []struct {
+		name     string
+		step     int64
+		lastStep sql.NullInt64
+		want     bool
+	}{
+		{"first code ever", 100, none, true},
+		{"newer step", 101, used(100), true},
+		{"same code again", 100, used(100), false},
+		{"older code in the window after a newer one", 99, used(100), false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := totpStepUnused(tc.step, tc.lastStep); got != tc.want {
+				t.Errorf("totpStepUnused(%d, %v) = %v, want %v", tc.step, tc.lastStep, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+var (
+	ErrRoleChangeForbidden = errors.New("changing a user's role requires the manage_roles permission")
+	ErrInvalidSeller       = errors.New("seller must be an active user whose role can manage products")
+)
+
+// CheckSeller returns ErrInvalidSeller unless userID is an active, not
+// erased user whose active role grants manage_products, so that an admin
+// cannot list a product under a customer or an erased account.
+func CheckSeller(userID string) error {
+	var ok bool
+	err := database.DB.QueryRow(`
+		SELECT COUNT(*) > 0 FROM users u
+		JOIN roles r ON r.id = u.role_id
+		JOIN role_permissions rp ON rp.role_id = r.id
+		WHERE u.id = ? AND u.is_active = TRUE AND u.erased_at IS NULL
+		  AND r.is_active = TRUE AND rp.permission = ?
+	`, userID, utils.PermManageProducts).Scan(&ok)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidSeller
+	}
+	return nil
+}
+
+// UserUpdate holds the fields an admin may change on a user. A zero RoleID
+// keeps the current role.
+type UserUpdate struct {
+	Name   string
+	Email  string
+	Phone  string
+	RoleID int
+}
+
+// UpdateUser changes a user's profile and, for callers holding
+// manage_roles, their role. It reports whether the email changed, in which
+// case the caller revokes the user's sessions. A role change that would
+// leave no active user with manage_roles returns ErrLastUserManager.
+func UpdateUser(userID string, u UserUpdate, permissions []string) (emailChanged bool, err error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var currentEmail string
+	var currentRoleID int
+	err = tx.QueryRow("SELECT email, role_id FROM users WHERE id = ? FOR UPDATE", userID).Scan(&currentEmail, &currentRoleID)
+	if err == sql.ErrNoRows {
+		return false, ErrUserNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if u.RoleID == 0 {
+		u.RoleID = currentRoleID
+	}
+	if u.RoleID != currentRoleID {
+		if !utils.HasPermission(permissions, utils.PermManageRoles) {
+			return false, ErrRoleChangeForbidden
+		}
+		if err := roleExists(tx, u.RoleID); err != nil {
+			return false, err
+		}
+		if err := ensureUserRoleManagerRemains(tx, userID, u.RoleID); err != nil {
+			return false, err
+		}
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE users SET name = ?, email = ?, phone = ?, role_id = ? WHERE id = ?
+	`, u.Name, u.Email, u.Phone, u.RoleID, userID); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	// Permissions are resolved per request, so dropping the cached role
+	// assignment is enough for a new role to take effect.
+	InvalidateUser(userID)
+	return u.Email != currentEmail, nil
+}
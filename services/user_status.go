@@ -0,0 +1,97 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/emyu/ecommer-be/database"
+)
+
+var (
+	ErrUserInactive         = errors.New("account is deactivated")
+	ErrCannotDeactivateSelf = errors.New("you cannot deactivate your own account")
+)
+
+// DeactivateUser blocks a user from logging in and ends all of their
+// sessions, so tokens they already hold stop working at once.
+func DeactivateUser(userID, reason, actorID string) error {
+	if userID == actorID {
+		return ErrCannotDeactivateSelf
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE users SET is_active = FALSE, deactivated_at = NOW(), deactivation_reason = ? WHERE id = ?
+	`, reason, userID)
+	if err != nil {
+		return err
+	}
+	if err := requireUserRow(tx, res, userID); err != nil {
+		return err
+	}
+
+	if err := revokeUserSessions(tx, userID, "account deactivated"); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	InvalidateUser(userID)
+	return nil
+}
+
+// ActivateUser lets a deactivated user log in again. Erased accounts stay
+// deactivated for good.
+func ActivateUser(userID string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var erased bool
+	err = tx.QueryRow("SELECT erased_at IS NOT NULL FROM users WHERE id = ? FOR UPDATE", userID).Scan(&erased)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if erased {
+		return ErrUserErased
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE users SET is_active = TRUE, deactivated_at = NULL, deactivation_reason = NULL WHERE id = ?
+	`, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	InvalidateUser(userID)
+	return nil
+}
+
+// requireUserRow returns ErrUserNotFound when an update touched no row
+// because the user does not exist (rather than because nothing changed).
+func requireUserRow(tx *sql.Tx, res sql.Result, userID string) error {
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	var exists bool
+	if err := tx.QueryRow("SELECT COUNT(*) > 0 FROM users WHERE id = ?", userID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUserNotFound
+	}
+	return nil
+}
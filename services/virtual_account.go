@@ -0,0 +1,40 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/emyu/ecommer-be/config"
+	"github.com/emyu/ecommer-be/payment"
+)
+
+// nextVANumber allocates the next virtual account number for a bank. The
+// per-bank counter row is locked by the upsert until the transaction ends,
+// so concurrent payments never receive the same suffix.
+func nextVANumber(tx *sql.Tx, bank string) (string, error) {
+	bank = strings.ToLower(bank)
+	prefix, ok := config.AppConfig.VAPrefixes[bank]
+	if !ok || prefix == "" {
+		return "", fmt.Errorf("no virtual account prefix configured for bank %q", bank)
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO va_sequences (bank, last_value) VALUES (?, 1)
+		ON DUPLICATE KEY UPDATE last_value = LAST_INSERT_ID(last_value + 1)
+	`, bank)
+	if err != nil {
+		return "", err
+	}
+
+	// One affected row means the counter was just created at 1; two means
+	// an existing counter was incremented and LAST_INSERT_ID holds it.
+	seq := int64(1)
+	if n, _ := res.RowsAffected(); n == 2 {
+		if seq, err = res.LastInsertId(); err != nil {
+			return "", err
+		}
+	}
+
+	return payment.FormatVANumber(prefix, seq, config.AppConfig.VANumberLength)
+}
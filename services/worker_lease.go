@@ -0,0 +1,39 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/emyu/ecommer-be/database"
+	"github.com/emyu/ecommer-be/utils"
+)
+
+// workerID identifies this process when it holds a lease.
+var workerID = func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), utils.GenerateSecureToken(4))
+}()
+
+// acquireLease takes or renews the named lease for ttl. Only one API replica
+// holds a lease at a time; others get false until it expires.
+func acquireLease(name string, ttl time.Duration) (bool, error) {
+	// MySQL applies the assignments left to right, so expires_at is only
+	// extended when holder was (or has just become) this worker.
+	_, err := database.DB.Exec(`
+		INSERT INTO worker_leases (name, holder, expires_at)
+		VALUES (?, ?, NOW() + INTERVAL ? SECOND)
+		ON DUPLICATE KEY UPDATE
+			holder = IF(expires_at < NOW() OR holder = VALUES(holder), VALUES(holder), holder),
+			expires_at = IF(holder = VALUES(holder), VALUES(expires_at), expires_at)
+	`, name, workerID, int(ttl.Seconds()))
+	if err != nil {
+		return false, err
+	}
+
+	var holder string
+	if err := database.DB.QueryRow("SELECT holder FROM worker_leases WHERE name = ?", name).Scan(&holder); err != nil {
+		return false, err
+	}
+	return holder == workerID, nil
+}
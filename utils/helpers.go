@@ -5,20 +5,8 @@ import (
 	"math/rand"
 	"strings"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 12)
-	return string(bytes), err
-}
-
-func ComparePassword(hashedPassword, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
-}
-
 func GenerateID() string {
 	b := make([]byte, 8)
 	rand.Seed(time.Now().UnixNano())
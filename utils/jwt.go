@@ -8,37 +8,39 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Claims only identify the user and session. Role and permissions are
+// resolved on every request so that changes apply immediately.
 type Claims struct {
-	ID          string   `json:"id"`
-	Email       string   `json:"email"`
-	RoleID      int      `json:"role_id"`
-	RoleName    string   `json:"role_name"`
-	Permissions []string `json:"permissions"`
+	ID        string `json:"id"`
+	SessionID string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(id, email string, roleID int, roleName string, permissions []string) (string, error) {
+// GenerateToken issues a short-lived access token for a session. Every token
+// gets its own jti so that it can be revoked on its own.
+func GenerateToken(id, sessionID string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(config.AppConfig.AccessTokenTTL)
 	claims := &Claims{
-		ID:          id,
-		Email:       email,
-		RoleID:      roleID,
-		RoleName:    roleName,
-		Permissions: permissions,
+		ID:        id,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        GenerateSecureToken(16),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(config.AppConfig.JWTKey))
+	signed, err := token.SignedString([]byte(config.AppConfig.JWTKey))
+	return signed, expiresAt, err
 }
 
 func ValidateToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		return []byte(config.AppConfig.JWTKey), nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
 
 	if err != nil {
 		return nil, err
@@ -48,5 +50,54 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		return nil, errors.New("invalid token")
 	}
 
+	// Access tokens carry no audience; anything else (such as a login
+	// challenge) must not be accepted as one.
+	if len(claims.Audience) > 0 {
+		return nil, errors.New("invalid token")
+	}
+
 	return claims, nil
 }
+
+// challengeAudience marks tokens that only prove the password step of a
+// two-factor login.
+const challengeAudience = "login-2fa"
+
+// GenerateChallengeToken issues the short-lived token a client exchanges,
+// together with a second factor, for a session.
+func GenerateChallengeToken(userID string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(config.AppConfig.TwoFactorChallengeTTL)
+	claims := &Claims{
+		ID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        GenerateSecureToken(16),
+			Audience:  jwt.ClaimStrings{challengeAudience},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString([]byte(config.AppConfig.JWTKey))
+	return signed, expiresAt, err
+}
+
+// ValidateChallengeToken returns the user ID of a login challenge token.
+func ValidateChallengeToken(tokenString string) (string, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(config.AppConfig.JWTKey), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired(),
+		jwt.WithAudience(challengeAudience))
+
+	if err != nil {
+		return "", err
+	}
+
+	if !token.Valid {
+		return "", errors.New("invalid token")
+	}
+
+	return claims.ID, nil
+}
@@ -0,0 +1,211 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/emyu/ecommer-be/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher creates and checks password hashes of one algorithm.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches a hash this hasher recognizes.
+	Verify(encoded, password string) bool
+	// Recognizes reports whether encoded was made with this algorithm.
+	Recognizes(encoded string) bool
+	// NeedsRehash reports whether encoded was made with other settings than
+	// the hasher's current ones.
+	NeedsRehash(encoded string) bool
+}
+
+// Argon2idHasher hashes passwords with argon2id into PHC strings such as
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>.
+type Argon2idHasher struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+const argon2idPrefix = "$argon2id$"
+
+var (
+	argon2SlotsOnce sync.Once
+	argon2Slots     chan struct{}
+)
+
+// argon2Key derives a key while holding one of ARGON2_MAX_CONCURRENT slots.
+// Every derivation takes Memory KiB, so unbounded parallel logins could
+// exhaust the server's memory; excess requests wait for a slot instead.
+func argon2Key(password, salt []byte, iterations, memory uint32, parallelism uint8, keyLength uint32) []byte {
+	argon2SlotsOnce.Do(func() {
+		argon2Slots = make(chan struct{}, max(config.AppConfig.Argon2MaxConcurrent, 1))
+	})
+	argon2Slots <- struct{}{}
+	defer func() { <-argon2Slots }()
+	return argon2.IDKey(password, salt, iterations, memory, parallelism, keyLength)
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2Key([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h Argon2idHasher) Verify(encoded, password string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false
+	}
+	other := argon2Key([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+func (h Argon2idHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func (h Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory != h.Memory || params.Iterations != h.Iterations || params.Parallelism != h.Parallelism ||
+		uint32(len(salt)) != h.SaltLength || uint32(len(key)) != h.KeyLength
+}
+
+func decodeArgon2id(encoded string) (params Argon2idHasher, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, fmt.Errorf("not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, err
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, err
+	}
+	if params.Iterations == 0 || params.Parallelism == 0 || params.Memory > 4*1024*1024 {
+		return params, nil, nil, fmt.Errorf("invalid argon2 parameters")
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, err
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return params, nil, nil, err
+	}
+	if len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("empty argon2 hash")
+	}
+	return params, salt, key, nil
+}
+
+// BcryptHasher hashes passwords with bcrypt. bcrypt only looks at the first
+// 72 bytes of a password, so it is kept mainly to verify older hashes.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(bytes), err
+}
+
+func (h BcryptHasher) Verify(encoded, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+}
+
+func (h BcryptHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// passwordHashers returns every supported hasher, configured from
+// AppConfig. The one named by PASSWORD_HASHER comes first and hashes new
+// passwords; the others only verify existing hashes.
+func passwordHashers() []PasswordHasher {
+	cfg := config.AppConfig
+	argon := Argon2idHasher{
+		Memory:      uint32(cfg.Argon2Memory),
+		Iterations:  uint32(cfg.Argon2Iterations),
+		Parallelism: uint8(cfg.Argon2Parallelism),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+	bcryptHasher := BcryptHasher{Cost: cfg.BcryptCost}
+
+	if cfg.PasswordHasher == "bcrypt" {
+		return []PasswordHasher{bcryptHasher, argon}
+	}
+	return []PasswordHasher{argon, bcryptHasher}
+}
+
+// HashPassword hashes a new password with the configured hasher.
+func HashPassword(password string) (string, error) {
+	return passwordHashers()[0].Hash(password)
+}
+
+// ComparePassword checks a password against a hash made by any supported
+// hasher.
+func ComparePassword(hashedPassword, password string) bool {
+	for _, h := range passwordHashers() {
+		if h.Recognizes(hashedPassword) {
+			return h.Verify(hashedPassword, password)
+		}
+	}
+	return false
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// PrepareDummyHash computes the hash that logins for unknown emails are
+// checked against. main calls it at startup so that the first such login
+// is not slower than the rest.
+func PrepareDummyHash() {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = HashPassword(GenerateSecureToken(16))
+	})
+}
+
+// CompareDummyPassword takes as long as checking password against a real
+// hash of the configured hasher, so a login for an unknown email cannot be
+// told apart from a wrong password by its response time.
+func CompareDummyPassword(password string) {
+	PrepareDummyHash()
+	ComparePassword(dummyHash, password)
+}
+
+// PasswordNeedsRehash reports whether a hash should be replaced, because it
+// was made with another hasher than the configured one or other settings.
+func PasswordNeedsRehash(hashedPassword string) bool {
+	current := passwordHashers()[0]
+	return !current.Recognizes(hashedPassword) || current.NeedsRehash(hashedPassword)
+}
@@ -0,0 +1,50 @@
+package utils
+
+// Permission strings checked by the API. Roles may only be granted
+// permissions listed in PermissionRegistry.
+const (
+	PermManageProducts      = "manage_products"
+	PermManageAllProducts   = "manage_all_products"
+	PermManageCategories    = "manage_categories"
+	PermManageOrders        = "manage_orders"
+	PermManageUsers         = "manage_users"
+	PermManagePayments      = "manage_payments"
+	PermManageCoupons       = "manage_coupons"
+	PermViewReports         = "view_reports"
+	PermManageRoles         = "manage_roles"
+	PermViewOrders          = "view_orders"
+	PermViewSalesReports    = "view_sales_reports"
+	PermManagePayouts       = "manage_payouts"
+	PermManagePayoutAccount = "manage_payout_account"
+)
+
+type PermissionInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+var PermissionRegistry = []PermissionInfo{
+	{PermManageProducts, "Create, update and delete own products and adjust their stock"},
+	{PermManageAllProducts, "Manage products of every seller and see every seller's orders"},
+	{PermManageCategories, "Create, update and delete categories"},
+	{PermManageOrders, "View all orders and change their status"},
+	{PermManageUsers, "View, update and delete users"},
+	{PermManagePayments, "Import bank mutations and refund payments"},
+	{PermManageCoupons, "Create, update and delete coupons"},
+	{PermViewReports, "View store-wide reports and user statistics"},
+	{PermManageRoles, "Create roles and grant or revoke permissions"},
+	{PermViewOrders, "View orders containing the seller's products"},
+	{PermViewSalesReports, "View the seller's sales report"},
+	{PermManagePayouts, "View seller balances and create, export and settle payout batches"},
+	{PermManagePayoutAccount, "Set the bank account the seller's payouts go to"},
+}
+
+// IsKnownPermission reports whether p is in PermissionRegistry.
+func IsKnownPermission(p string) bool {
+	for _, info := range PermissionRegistry {
+		if info.Name == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors, the ASCII
+// string "12345678901234567890", base32 encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	// RFC 6238 appendix B lists 8-digit codes; a 6-digit code is the same
+	// value modulo 10^6, i.e. its last six digits.
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, v := range vectors {
+		got, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode at %d: %v", v.unix, err)
+		}
+		if got != v.code {
+			t.Errorf("TOTPCode at %d = %s, want %s", v.unix, got, v.code)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := TOTPStep(now)
+	code := func(s int64) string {
+		c, err := TOTPCode(rfc6238Secret, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	cases := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", code(step), step, true},
+		{"previous step within skew", code(step - 1), step - 1, true},
+		{"next step within skew", code(step + 1), step + 1, true},
+		{"too old", code(step - 2), 0, false},
+		{"too new", code(step + 2), 0, false},
+		{"surrounding spaces", " " + code(step) + " ", step, true},
+		{"wrong length", code(step)[:5], 0, false},
+		{"empty", "", 0, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			gotStep, ok := MatchTOTP(rfc6238Secret, tc.code, now)
+			if ok != tc.wantOK || gotStep != tc.wantStep {
+				t.Errorf("MatchTOTP(%q) = %d, %v, want %d, %v", tc.code, gotStep, ok, tc.wantStep, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestMatchTOTPLowercaseSecret(t *testing.T) {
+	now := time.Unix(59, 0)
+	if _, ok := MatchTOTP("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "287082", now); !ok {
+		t.Error("lowercase secret was not accepted")
+	}
+}